@@ -1,7 +1,6 @@
 package attach
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -14,8 +13,9 @@ import (
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/coder/agentapi/lib/httpapi"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
 	"github.com/spf13/cobra"
-	sse "github.com/tmaxmax/go-sse"
 	"golang.org/x/term"
 	"golang.org/x/xerrors"
 )
@@ -72,66 +72,89 @@ func (m model) View() string {
 	return m.screen
 }
 
-func ReadScreenOverHTTP(ctx context.Context, url string, ch chan<- httpapi.ScreenUpdateBody) error {
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
+// DialWebSocket connects to the WebSocket endpoint of the server at url,
+// subscribing to screen updates only. After the first update, the server
+// only sends the rows of the screen that changed.
+func DialWebSocket(ctx context.Context, url string, authToken string) (*websocket.Conn, error) {
+	header := http.Header{}
+	if authToken != "" {
+		header.Set("Authorization", "Bearer "+authToken)
+	}
+	conn, res, err := websocket.Dial(ctx, url+"?types="+string(httpapi.EventTypeScreenUpdate)+"&screen_mode="+string(httpapi.ScreenModeDiff), &websocket.DialOptions{
+		HTTPHeader: header,
+	})
 	if err != nil {
-		return xerrors.Errorf("failed to do request: %w", err)
+		if res != nil {
+			return nil, xerrors.Errorf("failed to connect: %w", errors.New(res.Status))
+		}
+		return nil, xerrors.Errorf("failed to connect: %w", err)
 	}
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	// 1MB: the first update contains the whole screen, which can be big.
+	// A terminal of 80x1000 can be over 80000 bytes.
+	conn.SetReadLimit(1 << 20)
+	return conn, nil
+}
 
-	for ev, err := range sse.Read(res.Body, &sse.ReadConfig{
-		// 256KB: screen can be big. The default terminal size is 80x1000,
-		// which can be over 80000 bytes.
-		MaxEventSize: 256 * 1024,
-	}) {
-		if err != nil {
-			return xerrors.Errorf("failed to read sse: %w", err)
+func ReadScreenOverWebSocket(ctx context.Context, conn *websocket.Conn, ch chan<- httpapi.ScreenUpdateBody) error {
+	var rows []string
+	for {
+		var event struct {
+			Type httpapi.EventType `json:"type"`
+			Data json.RawMessage   `json:"data"`
 		}
-		var screen httpapi.ScreenUpdateBody
-		if err := json.Unmarshal([]byte(ev.Data), &screen); err != nil {
-			return xerrors.Errorf("failed to unmarshal screen: %w", err)
+		if err := wsjson.Read(ctx, conn, &event); err != nil {
+			if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+				return nil
+			}
+			return xerrors.Errorf("failed to read event: %w", err)
 		}
-		ch <- screen
+		switch event.Type {
+		case httpapi.EventTypeScreenUpdate:
+			var screen httpapi.ScreenUpdateBody
+			if err := json.Unmarshal(event.Data, &screen); err != nil {
+				return xerrors.Errorf("failed to unmarshal screen: %w", err)
+			}
+			rows = strings.Split(screen.Screen, "\n")
+		case httpapi.EventTypeScreenPatch:
+			var patch httpapi.ScreenPatchBody
+			if err := json.Unmarshal(event.Data, &patch); err != nil {
+				return xerrors.Errorf("failed to unmarshal screen patch: %w", err)
+			}
+			rows = httpapi.ApplyScreenPatch(rows, patch)
+		default:
+			continue
+		}
+		ch <- httpapi.ScreenUpdateBody{Screen: strings.Join(rows, "\n")}
 	}
-	return nil
 }
 
-func WriteRawInputOverHTTP(ctx context.Context, url string, msg string) error {
-	messageRequest := httpapi.MessageRequestBody{
-		Type:    httpapi.MessageTypeRaw,
+func WriteRawInputOverWebSocket(ctx context.Context, conn *websocket.Conn, msg string) error {
+	if err := wsjson.Write(ctx, conn, httpapi.WebSocketInput{
+		Type:    httpapi.WebSocketInputTypeRaw,
 		Content: msg,
+	}); err != nil {
+		return xerrors.Errorf("failed to write input: %w", err)
 	}
-	messageRequestBytes, err := json.Marshal(messageRequest)
-	if err != nil {
-		return xerrors.Errorf("failed to marshal message request: %w", err)
-	}
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(messageRequestBytes))
-	req.Header.Set("Content-Type", "application/json")
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return xerrors.Errorf("failed to do request: %w", err)
-	}
-	defer func() {
-		_ = res.Body.Close()
-	}()
-	if res.StatusCode != http.StatusOK {
-		return xerrors.Errorf("failed to write raw input: %w", errors.New(res.Status))
-	}
-
 	return nil
 }
 
-func runAttach(remoteUrl string) error {
+func runAttach(remoteUrl string, authToken string, resize bool) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	stdin := int(os.Stdin.Fd())
 
+	conn, err := DialWebSocket(ctx, remoteUrl+"/ws", authToken)
+	if err != nil {
+		return xerrors.Errorf("failed to attach: %w", err)
+	}
+	defer func() {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}()
+
+	if resize {
+		syncTerminalWidth(ctx, remoteUrl+"/terminal/size", authToken)
+	}
+
 	oldState, err := term.MakeRaw(stdin)
 	if err != nil {
 		return xerrors.Errorf("failed to make raw: %w", err)
@@ -150,7 +173,7 @@ func runAttach(remoteUrl string) error {
 	readScreenErrCh := make(chan error, 1)
 	go func() {
 		defer close(readScreenErrCh)
-		if err := ReadScreenOverHTTP(ctx, remoteUrl+"/internal/screen", screenCh); err != nil {
+		if err := ReadScreenOverWebSocket(ctx, conn, screenCh); err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
@@ -173,7 +196,7 @@ func runAttach(remoteUrl string) error {
 				if input == "\x03" {
 					continue
 				}
-				if err := WriteRawInputOverHTTP(ctx, remoteUrl+"/message", input); err != nil {
+				if err := WriteRawInputOverWebSocket(ctx, conn, input); err != nil {
 					writeRawInputErrCh <- xerrors.Errorf("failed to write raw input: %w", err)
 					return
 				}
@@ -219,7 +242,11 @@ func runAttach(remoteUrl string) error {
 	return err
 }
 
-var remoteUrlArg string
+var (
+	remoteUrlArg string
+	authTokenArg string
+	resizeArg    bool
+)
 
 var AttachCmd = &cobra.Command{
 	Use:   "attach",
@@ -235,7 +262,11 @@ var AttachCmd = &cobra.Command{
 			remoteUrl = "http://" + remoteUrl
 		}
 		remoteUrl = strings.TrimRight(remoteUrl, "/")
-		if err := runAttach(remoteUrl); err != nil {
+		authToken := authTokenArg
+		if authToken == "" {
+			authToken = os.Getenv("AGENTAPI_AUTH_TOKEN")
+		}
+		if err := runAttach(remoteUrl, authToken, resizeArg); err != nil {
 			fmt.Fprintf(os.Stderr, "Attach failed: %+v\n", err)
 			os.Exit(1)
 		}
@@ -244,4 +275,6 @@ var AttachCmd = &cobra.Command{
 
 func init() {
 	AttachCmd.Flags().StringVarP(&remoteUrlArg, "url", "u", "localhost:3284", "URL of the agentapi server to attach to. May optionally include a protocol and a path.")
+	AttachCmd.Flags().StringVar(&authTokenArg, "auth-token", "", "Bearer token for servers started with --auth-token. Defaults to the AGENTAPI_AUTH_TOKEN env var")
+	AttachCmd.Flags().BoolVar(&resizeArg, "resize", false, "Resize the agent's terminal to the width of this terminal, and keep it in sync when this terminal is resized. The agent's terminal is shared by all clients, so they all see the new width")
 }
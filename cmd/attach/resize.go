@@ -0,0 +1,77 @@
+package attach
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/term"
+	"golang.org/x/xerrors"
+)
+
+// ResizeTerminal sets the width of the agent's terminal with POST /terminal/size.
+// Only the width is sent, so the terminal keeps its height.
+func ResizeTerminal(ctx context.Context, url string, authToken string, width int) error {
+	// The server rejects widths outside this range.
+	width = min(max(width, 10), 1000)
+	body, err := json.Marshal(map[string]int{"width": width})
+	if err != nil {
+		return xerrors.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to resize terminal: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return xerrors.Errorf("failed to resize terminal: %s", res.Status)
+	}
+	return nil
+}
+
+// syncTerminalWidth resizes the agent's terminal to the width of the local
+// terminal, and again whenever the local terminal is resized, until ctx is canceled.
+// The first resize is done before it returns.
+func syncTerminalWidth(ctx context.Context, url string, authToken string) {
+	fd := int(os.Stdout.Fd())
+	resize := func() error {
+		width, _, err := term.GetSize(fd)
+		if err != nil {
+			return xerrors.Errorf("failed to get terminal size: %w", err)
+		}
+		return ResizeTerminal(ctx, url, authToken, width)
+	}
+	if err := resize(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the agent's terminal won't follow the size of this terminal: %v\n", err)
+		return
+	}
+
+	resizeCh := make(chan os.Signal, 1)
+	notifyResize(resizeCh)
+	go func() {
+		defer stopNotifyResize(resizeCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-resizeCh:
+				// Errors can't be reported while the screen is displayed. The
+				// next resize is tried anyway.
+				_ = resize()
+			}
+		}
+	}()
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package attach
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyResize(ch chan<- os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
+
+func stopNotifyResize(ch chan<- os.Signal) {
+	signal.Stop(ch)
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package attach
+
+import "os"
+
+// Windows has no signal for terminal resizes, so the width is only synced
+// when attaching.
+func notifyResize(ch chan<- os.Signal) {}
+
+func stopNotifyResize(ch chan<- os.Signal) {}
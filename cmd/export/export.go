@@ -0,0 +1,89 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+func runExport(remoteUrl string, authToken string, format string, output string) error {
+	req, err := http.NewRequest(http.MethodGet, remoteUrl+"/export?format="+url.QueryEscape(format), nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	if authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+authToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return xerrors.Errorf("server responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return xerrors.Errorf("failed to create output file: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		w = f
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return xerrors.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+var (
+	remoteUrlArg string
+	authTokenArg string
+	formatArg    string
+	outputArg    string
+)
+
+var ExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the conversation of a running agent",
+	Long:  `Export the conversation of a running agent as Markdown, HTML, or JSON`,
+	Run: func(cmd *cobra.Command, args []string) {
+		remoteUrl := remoteUrlArg
+		if remoteUrl == "" {
+			fmt.Fprintln(os.Stderr, "URL is required")
+			os.Exit(1)
+		}
+		if !strings.HasPrefix(remoteUrl, "http") {
+			remoteUrl = "http://" + remoteUrl
+		}
+		remoteUrl = strings.TrimRight(remoteUrl, "/")
+		authToken := authTokenArg
+		if authToken == "" {
+			authToken = os.Getenv("AGENTAPI_AUTH_TOKEN")
+		}
+		if err := runExport(remoteUrl, authToken, formatArg, outputArg); err != nil {
+			fmt.Fprintf(os.Stderr, "Export failed: %+v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	ExportCmd.Flags().StringVarP(&remoteUrlArg, "url", "u", "localhost:3284", "URL of the agentapi server to export from. May optionally include a protocol and a path, e.g. /sessions/<id> to export another session.")
+	ExportCmd.Flags().StringVar(&authTokenArg, "auth-token", "", "Bearer token for servers started with --auth-token. Defaults to the AGENTAPI_AUTH_TOKEN env var")
+	ExportCmd.Flags().StringVarP(&formatArg, "format", "f", "md", "Format of the export: md, html, or json")
+	ExportCmd.Flags().StringVarP(&outputArg, "output", "o", "", "File to write the export to. Defaults to stdout")
+}
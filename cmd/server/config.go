@@ -0,0 +1,134 @@
+package server
+
+import (
+	"maps"
+	"math"
+	"os"
+	"slices"
+
+	"github.com/spf13/viper"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/coder/agentapi/lib/httpapi"
+)
+
+// configKeyCommand is the key of the agent command and its arguments in the config file.
+const configKeyCommand = "command"
+
+// loadConfigFile reads server options from a YAML file and merges them into
+// viper, where they take precedence over the defaults but not over flags and
+// env vars. It returns the agent command from the file, or nil if it has none.
+func loadConfigFile(path string, specs []flagSpec) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read config file: %w", err)
+	}
+	settings, command, err := parseConfig(data, specs)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return nil, xerrors.Errorf("failed to apply config file %s: %w", path, err)
+	}
+	return command, nil
+}
+
+// parseConfig parses a config file, whose keys are the names of the flags in
+// specs and configKeyCommand. Values must have the type of their flag.
+func parseConfig(data []byte, specs []flagSpec) (map[string]any, []string, error) {
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, nil, xerrors.Errorf("failed to parse config: %w", err)
+	}
+	flagTypes := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		flagTypes[spec.name] = spec.flagType
+	}
+
+	settings := make(map[string]any, len(values))
+	var command []string
+	// The keys are sorted so that the same error is reported every time.
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		value := values[key]
+		if key == configKeyCommand {
+			list, err := configStringList(value)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("%s: %w", key, err)
+			}
+			if len(list) == 0 {
+				return nil, nil, xerrors.Errorf("%s must not be empty", key)
+			}
+			command = list
+			continue
+		}
+		flagType, ok := flagTypes[key]
+		// A config file can't point at another one.
+		if !ok || key == FlagConfig {
+			return nil, nil, xerrors.Errorf("unknown option %q", key)
+		}
+		v, err := configValue(flagType, value)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("%s: %w", key, err)
+		}
+		settings[key] = v
+	}
+
+	if hosts, ok := settings[FlagAllowedHosts]; ok {
+		if _, err := httpapi.ParseAllowedHosts(hosts.([]string)); err != nil {
+			return nil, nil, xerrors.Errorf("%s: %w", FlagAllowedHosts, err)
+		}
+	}
+	if origins, ok := settings[FlagAllowedOrigins]; ok {
+		if _, err := httpapi.ParseAllowedOrigins(origins.([]string)); err != nil {
+			return nil, nil, xerrors.Errorf("%s: %w", FlagAllowedOrigins, err)
+		}
+	}
+	return settings, command, nil
+}
+
+// configValue checks that a value of the config file has the type of its flag.
+func configValue(flagType string, value any) (any, error) {
+	switch flagType {
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, xerrors.New("must be a string")
+	case "int":
+		if i, ok := value.(int); ok {
+			return i, nil
+		}
+		return nil, xerrors.New("must be an integer")
+	case "uint16":
+		if i, ok := value.(int); ok && i >= 0 && i <= math.MaxUint16 {
+			return uint16(i), nil
+		}
+		return nil, xerrors.Errorf("must be an integer between 0 and %d", math.MaxUint16)
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, xerrors.New("must be true or false")
+	case "stringSlice":
+		return configStringList(value)
+	default:
+		return nil, xerrors.Errorf("unknown flag type: %s", flagType)
+	}
+}
+
+func configStringList(value any) ([]string, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, xerrors.New("must be a list of strings")
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, xerrors.New("must be a list of strings")
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
@@ -2,13 +2,14 @@ package server
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"sort"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -17,6 +18,7 @@ import (
 	"github.com/coder/agentapi/lib/httpapi"
 	"github.com/coder/agentapi/lib/logctx"
 	"github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/policy"
 	"github.com/coder/agentapi/lib/termexec"
 )
 
@@ -37,35 +39,35 @@ const (
 	AgentTypeCustom   AgentType = msgfmt.AgentTypeCustom
 )
 
-// agentTypeAliases contains the mapping of possible input agent type strings to their canonical AgentType values
-var agentTypeAliases = map[string]AgentType{
-	"claude":       AgentTypeClaude,
-	"goose":        AgentTypeGoose,
-	"aider":        AgentTypeAider,
-	"codex":        AgentTypeCodex,
-	"gemini":       AgentTypeGemini,
-	"copilot":      AgentTypeCopilot,
-	"amp":          AgentTypeAmp,
-	"auggie":       AgentTypeAuggie,
-	"cursor":       AgentTypeCursor,
-	"cursor-agent": AgentTypeCursor,
-	"q":            AgentTypeAmazonQ,
-	"amazonq":      AgentTypeAmazonQ,
-	"opencode":     AgentTypeOpencode,
-	"custom":       AgentTypeCustom,
+// isProfilePath reports whether the agent type is the path of a profile file
+// rather than the name of a registered profile.
+func isProfilePath(agentType string) bool {
+	ext := strings.ToLower(filepath.Ext(agentType))
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
 }
 
 func parseAgentType(firstArg string, agentTypeVar string) (AgentType, error) {
+	// if the agent type is a profile file, load it and make it available
+	if isProfilePath(agentTypeVar) {
+		profile, err := msgfmt.LoadProfile(agentTypeVar)
+		if err != nil {
+			return AgentTypeCustom, err
+		}
+		if err := msgfmt.RegisterProfile(profile); err != nil {
+			return AgentTypeCustom, xerrors.Errorf("failed to register profile %s: %w", agentTypeVar, err)
+		}
+		return profile.Name, nil
+	}
 	// if the agent type is provided, use it
-	if castedAgentType, ok := agentTypeAliases[agentTypeVar]; ok {
-		return castedAgentType, nil
+	if profile, ok := msgfmt.ProfileByName(agentTypeVar); ok {
+		return profile.Name, nil
 	}
 	if agentTypeVar != "" {
 		return AgentTypeCustom, fmt.Errorf("invalid agent type: %s", agentTypeVar)
 	}
 	// if the agent type is not provided, guess it from the first argument
-	if castedFirstArg, ok := agentTypeAliases[firstArg]; ok {
-		return castedFirstArg, nil
+	if profile, ok := msgfmt.ProfileByName(firstArg); ok {
+		return profile.Name, nil
 	}
 	return AgentTypeCustom, nil
 }
@@ -87,32 +89,96 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 	if termHeight < 10 {
 		return xerrors.Errorf("term height must be at least 10")
 	}
+	scrollbackLines := viper.GetInt(FlagScrollback)
+	if scrollbackLines < 0 {
+		return xerrors.Errorf("scrollback must not be negative")
+	}
+
+	authToken, err := resolveAuthToken(viper.GetString(FlagAuthToken), viper.GetString(FlagAuthTokenFile))
+	if err != nil {
+		return xerrors.Errorf("failed to resolve auth token: %w", err)
+	}
+
+	uploadMaxSize := viper.GetInt(FlagUploadMaxSize)
+	if uploadMaxSize < 1 {
+		return xerrors.Errorf("upload max size must be at least 1MB")
+	}
+	uploadQuota := viper.GetInt(FlagUploadQuota)
+	if uploadQuota < 0 {
+		return xerrors.Errorf("upload quota must not be negative")
+	}
+
+	restartPolicy, err := httpapi.ParseRestartPolicy(viper.GetString(FlagRestart))
+	if err != nil {
+		return xerrors.Errorf("failed to parse restart policy: %w", err)
+	}
+
+	var approvalPolicy *policy.Policy
+	if policyPath := viper.GetString(FlagApprovalPolicy); policyPath != "" {
+		approvalPolicy, err = policy.Load(policyPath)
+		if err != nil {
+			return xerrors.Errorf("failed to load approval policy: %w", err)
+		}
+	}
 
 	printOpenAPI := viper.GetBool(FlagPrintOpenAPI)
+	var recorder *termexec.Recorder
+	if recordPath := viper.GetString(FlagRecord); recordPath != "" && !printOpenAPI {
+		recorder, err = termexec.NewRecorder(recordPath, termWidth, termHeight)
+		if err != nil {
+			return xerrors.Errorf("failed to start recording: %w", err)
+		}
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				logger.Error("Failed to close recording", "error", err)
+			}
+		}()
+		logger.Info("Recording the agent's terminal", "path", recordPath)
+	}
+	processConfig := httpapi.SetupProcessConfig{
+		Program:         agent,
+		ProgramArgs:     argsToPass[1:],
+		TerminalWidth:   termWidth,
+		TerminalHeight:  termHeight,
+		ScrollbackLines: scrollbackLines,
+		AgentType:       agentType,
+		Recorder:        recorder,
+	}
 	var process *termexec.Process
 	if printOpenAPI {
 		process = nil
 	} else {
-		process, err = httpapi.SetupProcess(ctx, httpapi.SetupProcessConfig{
-			Program:        agent,
-			ProgramArgs:    argsToPass[1:],
-			TerminalWidth:  termWidth,
-			TerminalHeight: termHeight,
-			AgentType:      agentType,
-		})
+		process, err = httpapi.StartAgentProcess(ctx, processConfig)
 		if err != nil {
-			return xerrors.Errorf("failed to setup process: %w", err)
+			return xerrors.Errorf("failed to start agent: %w", err)
 		}
 	}
+	// The agent runs in the working directory of the server.
+	workDir, err := os.Getwd()
+	if err != nil {
+		return xerrors.Errorf("failed to get working directory: %w", err)
+	}
 	port := viper.GetInt(FlagPort)
 	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
-		AgentType:      agentType,
-		Process:        process,
-		Port:           port,
-		ChatBasePath:   viper.GetString(FlagChatBasePath),
-		AllowedHosts:   viper.GetStringSlice(FlagAllowedHosts),
-		AllowedOrigins: viper.GetStringSlice(FlagAllowedOrigins),
-		InitialPrompt:  viper.GetString(FlagInitialPrompt),
+		AgentType:       agentType,
+		Process:         process,
+		Port:            port,
+		ChatBasePath:    viper.GetString(FlagChatBasePath),
+		AllowedHosts:    viper.GetStringSlice(FlagAllowedHosts),
+		AllowedOrigins:  viper.GetStringSlice(FlagAllowedOrigins),
+		InitialPrompt:   viper.GetString(FlagInitialPrompt),
+		TerminalWidth:   termWidth,
+		TerminalHeight:  termHeight,
+		ScrollbackLines: scrollbackLines,
+		AuthToken:       authToken,
+		StateDir:        viper.GetString(FlagStateDir),
+		ProcessConfig:   processConfig,
+		RestartPolicy:   restartPolicy,
+		// The flags are in megabytes.
+		UploadMaxFileSize: int64(uploadMaxSize) << 20,
+		UploadQuota:       int64(uploadQuota) << 20,
+		WorkDir:           workDir,
+		ApprovalPolicy:    approvalPolicy,
 	})
 	if err != nil {
 		return xerrors.Errorf("failed to create server: %w", err)
@@ -126,17 +192,25 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 	processExitCh := make(chan error, 1)
 	go func() {
 		defer close(processExitCh)
-		if err := process.Wait(); err != nil {
-			if errors.Is(err, termexec.ErrNonZeroExitCode) {
-				processExitCh <- xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
-			} else {
-				processExitCh <- xerrors.Errorf("failed to wait for process: %w", err)
-			}
+		// The agent may be restarted according to the restart policy. The
+		// server only stops once the agent exits for good.
+		if err := srv.WaitForAgent(ctx); err != nil {
+			processExitCh <- err
 		}
 		if err := srv.Stop(ctx); err != nil {
 			logger.Error("Failed to stop server", "error", err)
 		}
 	}()
+	// Handle SIGINT (Ctrl+C) and SIGTERM by stopping the agent and the server.
+	// The agent must not be closed directly, since it'd be restarted.
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signalCh
+		if err := srv.Stop(ctx); err != nil {
+			logger.Error("Failed to stop server", "error", err)
+		}
+	}()
 	if err := srv.Start(); err != nil && err != context.Canceled && err != http.ErrServerClosed {
 		return xerrors.Errorf("failed to start server: %w", err)
 	}
@@ -148,14 +222,27 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 	return nil
 }
 
-var agentNames = (func() []string {
-	names := make([]string, 0, len(agentTypeAliases))
-	for agentType := range agentTypeAliases {
-		names = append(names, agentType)
+// resolveAuthToken returns the bearer token required by the server, read either
+// directly from the flag value or from a file. An empty token disables authentication.
+func resolveAuthToken(token string, tokenFile string) (string, error) {
+	if token != "" && tokenFile != "" {
+		return "", xerrors.Errorf("--%s and --%s are mutually exclusive", FlagAuthToken, FlagAuthTokenFile)
 	}
-	sort.Strings(names)
-	return names
-})()
+	if tokenFile == "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", xerrors.Errorf("failed to read token file: %w", err)
+	}
+	token = strings.TrimSpace(string(data))
+	if token == "" {
+		return "", xerrors.Errorf("token file %s is empty", tokenFile)
+	}
+	return token, nil
+}
+
+var agentNames = msgfmt.ProfileNames()
 
 type flagSpec struct {
 	name         string
@@ -172,18 +259,46 @@ const (
 	FlagChatBasePath   = "chat-base-path"
 	FlagTermWidth      = "term-width"
 	FlagTermHeight     = "term-height"
+	FlagScrollback     = "scrollback"
 	FlagAllowedHosts   = "allowed-hosts"
 	FlagAllowedOrigins = "allowed-origins"
 	FlagExit           = "exit"
 	FlagInitialPrompt  = "initial-prompt"
+	FlagAuthToken      = "auth-token"
+	FlagAuthTokenFile  = "auth-token-file"
+	FlagStateDir       = "state-dir"
+	FlagRestart        = "restart"
+	FlagUploadMaxSize  = "upload-max-size"
+	FlagUploadQuota    = "upload-quota"
+	FlagApprovalPolicy = "approval-policy"
+	FlagRecord         = "record"
+	FlagConfig         = "config"
 )
 
 func CreateServerCmd() *cobra.Command {
+	// configCommand is the agent command from the config file. It's used if
+	// the agent command isn't passed on the command line.
+	var configCommand []string
+	var flagSpecs []flagSpec
 	serverCmd := &cobra.Command{
 		Use:   "server [agent]",
 		Short: "Run the server",
-		Long:  fmt.Sprintf("Run the server with the specified agent (one of: %s)", strings.Join(agentNames, ", ")),
-		Args:  cobra.MinimumNArgs(1),
+		Long:  fmt.Sprintf("Run the server with the specified agent (one of: %s). The agent command may also be set in the config file", strings.Join(agentNames, ", ")),
+		Args:  cobra.ArbitraryArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			configCommand = nil
+			if configPath := viper.GetString(FlagConfig); configPath != "" {
+				command, err := loadConfigFile(configPath, flagSpecs)
+				if err != nil {
+					return err
+				}
+				configCommand = command
+			}
+			if len(cmd.Flags().Args()) == 0 && len(configCommand) == 0 {
+				return xerrors.Errorf("requires the agent command as an argument or under %q in the config file", configKeyCommand)
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 			// The --exit flag is used for testing validation of flags in the test suite
 			if viper.GetBool(FlagExit) {
@@ -195,25 +310,40 @@ func CreateServerCmd() *cobra.Command {
 				logger = slog.New(logctx.DiscardHandler)
 			}
 			ctx := logctx.WithLogger(context.Background(), logger)
-			if err := runServer(ctx, logger, cmd.Flags().Args()); err != nil {
+			// The agent command on the command line replaces the one in the config file.
+			argsToPass := cmd.Flags().Args()
+			if len(argsToPass) == 0 {
+				argsToPass = configCommand
+			}
+			if err := runServer(ctx, logger, argsToPass); err != nil {
 				fmt.Fprintf(os.Stderr, "%+v\n", err)
 				os.Exit(1)
 			}
 		},
 	}
 
-	flagSpecs := []flagSpec{
-		{FlagType, "t", "", fmt.Sprintf("Override the agent type (one of: %s, custom)", strings.Join(agentNames, ", ")), "string"},
+	flagSpecs = []flagSpec{
+		{FlagType, "t", "", fmt.Sprintf("Override the agent type (one of: %s), or the path of a YAML or JSON agent profile", strings.Join(agentNames, ", ")), "string"},
 		{FlagPort, "p", 3284, "Port to run the server on", "int"},
 		{FlagPrintOpenAPI, "P", false, "Print the OpenAPI schema to stdout and exit", "bool"},
 		{FlagChatBasePath, "c", "/chat", "Base path for assets and routes used in the static files of the chat interface", "string"},
 		{FlagTermWidth, "W", uint16(80), "Width of the emulated terminal", "uint16"},
-		{FlagTermHeight, "H", uint16(1000), "Height of the emulated terminal", "uint16"},
+		{FlagTermHeight, "H", uint16(50), "Height of the emulated terminal. Replies longer than the terminal height are kept in the scrollback", "uint16"},
+		{FlagScrollback, "", 10000, "Number of lines that scrolled off the top of the emulated terminal to keep, so replies longer than the terminal height aren't truncated. The scrollback is disabled if 0", "int"},
 		// localhost is the default host for the server. Port is ignored during matching.
 		{FlagAllowedHosts, "a", []string{"localhost", "127.0.0.1", "[::1]"}, "HTTP allowed hosts (hostnames only, no ports). Use '*' for all, comma-separated list via flag, space-separated list via AGENTAPI_ALLOWED_HOSTS env var", "stringSlice"},
 		// localhost:3284 is the default origin when you open the chat interface in your browser. localhost:3000 and 3001 are used during development.
 		{FlagAllowedOrigins, "o", []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, "HTTP allowed origins. Use '*' for all, comma-separated list via flag, space-separated list via AGENTAPI_ALLOWED_ORIGINS env var", "stringSlice"},
 		{FlagInitialPrompt, "I", "", "Initial prompt for the agent (recommended only if the agent doesn't support initial prompt in interaction mode)", "string"},
+		{FlagAuthToken, "", "", "Require this bearer token on all API requests. Prefer --auth-token-file or the AGENTAPI_AUTH_TOKEN env var to keep the token out of the process list", "string"},
+		{FlagAuthTokenFile, "", "", "Path to a file containing the bearer token required on all API requests", "string"},
+		{FlagStateDir, "", "", "Directory where the conversation history is persisted and restored from on startup. History is kept in memory only if unset", "string"},
+		{FlagRestart, "", string(httpapi.RestartPolicyNever), "Restart the agent when it exits (one of: never, on-failure, always). Restarts are delayed with exponential backoff, and the conversation history is kept", "string"},
+		{FlagUploadMaxSize, "", 10, "Maximum size of a single uploaded file in megabytes", "int"},
+		{FlagUploadQuota, "", 0, "Maximum total size of the uploaded files in megabytes. Uploads are unlimited if 0", "int"},
+		{FlagApprovalPolicy, "", "", "Path to a YAML or JSON file with rules that approve or deny the agent's permission prompts automatically", "string"},
+		{FlagRecord, "", "", "Record the agent's terminal output and the input sent to it to an asciinema v2 cast file at this path. The file is overwritten if it exists", "string"},
+		{FlagConfig, "", "", "Path to a YAML file with server options, keyed by flag name, and the agent command under 'command'. Flags and env vars take precedence over the file", "string"},
 	}
 
 	for _, spec := range flagSpecs {
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -176,6 +177,28 @@ func TestParseAgentType(t *testing.T) {
 		_, err := parseAgentType("claude", "invalid")
 		require.Error(t, err)
 	})
+
+	t.Run("profile file", func(t *testing.T) {
+		profilePath := filepath.Join(t.TempDir(), "mybot.yaml")
+		require.NoError(t, os.WriteFile(profilePath, []byte("name: mybot\naliases: [mybot-cli]\nbase: claude\n"), 0o600))
+		got, err := parseAgentType("mybot-cli", profilePath)
+		require.NoError(t, err)
+		require.Equal(t, AgentType("mybot"), got)
+
+		// The profile can be selected by its aliases once it's loaded.
+		got, err = parseAgentType("mybot-cli", "")
+		require.NoError(t, err)
+		require.Equal(t, AgentType("mybot"), got)
+	})
+
+	t.Run("invalid profile file", func(t *testing.T) {
+		profilePath := filepath.Join(t.TempDir(), "invalid.json")
+		require.NoError(t, os.WriteFile(profilePath, []byte(`{"name": "claude"}`), 0o600))
+		_, err := parseAgentType("claude", profilePath)
+		require.Error(t, err)
+		_, err = parseAgentType("claude", filepath.Join(t.TempDir(), "missing.yaml"))
+		require.Error(t, err)
+	})
 }
 
 // Test helper to isolate viper config between tests
@@ -229,9 +252,19 @@ func TestServerCmd_AllArgs_Defaults(t *testing.T) {
 		{"print-openapi default", FlagPrintOpenAPI, false, func() any { return viper.GetBool(FlagPrintOpenAPI) }},
 		{"chat-base-path default", FlagChatBasePath, "/chat", func() any { return viper.GetString(FlagChatBasePath) }},
 		{"term-width default", FlagTermWidth, uint16(80), func() any { return viper.GetUint16(FlagTermWidth) }},
-		{"term-height default", FlagTermHeight, uint16(1000), func() any { return viper.GetUint16(FlagTermHeight) }},
+		{"term-height default", FlagTermHeight, uint16(50), func() any { return viper.GetUint16(FlagTermHeight) }},
+		{"scrollback default", FlagScrollback, 10000, func() any { return viper.GetInt(FlagScrollback) }},
 		{"allowed-hosts default", FlagAllowedHosts, []string{"localhost", "127.0.0.1", "[::1]"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"allowed-origins default", FlagAllowedOrigins, []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"auth-token default", FlagAuthToken, "", func() any { return viper.GetString(FlagAuthToken) }},
+		{"auth-token-file default", FlagAuthTokenFile, "", func() any { return viper.GetString(FlagAuthTokenFile) }},
+		{"state-dir default", FlagStateDir, "", func() any { return viper.GetString(FlagStateDir) }},
+		{"restart default", FlagRestart, "never", func() any { return viper.GetString(FlagRestart) }},
+		{"upload-max-size default", FlagUploadMaxSize, 10, func() any { return viper.GetInt(FlagUploadMaxSize) }},
+		{"upload-quota default", FlagUploadQuota, 0, func() any { return viper.GetInt(FlagUploadQuota) }},
+		{"approval-policy default", FlagApprovalPolicy, "", func() any { return viper.GetString(FlagApprovalPolicy) }},
+		{"record default", FlagRecord, "", func() any { return viper.GetString(FlagRecord) }},
+		{"config default", FlagConfig, "", func() any { return viper.GetString(FlagConfig) }},
 	}
 
 	for _, tt := range tests {
@@ -264,8 +297,18 @@ func TestServerCmd_AllEnvVars(t *testing.T) {
 		{"AGENTAPI_CHAT_BASE_PATH", "AGENTAPI_CHAT_BASE_PATH", "/api", "/api", func() any { return viper.GetString(FlagChatBasePath) }},
 		{"AGENTAPI_TERM_WIDTH", "AGENTAPI_TERM_WIDTH", "120", uint16(120), func() any { return viper.GetUint16(FlagTermWidth) }},
 		{"AGENTAPI_TERM_HEIGHT", "AGENTAPI_TERM_HEIGHT", "500", uint16(500), func() any { return viper.GetUint16(FlagTermHeight) }},
+		{"AGENTAPI_SCROLLBACK", "AGENTAPI_SCROLLBACK", "500", 500, func() any { return viper.GetInt(FlagScrollback) }},
 		{"AGENTAPI_ALLOWED_HOSTS", "AGENTAPI_ALLOWED_HOSTS", "localhost example.com", []string{"localhost", "example.com"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"AGENTAPI_ALLOWED_ORIGINS", "AGENTAPI_ALLOWED_ORIGINS", "https://example.com http://localhost:3000", []string{"https://example.com", "http://localhost:3000"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"AGENTAPI_AUTH_TOKEN", "AGENTAPI_AUTH_TOKEN", "secret", "secret", func() any { return viper.GetString(FlagAuthToken) }},
+		{"AGENTAPI_AUTH_TOKEN_FILE", "AGENTAPI_AUTH_TOKEN_FILE", "/run/secrets/token", "/run/secrets/token", func() any { return viper.GetString(FlagAuthTokenFile) }},
+		{"AGENTAPI_STATE_DIR", "AGENTAPI_STATE_DIR", "/var/lib/agentapi", "/var/lib/agentapi", func() any { return viper.GetString(FlagStateDir) }},
+		{"AGENTAPI_RESTART", "AGENTAPI_RESTART", "on-failure", "on-failure", func() any { return viper.GetString(FlagRestart) }},
+		{"AGENTAPI_UPLOAD_MAX_SIZE", "AGENTAPI_UPLOAD_MAX_SIZE", "50", 50, func() any { return viper.GetInt(FlagUploadMaxSize) }},
+		{"AGENTAPI_UPLOAD_QUOTA", "AGENTAPI_UPLOAD_QUOTA", "500", 500, func() any { return viper.GetInt(FlagUploadQuota) }},
+		{"AGENTAPI_APPROVAL_POLICY", "AGENTAPI_APPROVAL_POLICY", "/tmp/policy.yaml", "/tmp/policy.yaml", func() any { return viper.GetString(FlagApprovalPolicy) }},
+		{"AGENTAPI_RECORD", "AGENTAPI_RECORD", "/tmp/agent.cast", "/tmp/agent.cast", func() any { return viper.GetString(FlagRecord) }},
+		{"AGENTAPI_CONFIG", "AGENTAPI_CONFIG", "/dev/null", "/dev/null", func() any { return viper.GetString(FlagConfig) }},
 	}
 
 	for _, tt := range tests {
@@ -380,12 +423,12 @@ func TestMixed_ConfigurationScenarios(t *testing.T) {
 		}
 
 		// Verify mixed configuration
-		assert.Equal(t, "goose", viper.GetString(FlagType))            // from env
-		assert.Equal(t, 9999, viper.GetInt(FlagPort))                  // from CLI
-		assert.Equal(t, true, viper.GetBool(FlagPrintOpenAPI))         // from CLI
-		assert.Equal(t, "/chat", viper.GetString(FlagChatBasePath))    // default
-		assert.Equal(t, uint16(120), viper.GetUint16(FlagTermWidth))   // from env
-		assert.Equal(t, uint16(1000), viper.GetUint16(FlagTermHeight)) // default
+		assert.Equal(t, "goose", viper.GetString(FlagType))          // from env
+		assert.Equal(t, 9999, viper.GetInt(FlagPort))                // from CLI
+		assert.Equal(t, true, viper.GetBool(FlagPrintOpenAPI))       // from CLI
+		assert.Equal(t, "/chat", viper.GetString(FlagChatBasePath))  // default
+		assert.Equal(t, uint16(120), viper.GetUint16(FlagTermWidth)) // from env
+		assert.Equal(t, uint16(50), viper.GetUint16(FlagTermHeight)) // default
 	})
 }
 
@@ -571,3 +614,139 @@ func TestServerCmd_AllowedOrigins(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCmd_ConfigFile(t *testing.T) {
+	writeConfig := func(t *testing.T, config string) string {
+		t.Helper()
+		configPath := filepath.Join(t.TempDir(), "agentapi.yaml")
+		require.NoError(t, os.WriteFile(configPath, []byte(config), 0o600))
+		return configPath
+	}
+	execute := func(t *testing.T, args ...string) error {
+		t.Helper()
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs(append(args, "--exit"))
+		return serverCmd.Execute()
+	}
+	config := `
+type: claude
+port: 8080
+term-width: 120
+allowed-hosts: [localhost, example.com]
+allowed-origins: [https://example.com]
+print-openapi: true
+command: [claude, --allowedTools, "Bash(git*)"]
+`
+
+	t.Run("values", func(t *testing.T) {
+		isolateViper(t)
+		require.NoError(t, execute(t, "--config", writeConfig(t, config)))
+		assert.Equal(t, "claude", viper.GetString(FlagType))
+		assert.Equal(t, 8080, viper.GetInt(FlagPort))
+		assert.Equal(t, uint16(120), viper.GetUint16(FlagTermWidth))
+		assert.Equal(t, uint16(50), viper.GetUint16(FlagTermHeight)) // default
+		assert.Equal(t, []string{"localhost", "example.com"}, viper.GetStringSlice(FlagAllowedHosts))
+		assert.Equal(t, []string{"https://example.com"}, viper.GetStringSlice(FlagAllowedOrigins))
+		assert.Equal(t, true, viper.GetBool(FlagPrintOpenAPI))
+	})
+
+	t.Run("env var", func(t *testing.T) {
+		isolateViper(t)
+		t.Setenv("AGENTAPI_CONFIG", writeConfig(t, config))
+		require.NoError(t, execute(t))
+		assert.Equal(t, 8080, viper.GetInt(FlagPort))
+	})
+
+	t.Run("flags and env vars take precedence", func(t *testing.T) {
+		isolateViper(t)
+		t.Setenv("AGENTAPI_TERM_WIDTH", "150")
+		require.NoError(t, execute(t, "--config", writeConfig(t, config), "--port", "9090", "goose"))
+		assert.Equal(t, 9090, viper.GetInt(FlagPort))
+		assert.Equal(t, uint16(150), viper.GetUint16(FlagTermWidth))
+		assert.Equal(t, "claude", viper.GetString(FlagType))
+	})
+
+	errorCases := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{"unknown option", "prot: 8080\ncommand: [claude]\n", `unknown option "prot"`},
+		{"nested config", "config: other.yaml\ncommand: [claude]\n", `unknown option "config"`},
+		{"wrong type", "port: \"8080\"\ncommand: [claude]\n", "port: must be an integer"},
+		{"uint16 out of range", "term-width: 70000\ncommand: [claude]\n", "term-width: must be an integer between 0 and 65535"},
+		{"comma separated list", "allowed-hosts: localhost,example.com\ncommand: [claude]\n", "allowed-hosts: must be a list of strings"},
+		{"invalid host", "allowed-hosts: [\"localhost:3284\"]\ncommand: [claude]\n", "allowed-hosts: 'localhost:3284' must not include a port"},
+		{"invalid origin", "allowed-origins: [\"https://a.com b.com\"]\ncommand: [claude]\n", "allowed-origins: 'https://a.com b.com' contains whitespace"},
+		{"empty command", "command: []\n", "command must not be empty"},
+		{"no command", "port: 8080\n", "requires the agent command"},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateViper(t)
+			err := execute(t, "--config", writeConfig(t, tt.config))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		isolateViper(t)
+		err := execute(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"), "claude")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to read config file")
+	})
+}
+
+func TestParseConfig(t *testing.T) {
+	specs := []flagSpec{{FlagPort, "p", 3284, "", "int"}}
+	settings, command, err := parseConfig([]byte("port: 8080\ncommand: [aider, --model, sonnet]\n"), specs)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{FlagPort: 8080}, settings)
+	assert.Equal(t, []string{"aider", "--model", "sonnet"}, command)
+
+	settings, command, err = parseConfig([]byte(""), specs)
+	require.NoError(t, err)
+	assert.Empty(t, settings)
+	assert.Nil(t, command)
+}
+
+func TestResolveAuthToken(t *testing.T) {
+	t.Run("no token", func(t *testing.T) {
+		token, err := resolveAuthToken("", "")
+		require.NoError(t, err)
+		assert.Equal(t, "", token)
+	})
+
+	t.Run("token from flag", func(t *testing.T) {
+		token, err := resolveAuthToken("secret", "")
+		require.NoError(t, err)
+		assert.Equal(t, "secret", token)
+	})
+
+	t.Run("token from file", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+		token, err := resolveAuthToken("", tokenFile)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", token)
+	})
+
+	t.Run("empty token file", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("  \n"), 0o600))
+		_, err := resolveAuthToken("", tokenFile)
+		require.Error(t, err)
+	})
+
+	t.Run("missing token file", func(t *testing.T) {
+		_, err := resolveAuthToken("", filepath.Join(t.TempDir(), "missing"))
+		require.Error(t, err)
+	})
+
+	t.Run("flag and file are mutually exclusive", func(t *testing.T) {
+		_, err := resolveAuthToken("secret", "/tmp/token")
+		require.ErrorContains(t, err, "mutually exclusive")
+	})
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/policy"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+func newPendingAction(id int, prompt *mf.PermissionPrompt) *PendingAction {
+	options := make([]ActionOption, 0, len(prompt.Options))
+	for _, option := range prompt.Options {
+		options = append(options, ActionOption{Decision: option.Decision, Label: option.Label})
+	}
+	return &PendingAction{
+		Id:        id,
+		Question:  prompt.Question,
+		Details:   prompt.Details,
+		Options:   options,
+		CreatedAt: time.Now(),
+	}
+}
+
+func samePrompt(a, b *mf.PermissionPrompt) bool {
+	return a.Question == b.Question && a.Details == b.Details && slices.Equal(a.Options, b.Options)
+}
+
+// updatePendingAction looks for a permission prompt on the screen and returns the
+// pending action, or nil if the agent isn't waiting for permission. Prompts are only
+// detected once the screen is stable, so that the agent's output can't be mistaken
+// for a prompt while it's being written.
+func (sess *session) updatePendingAction(status st.ConversationStatus) *PendingAction {
+	var prompt *mf.PermissionPrompt
+	screen := sess.conversation.Screen()
+	if status == st.ConversationStatusStable {
+		prompt = mf.DetectPermissionPrompt(sess.agentType, screen)
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	switch {
+	case prompt == nil || screen == sess.answeredScreen:
+		// The prompt may stay on the screen for a moment after it was answered.
+		sess.pendingAction, sess.prompt = nil, nil
+	case sess.prompt == nil || !samePrompt(sess.prompt, prompt):
+		sess.lastActionId++
+		sess.prompt = prompt
+		sess.pendingAction = newPendingAction(sess.lastActionId, prompt)
+		sess.logger.Info("Agent is waiting for permission", "actionId", sess.lastActionId, "question", prompt.Question)
+		if sess.policy != nil && sess.applyPolicy(screen) {
+			sess.pendingAction, sess.prompt = nil, nil
+		}
+	}
+	return sess.pendingAction
+}
+
+// applyPolicy answers the pending action if the approval policy approves or denies it.
+// It returns true if the action was answered. Assumes the caller holds the lock.
+func (sess *session) applyPolicy(screen string) bool {
+	result := sess.policy.Evaluate(sess.prompt)
+	logger := sess.logger.With("actionId", sess.pendingAction.Id, "policyDecision", result.String())
+	var decision mf.ActionDecision
+	switch result.Decision {
+	case policy.DecisionApprove:
+		decision = mf.ActionDecisionApprove
+	case policy.DecisionDeny:
+		decision = mf.ActionDecisionDeny
+	default:
+		logger.Info("Approval policy left the action to be answered through the API")
+		return false
+	}
+	option, ok := sess.prompt.Option(decision)
+	if !ok {
+		logger.Warn("The agent doesn't offer the decision of the approval policy")
+		return false
+	}
+	if err := sess.answerPrompt(option, screen); err != nil {
+		logger.Error("Failed to answer permission prompt", "error", err)
+		return false
+	}
+	logger.Info("Answered permission prompt with the approval policy", "decision", decision)
+	return true
+}
+
+// answerPrompt sends the keystrokes that select the option to the agent.
+// Assumes the caller holds the lock.
+func (sess *session) answerPrompt(option mf.PermissionOption, screen string) error {
+	if _, err := sess.agentio.Write([]byte(option.Keys)); err != nil {
+		return xerrors.Errorf("failed to send decision: %w", err)
+	}
+	sess.answeredScreen = screen
+	return nil
+}
+
+// answerAction handles POST /actions/{id}
+func (s *Server) answerAction(ctx context.Context, input *ActionRequest) (*ActionResponse, error) {
+	sess := sessionFrom(ctx)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.pendingAction == nil || sess.pendingAction.Id != input.Id {
+		return nil, huma.Error404NotFound(fmt.Sprintf("action %d is not pending", input.Id))
+	}
+	option, ok := sess.prompt.Option(input.Body.Decision)
+	if !ok {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("the agent doesn't offer the %q decision for this action", input.Body.Decision))
+	}
+	if err := sess.answerPrompt(option, sess.conversation.Screen()); err != nil {
+		return nil, err
+	}
+	sess.logger.Info("Answered permission prompt", "actionId", input.Id, "decision", input.Body.Decision)
+
+	sess.pendingAction, sess.prompt = nil, nil
+	sess.emitter.UpdatePendingActionAndEmitChanges(nil)
+
+	resp := &ActionResponse{}
+	resp.Body.Ok = true
+	return resp, nil
+}
@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const unauthorizedMessage = "missing or invalid bearer token"
+
+// publicOperationKey marks operations that don't require authentication in their
+// metadata, such as health checks, which orchestrators call without credentials.
+const publicOperationKey = "public"
+
+// bearerTokenAuth checks that requests carry the expected token in an
+// "Authorization: Bearer <token>" header.
+type bearerTokenAuth struct {
+	// Comparing fixed-size digests keeps the comparison constant-time
+	// regardless of the length of the provided token.
+	expected [sha256.Size]byte
+}
+
+func newBearerTokenAuth(token string) *bearerTokenAuth {
+	return &bearerTokenAuth{expected: sha256.Sum256([]byte(token))}
+}
+
+func (a *bearerTokenAuth) authorized(authorizationHeader string) bool {
+	provided, ok := parseBearerToken(authorizationHeader)
+	if !ok {
+		return false
+	}
+	digest := sha256.Sum256([]byte(provided))
+	return subtle.ConstantTimeCompare(digest[:], a.expected[:]) == 1
+}
+
+// middleware rejects API operations that aren't authorized. It applies to every
+// operation registered with huma, including the SSE streams and file uploads.
+func (a *bearerTokenAuth) middleware(api huma.API) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		if public, _ := ctx.Operation().Metadata[publicOperationKey].(bool); public {
+			next(ctx)
+			return
+		}
+		if a.authorized(ctx.Header("Authorization")) {
+			next(ctx)
+			return
+		}
+		ctx.SetHeader("WWW-Authenticate", `Bearer realm="agentapi"`)
+		_ = huma.WriteErr(api, ctx, http.StatusUnauthorized, unauthorizedMessage)
+	}
+}
+
+// httpMiddleware is the equivalent of middleware for routes served outside of huma.
+func (a *bearerTokenAuth) httpMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.authorized(r.Header.Get("Authorization")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agentapi"`)
+		http.Error(w, unauthorizedMessage, http.StatusUnauthorized)
+	})
+}
+
+// parseBearerToken extracts the token from an Authorization header value.
+// The scheme is matched case-insensitively, as required by RFC 7235.
+func parseBearerToken(header string) (string, bool) {
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len(prefix):])
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
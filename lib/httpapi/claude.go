@@ -26,9 +26,14 @@ func formatClaudeCodeMessage(message string) []st.MessagePart {
 	return parts
 }
 
+// FormatMessage returns the parts a message is written to the agent's terminal
+// with, according to the input encoding of the agent's profile.
 func FormatMessage(agentType mf.AgentType, message string) []st.MessagePart {
 	message = mf.TrimWhitespace(message)
-	// for now Claude Code formatting seems to also work for Goose and Aider
-	// so we can use the same function for all three
+	if mf.ProfileOf(agentType).InputEncoding == mf.InputEncodingRaw {
+		return []st.MessagePart{st.MessagePartText{Content: message}}
+	}
+	// for now Claude Code formatting seems to also work for the other agents
+	// so we can use the same function for all of them
 	return formatClaudeCodeMessage(message)
 }
@@ -2,6 +2,7 @@ package httpapi
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,11 @@ const (
 	EventTypeMessageUpdate EventType = "message_update"
 	EventTypeStatusChange  EventType = "status_change"
 	EventTypeScreenUpdate  EventType = "screen_update"
+	EventTypePendingAction EventType = "pending_action"
+	// EventTypeStyledScreenUpdate events carry the screen with ANSI escape sequences
+	// for colors and text attributes. They're only sent to subscribers that opted in,
+	// in place of screen updates.
+	EventTypeStyledScreenUpdate EventType = "styled_screen_update"
 )
 
 type AgentStatus string
@@ -40,19 +46,57 @@ type MessageUpdateBody struct {
 	Id      int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
 	Role    st.ConversationRole `json:"role" doc:"Role of the message author"`
 	Message string              `json:"message" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
+	Parts   []mf.MessagePart    `json:"parts" nullable:"false" doc:"Message content split into text, code blocks, tool calls, tool output, and diffs."`
 	Time    time.Time           `json:"time" doc:"Timestamp of the message"`
 }
 
+// messageParts splits a message into parts. Only agent messages contain tool
+// calls, so user messages are parsed like the messages of a custom agent.
+func messageParts(agentType mf.AgentType, msg st.ConversationMessage) []mf.MessagePart {
+	var parts []mf.MessagePart
+	switch msg.Role {
+	case st.ConversationRoleAgent:
+		parts = mf.ParseMessageParts(agentType, msg.Message)
+	case st.ConversationRoleUser:
+		parts = mf.ParseMessageParts(mf.AgentTypeCustom, msg.Message)
+	default:
+		if text := mf.TrimWhitespace(msg.Message); text != "" {
+			parts = []mf.MessagePart{{Type: mf.MessagePartTypeText, Content: text}}
+		}
+	}
+	if parts == nil {
+		return []mf.MessagePart{}
+	}
+	return parts
+}
+
+func newMessageUpdateBody(agentType mf.AgentType, msg st.ConversationMessage) MessageUpdateBody {
+	return MessageUpdateBody{
+		Id:      msg.Id,
+		Role:    msg.Role,
+		Message: msg.Message,
+		Parts:   messageParts(agentType, msg),
+		Time:    msg.Time,
+	}
+}
+
 type StatusChangeBody struct {
 	Status    AgentStatus  `json:"status" doc:"Agent status"`
 	AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent being used by the server."`
 }
 
+type PendingActionBody struct {
+	PendingAction *PendingAction `json:"pending_action" doc:"Permission prompt the agent is waiting on, or null once it's no longer shown."`
+}
+
 type ScreenUpdateBody struct {
 	Screen string `json:"screen"`
 }
 
 type Event struct {
+	// Id is the sequence number of the event. Events that recreate the current
+	// state carry the sequence number of the last event emitted before them.
+	Id      int
 	Type    EventType
 	Payload any
 }
@@ -66,6 +110,19 @@ type EventEmitter struct {
 	chanIdx             int
 	subscriptionBufSize int
 	screen              string
+	styledScreen        string
+	pendingAction       *PendingAction
+	// seq is the sequence number of the last emitted event.
+	seq int
+	// replayLog holds the most recent message and status events so that
+	// subscribers can resume from the last event they received.
+	// Screen updates, styled or not, are not kept, since every update replaces the whole screen.
+	replayLog     []Event
+	replayLogSize int
+	// replayFloor is the sequence number of the newest event evicted from the replay log.
+	replayFloor int
+	// droppedChannels counts the subscriptions closed because their buffer was full.
+	droppedChannels int
 }
 
 func convertStatus(status st.ConversationStatus) AgentStatus {
@@ -86,7 +143,10 @@ func convertStatus(status st.ConversationStatus) AgentStatus {
 // Listeners must actively drain the channel, so it's important to
 // set this to a value that is large enough to handle the expected
 // number of events.
-func NewEventEmitter(subscriptionBufSize int) *EventEmitter {
+//
+// replayLogSize is the number of message and status events kept
+// for subscribers resuming with SubscribeSince.
+func NewEventEmitter(subscriptionBufSize int, replayLogSize int) *EventEmitter {
 	return &EventEmitter{
 		mu:                  sync.Mutex{},
 		messages:            make([]st.ConversationMessage, 0),
@@ -94,21 +154,35 @@ func NewEventEmitter(subscriptionBufSize int) *EventEmitter {
 		chans:               make(map[int]chan Event),
 		chanIdx:             0,
 		subscriptionBufSize: subscriptionBufSize,
+		replayLog:           make([]Event, 0, replayLogSize),
+		replayLogSize:       replayLogSize,
 	}
 }
 
 // Assumes the caller holds the lock.
 func (e *EventEmitter) notifyChannels(eventType EventType, payload any) {
+	e.seq++
+	event := Event{
+		Id:      e.seq,
+		Type:    eventType,
+		Payload: payload,
+	}
+	if !isScreenEvent(eventType) && e.replayLogSize > 0 {
+		if len(e.replayLog) == e.replayLogSize {
+			e.replayFloor = e.replayLog[0].Id
+			// Shift in place to reuse the backing array.
+			copy(e.replayLog, e.replayLog[1:])
+			e.replayLog = e.replayLog[:len(e.replayLog)-1]
+		}
+		e.replayLog = append(e.replayLog, event)
+	}
+
 	chanIds := make([]int, 0, len(e.chans))
 	for chanId := range e.chans {
 		chanIds = append(chanIds, chanId)
 	}
 	for _, chanId := range chanIds {
 		ch := e.chans[chanId]
-		event := Event{
-			Type:    eventType,
-			Payload: payload,
-		}
 
 		select {
 		case ch <- event:
@@ -116,6 +190,7 @@ func (e *EventEmitter) notifyChannels(eventType EventType, payload any) {
 			// If the channel is full, close it.
 			// Listeners must actively drain the channel.
 			e.unsubscribeInner(chanId)
+			e.droppedChannels++
 		}
 	}
 }
@@ -137,12 +212,7 @@ func (e *EventEmitter) UpdateMessagesAndEmitChanges(newMessages []st.Conversatio
 			newMsg = newMessages[i]
 		}
 		if oldMsg != newMsg {
-			e.notifyChannels(EventTypeMessageUpdate, MessageUpdateBody{
-				Id:      newMessages[i].Id,
-				Role:    newMessages[i].Role,
-				Message: newMessages[i].Message,
-				Time:    newMessages[i].Time,
-			})
+			e.notifyChannels(EventTypeMessageUpdate, newMessageUpdateBody(e.agentType, newMessages[i]))
 		}
 	}
 
@@ -153,6 +223,9 @@ func (e *EventEmitter) UpdateStatusAndEmitChanges(newStatus st.ConversationStatu
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// The agent type is also used to split the messages into parts, so it's
+	// stored even if the status didn't change.
+	e.agentType = agentType
 	newAgentStatus := convertStatus(newStatus)
 	if e.status == newAgentStatus {
 		return
@@ -160,7 +233,38 @@ func (e *EventEmitter) UpdateStatusAndEmitChanges(newStatus st.ConversationStatu
 
 	e.notifyChannels(EventTypeStatusChange, StatusChangeBody{Status: newAgentStatus, AgentType: agentType})
 	e.status = newAgentStatus
+}
+
+// EmitStatus emits a status change event even if the status didn't change,
+// e.g. to let subscribers know that the agent was restarted.
+func (e *EventEmitter) EmitStatus(status st.ConversationStatus, agentType mf.AgentType) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.status = convertStatus(status)
 	e.agentType = agentType
+	e.notifyChannels(EventTypeStatusChange, StatusChangeBody{Status: e.status, AgentType: agentType})
+}
+
+// UpdatePendingActionAndEmitChanges emits an event when a permission prompt
+// appears or disappears. action is nil if there's no prompt.
+func (e *EventEmitter) UpdatePendingActionAndEmitChanges(action *PendingAction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if actionId(e.pendingAction) == actionId(action) {
+		return
+	}
+
+	e.notifyChannels(EventTypePendingAction, PendingActionBody{PendingAction: action})
+	e.pendingAction = action
+}
+
+func actionId(action *PendingAction) int {
+	if action == nil {
+		return 0
+	}
+	return action.Id
 }
 
 func (e *EventEmitter) UpdateScreenAndEmitChanges(newScreen string) {
@@ -175,34 +279,119 @@ func (e *EventEmitter) UpdateScreenAndEmitChanges(newScreen string) {
 	e.screen = newScreen
 }
 
+// UpdateStyledScreenAndEmitChanges works like UpdateScreenAndEmitChanges for the
+// screen encoded with ANSI escape sequences.
+func (e *EventEmitter) UpdateStyledScreenAndEmitChanges(newScreen string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.styledScreen == newScreen {
+		return
+	}
+
+	e.notifyChannels(EventTypeStyledScreenUpdate, ScreenUpdateBody{Screen: newScreen})
+	e.styledScreen = newScreen
+}
+
+func isScreenEvent(eventType EventType) bool {
+	return eventType == EventTypeScreenUpdate || eventType == EventTypeStyledScreenUpdate
+}
+
 // Assumes the caller holds the lock.
 func (e *EventEmitter) currentStateAsEvents() []Event {
-	events := make([]Event, 0, len(e.messages)+2)
+	events := make([]Event, 0, len(e.messages)+4)
 	for _, msg := range e.messages {
 		events = append(events, Event{
+			Id:      e.seq,
 			Type:    EventTypeMessageUpdate,
-			Payload: MessageUpdateBody{Id: msg.Id, Role: msg.Role, Message: msg.Message, Time: msg.Time},
+			Payload: newMessageUpdateBody(e.agentType, msg),
 		})
 	}
 	events = append(events, Event{
+		Id:      e.seq,
 		Type:    EventTypeStatusChange,
 		Payload: StatusChangeBody{Status: e.status, AgentType: e.agentType},
 	})
-	events = append(events, Event{
+	if e.pendingAction != nil {
+		events = append(events, Event{
+			Id:      e.seq,
+			Type:    EventTypePendingAction,
+			Payload: PendingActionBody{PendingAction: e.pendingAction},
+		})
+	}
+	events = append(events, e.currentScreenEvents()...)
+	return events
+}
+
+// Returns the current screen, followed by the styled screen if there is one.
+// Assumes the caller holds the lock.
+func (e *EventEmitter) currentScreenEvents() []Event {
+	events := []Event{{
+		Id:      e.seq,
 		Type:    EventTypeScreenUpdate,
 		Payload: ScreenUpdateBody{Screen: strings.TrimRight(e.screen, mf.WhiteSpaceChars)},
-	})
+	}}
+	if e.styledScreen != "" {
+		events = append(events, Event{
+			Id:      e.seq,
+			Type:    EventTypeStyledScreenUpdate,
+			Payload: ScreenUpdateBody{Screen: e.styledScreen},
+		})
+	}
 	return events
 }
 
+// Returns the message and status events emitted after lastEventId, or false if some of
+// them are no longer in the replay log. Only the latest update of every message and
+// the latest status change are returned, since each of them supersedes the previous ones.
+// Assumes the caller holds the lock.
+func (e *EventEmitter) missedEvents(lastEventId int) ([]Event, bool) {
+	if lastEventId < e.replayFloor || lastEventId > e.seq {
+		return nil, false
+	}
+	type eventKey struct {
+		eventType EventType
+		messageId int
+	}
+	seen := make(map[eventKey]bool)
+	missed := make([]Event, 0)
+	for i := len(e.replayLog) - 1; i >= 0 && e.replayLog[i].Id > lastEventId; i-- {
+		event := e.replayLog[i]
+		key := eventKey{eventType: event.Type}
+		if body, ok := event.Payload.(MessageUpdateBody); ok {
+			key.messageId = body.Id
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		missed = append(missed, event)
+	}
+	slices.Reverse(missed)
+	return missed, true
+}
+
 // Subscribe returns:
 // - a subscription ID that can be used to unsubscribe.
 // - a channel for receiving events.
 // - a list of events that allow to recreate the state of the conversation right before the subscription was created.
 func (e *EventEmitter) Subscribe() (int, <-chan Event, []Event) {
+	return e.SubscribeSince(0)
+}
+
+// SubscribeSince works like Subscribe, but resumes a previous subscription that last
+// received the event with lastEventId. If the events emitted since then are still in
+// the replay log, only those events and the current screen are returned instead of the
+// full state. A lastEventId of 0 or less starts a new subscription.
+func (e *EventEmitter) SubscribeSince(lastEventId int) (int, <-chan Event, []Event) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	stateEvents := e.currentStateAsEvents()
+	var stateEvents []Event
+	if missed, ok := e.missedEvents(lastEventId); lastEventId > 0 && ok {
+		stateEvents = append(missed, e.currentScreenEvents()...)
+	} else {
+		stateEvents = e.currentStateAsEvents()
+	}
 
 	// Once a channel becomes full, it will be closed.
 	ch := make(chan Event, e.subscriptionBufSize)
@@ -211,12 +400,25 @@ func (e *EventEmitter) Subscribe() (int, <-chan Event, []Event) {
 	return e.chanIdx - 1, ch, stateEvents
 }
 
-// Assumes the caller holds the lock.
+// Assumes the caller holds the lock. Channels that were closed because they
+// became full are already unsubscribed.
 func (e *EventEmitter) unsubscribeInner(chanId int) {
-	close(e.chans[chanId])
+	ch, ok := e.chans[chanId]
+	if !ok {
+		return
+	}
+	close(ch)
 	delete(e.chans, chanId)
 }
 
+// Stats returns the number of subscribers and the number of subscriptions
+// that were closed because the subscriber didn't keep up with the events.
+func (e *EventEmitter) Stats() (subscribers int, droppedChannels int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.chans), e.droppedChannels
+}
+
 func (e *EventEmitter) Unsubscribe(chanId int) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -10,9 +10,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func textParts(content string) []mf.MessagePart {
+	return []mf.MessagePart{{Type: mf.MessagePartTypeText, Content: content}}
+}
+
 func TestEventEmitter(t *testing.T) {
 	t.Run("single-subscription", func(t *testing.T) {
-		emitter := NewEventEmitter(10)
+		emitter := NewEventEmitter(10, 10)
 		_, ch, stateEvents := emitter.Subscribe()
 		assert.Empty(t, ch)
 		assert.Equal(t, []Event{
@@ -32,8 +36,9 @@ func TestEventEmitter(t *testing.T) {
 		})
 		newEvent := <-ch
 		assert.Equal(t, Event{
+			Id:      1,
 			Type:    EventTypeMessageUpdate,
-			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Role: st.ConversationRoleUser, Time: now},
+			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Parts: textParts("Hello, world!"), Role: st.ConversationRoleUser, Time: now},
 		}, newEvent)
 
 		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
@@ -42,26 +47,29 @@ func TestEventEmitter(t *testing.T) {
 		})
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      2,
 			Type:    EventTypeMessageUpdate,
-			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world! (updated)", Role: st.ConversationRoleUser, Time: now},
+			Payload: MessageUpdateBody{Id: 1, Message: "Hello, world! (updated)", Parts: textParts("Hello, world! (updated)"), Role: st.ConversationRoleUser, Time: now},
 		}, newEvent)
 
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      3,
 			Type:    EventTypeMessageUpdate,
-			Payload: MessageUpdateBody{Id: 2, Message: "What's up?", Role: st.ConversationRoleAgent, Time: now},
+			Payload: MessageUpdateBody{Id: 2, Message: "What's up?", Parts: textParts("What's up?"), Role: st.ConversationRoleAgent, Time: now},
 		}, newEvent)
 
 		emitter.UpdateStatusAndEmitChanges(st.ConversationStatusStable, mf.AgentTypeAider)
 		newEvent = <-ch
 		assert.Equal(t, Event{
+			Id:      4,
 			Type:    EventTypeStatusChange,
 			Payload: StatusChangeBody{Status: AgentStatusStable, AgentType: mf.AgentTypeAider},
 		}, newEvent)
 	})
 
 	t.Run("multiple-subscriptions", func(t *testing.T) {
-		emitter := NewEventEmitter(10)
+		emitter := NewEventEmitter(10, 10)
 		channels := make([]<-chan Event, 0, 10)
 		for i := 0; i < 10; i++ {
 			_, ch, _ := emitter.Subscribe()
@@ -75,14 +83,15 @@ func TestEventEmitter(t *testing.T) {
 		for _, ch := range channels {
 			newEvent := <-ch
 			assert.Equal(t, Event{
+				Id:      1,
 				Type:    EventTypeMessageUpdate,
-				Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Role: st.ConversationRoleUser, Time: now},
+				Payload: MessageUpdateBody{Id: 1, Message: "Hello, world!", Parts: textParts("Hello, world!"), Role: st.ConversationRoleUser, Time: now},
 			}, newEvent)
 		}
 	})
 
 	t.Run("close-channel", func(t *testing.T) {
-		emitter := NewEventEmitter(1)
+		emitter := NewEventEmitter(1, 1)
 		_, ch, _ := emitter.Subscribe()
 		for i := range 5 {
 			emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
@@ -98,4 +107,190 @@ func TestEventEmitter(t *testing.T) {
 			t.Fatalf("read should not block")
 		}
 	})
+
+	t.Run("resume", func(t *testing.T) {
+		emitter := NewEventEmitter(10, 10)
+		now := time.Now()
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 0, Message: "Hello", Role: st.ConversationRoleAgent, Time: now},
+		})
+		emitter.UpdateScreenAndEmitChanges("screen 1")
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 0, Message: "Hello", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "Hi", Role: st.ConversationRoleUser, Time: now},
+		})
+		emitter.UpdateStatusAndEmitChanges(st.ConversationStatusStable, mf.AgentTypeClaude)
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 0, Message: "Hello", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "Hi", Role: st.ConversationRoleUser, Time: now},
+			{Id: 2, Message: "Wor", Role: st.ConversationRoleAgent, Time: now},
+		})
+		emitter.UpdateScreenAndEmitChanges("screen 2")
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 0, Message: "Hello", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "Hi", Role: st.ConversationRoleUser, Time: now},
+			{Id: 2, Message: "World", Role: st.ConversationRoleAgent, Time: now},
+		})
+
+		// The subscriber received events up to the first screen update.
+		_, _, events := emitter.SubscribeSince(2)
+		assert.Equal(t, []Event{
+			{
+				Id:      3,
+				Type:    EventTypeMessageUpdate,
+				Payload: MessageUpdateBody{Id: 1, Message: "Hi", Parts: textParts("Hi"), Role: st.ConversationRoleUser, Time: now},
+			},
+			{
+				Id:      4,
+				Type:    EventTypeStatusChange,
+				Payload: StatusChangeBody{Status: AgentStatusStable, AgentType: mf.AgentTypeClaude},
+			},
+			// Only the latest update of message 2 is replayed.
+			{
+				Id:      7,
+				Type:    EventTypeMessageUpdate,
+				Payload: MessageUpdateBody{Id: 2, Message: "World", Parts: textParts("World"), Role: st.ConversationRoleAgent, Time: now},
+			},
+			{
+				Id:      7,
+				Type:    EventTypeScreenUpdate,
+				Payload: ScreenUpdateBody{Screen: "screen 2"},
+			},
+		}, events)
+
+		// The subscriber is up to date.
+		_, _, events = emitter.SubscribeSince(7)
+		assert.Equal(t, []Event{
+			{
+				Id:      7,
+				Type:    EventTypeScreenUpdate,
+				Payload: ScreenUpdateBody{Screen: "screen 2"},
+			},
+		}, events)
+
+		// The ID is from the future, e.g. from before a server restart.
+		_, _, events = emitter.SubscribeSince(100)
+		assert.Len(t, events, 5)
+		assert.Equal(t, EventTypeMessageUpdate, events[0].Type)
+	})
+
+	t.Run("resume-after-eviction", func(t *testing.T) {
+		emitter := NewEventEmitter(10, 2)
+		now := time.Now()
+		for i := range 4 {
+			emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+				{Id: 0, Message: fmt.Sprintf("Hello %d", i), Role: st.ConversationRoleAgent, Time: now},
+			})
+		}
+
+		// Events 1 and 2 were evicted from the replay log.
+		_, _, events := emitter.SubscribeSince(2)
+		assert.Equal(t, []Event{
+			{
+				Id:      4,
+				Type:    EventTypeMessageUpdate,
+				Payload: MessageUpdateBody{Id: 0, Message: "Hello 3", Parts: textParts("Hello 3"), Role: st.ConversationRoleAgent, Time: now},
+			},
+			{
+				Id:      4,
+				Type:    EventTypeScreenUpdate,
+				Payload: ScreenUpdateBody{Screen: ""},
+			},
+		}, events)
+
+		// Event 2 was missed, so the full state is returned instead.
+		_, _, events = emitter.SubscribeSince(1)
+		assert.Equal(t, []Event{
+			{
+				Id:      4,
+				Type:    EventTypeMessageUpdate,
+				Payload: MessageUpdateBody{Id: 0, Message: "Hello 3", Parts: textParts("Hello 3"), Role: st.ConversationRoleAgent, Time: now},
+			},
+			{
+				Id:      4,
+				Type:    EventTypeStatusChange,
+				Payload: StatusChangeBody{Status: AgentStatusRunning},
+			},
+			{
+				Id:      4,
+				Type:    EventTypeScreenUpdate,
+				Payload: ScreenUpdateBody{Screen: ""},
+			},
+		}, events)
+	})
+	t.Run("parts", func(t *testing.T) {
+		emitter := NewEventEmitter(10, 10)
+		_, ch, _ := emitter.Subscribe()
+		// The agent type is stored even though the status didn't change.
+		emitter.UpdateStatusAndEmitChanges(st.ConversationStatusChanging, mf.AgentTypeClaude)
+		now := time.Now()
+		emitter.UpdateMessagesAndEmitChanges([]st.ConversationMessage{
+			{Id: 0, Message: "⏺ Bash(ls)\n  ⎿  main.go", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "The agent was restarted.", Role: st.ConversationRoleSystem, Time: now},
+		})
+		newEvent := <-ch
+		assert.Equal(t, []mf.MessagePart{
+			{Type: mf.MessagePartTypeToolCall, Tool: "Bash", Content: "ls"},
+			{Type: mf.MessagePartTypeToolOutput, Content: "main.go"},
+		}, newEvent.Payload.(MessageUpdateBody).Parts)
+		newEvent = <-ch
+		assert.Equal(t, textParts("The agent was restarted."), newEvent.Payload.(MessageUpdateBody).Parts)
+	})
+	t.Run("pending-action", func(t *testing.T) {
+		emitter := NewEventEmitter(10, 10)
+		_, ch, _ := emitter.Subscribe()
+		action := &PendingAction{Id: 1, Question: "Do you want to proceed?"}
+		emitter.UpdatePendingActionAndEmitChanges(action)
+		emitter.UpdatePendingActionAndEmitChanges(action)
+		assert.Equal(t, Event{
+			Id:      1,
+			Type:    EventTypePendingAction,
+			Payload: PendingActionBody{PendingAction: action},
+		}, <-ch)
+
+		// New subscribers receive the pending action with the current state.
+		_, _, stateEvents := emitter.Subscribe()
+		assert.Contains(t, stateEvents, Event{
+			Id:      1,
+			Type:    EventTypePendingAction,
+			Payload: PendingActionBody{PendingAction: action},
+		})
+
+		emitter.UpdatePendingActionAndEmitChanges(nil)
+		assert.Equal(t, Event{
+			Id:      2,
+			Type:    EventTypePendingAction,
+			Payload: PendingActionBody{},
+		}, <-ch)
+		assert.Empty(t, ch)
+	})
+
+	t.Run("styled-screen", func(t *testing.T) {
+		emitter := NewEventEmitter(10, 10)
+		_, ch, stateEvents := emitter.Subscribe()
+		// There's no styled screen until the first update.
+		assert.Equal(t, EventTypeScreenUpdate, stateEvents[len(stateEvents)-1].Type)
+
+		screen := "\x1b[0;1;91merror\x1b[0m"
+		emitter.UpdateStyledScreenAndEmitChanges(screen)
+		emitter.UpdateStyledScreenAndEmitChanges(screen)
+		assert.Equal(t, Event{
+			Id:      1,
+			Type:    EventTypeStyledScreenUpdate,
+			Payload: ScreenUpdateBody{Screen: screen},
+		}, <-ch)
+		assert.Empty(t, ch)
+
+		_, _, stateEvents = emitter.Subscribe()
+		assert.Equal(t, Event{
+			Id:      1,
+			Type:    EventTypeStyledScreenUpdate,
+			Payload: ScreenUpdateBody{Screen: screen},
+		}, stateEvents[len(stateEvents)-1])
+
+		// Styled screens aren't replayed, but resuming subscribers get the current one.
+		_, _, stateEvents = emitter.SubscribeSince(1)
+		assert.Equal(t, []EventType{EventTypeScreenUpdate, EventTypeStyledScreenUpdate}, []EventType{stateEvents[0].Type, stateEvents[1].Type})
+		assert.Len(t, stateEvents, 2)
+	})
 }
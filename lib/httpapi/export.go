@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"strings"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatMarkdown ExportFormat = "md"
+	ExportFormatHTML     ExportFormat = "html"
+	ExportFormatJSON     ExportFormat = "json"
+)
+
+var ExportFormatValues = []ExportFormat{
+	ExportFormatMarkdown,
+	ExportFormatHTML,
+	ExportFormatJSON,
+}
+
+func (f ExportFormat) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ExportFormat", ExportFormatValues)
+}
+
+func (f ExportFormat) contentType() string {
+	switch f {
+	case ExportFormatHTML:
+		return "text/html; charset=utf-8"
+	case ExportFormatJSON:
+		return "application/json"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
+
+// conversationExport is the conversation as rendered by the export formats.
+type conversationExport struct {
+	AgentType  mf.AgentType `json:"agent_type"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Messages   []Message    `json:"messages"`
+}
+
+func newConversationExport(agentType mf.AgentType, messages []st.ConversationMessage, now time.Time) conversationExport {
+	export := conversationExport{
+		AgentType:  agentType,
+		ExportedAt: now.UTC(),
+		Messages:   make([]Message, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		export.Messages = append(export.Messages, Message{
+			Id:      msg.Id,
+			Role:    msg.Role,
+			Content: msg.Message,
+			Parts:   messageParts(agentType, msg),
+			Time:    msg.Time,
+		})
+	}
+	return export
+}
+
+func roleHeading(role st.ConversationRole) string {
+	switch role {
+	case st.ConversationRoleUser:
+		return "User"
+	case st.ConversationRoleAgent:
+		return "Agent"
+	default:
+		return "System"
+	}
+}
+
+func formatExportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// codeFence returns a fence that is longer than any run of backticks in content,
+// so the content can't close the code block.
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+func writeCodeBlock(b *strings.Builder, language string, content string) {
+	fence := codeFence(content)
+	fmt.Fprintf(b, "%s%s\n%s\n%s\n\n", fence, language, content, fence)
+}
+
+func renderMarkdown(export conversationExport) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Conversation with %s\n\nExported at %s.\n\n", export.AgentType, formatExportTime(export.ExportedAt))
+	for _, msg := range export.Messages {
+		fmt.Fprintf(&b, "## %s · %s\n\n", roleHeading(msg.Role), formatExportTime(msg.Time))
+		for _, part := range msg.Parts {
+			switch part.Type {
+			case mf.MessagePartTypeCode:
+				writeCodeBlock(&b, part.Language, part.Content)
+			case mf.MessagePartTypeDiff:
+				writeCodeBlock(&b, "diff", part.Content)
+			case mf.MessagePartTypeToolCall:
+				fmt.Fprintf(&b, "**Tool call: %s**\n\n", part.Tool)
+				writeCodeBlock(&b, "", part.Content)
+			case mf.MessagePartTypeToolOutput:
+				b.WriteString("**Tool output**\n\n")
+				writeCodeBlock(&b, "", part.Content)
+			default:
+				b.WriteString(part.Content + "\n\n")
+			}
+		}
+	}
+	return []byte(strings.TrimRight(b.String(), "\n") + "\n")
+}
+
+var exportHTMLTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"heading": roleHeading,
+	"time":    formatExportTime,
+	"iso":     func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Conversation with {{.AgentType}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; }
+section { border-top: 1px solid #ddd; padding: 0.5rem 0; }
+h2 { font-size: 1.1rem; }
+h2 time { color: #666; font-weight: normal; font-size: 0.9rem; }
+p { white-space: pre-wrap; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+.tool { font-weight: bold; margin-bottom: 0; }
+</style>
+</head>
+<body>
+<h1>Conversation with {{.AgentType}}</h1>
+<p>Exported at <time datetime="{{iso .ExportedAt}}">{{time .ExportedAt}}</time>.</p>
+{{- range .Messages}}
+<section class="message {{.Role}}">
+<h2>{{heading .Role}} <time datetime="{{iso .Time}}">{{time .Time}}</time></h2>
+{{- range .Parts}}
+{{- if eq .Type "code"}}
+<pre><code{{if .Language}} class="language-{{.Language}}"{{end}}>{{.Content}}</code></pre>
+{{- else if eq .Type "diff"}}
+<pre><code class="language-diff">{{.Content}}</code></pre>
+{{- else if eq .Type "tool_call"}}
+<p class="tool">Tool call: {{.Tool}}</p>
+<pre><code>{{.Content}}</code></pre>
+{{- else if eq .Type "tool_output"}}
+<p class="tool">Tool output</p>
+<pre><code>{{.Content}}</code></pre>
+{{- else}}
+<p>{{.Content}}</p>
+{{- end}}
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+func renderHTML(export conversationExport) ([]byte, error) {
+	var b bytes.Buffer
+	if err := exportHTMLTemplate.Execute(&b, export); err != nil {
+		return nil, xerrors.Errorf("failed to render HTML: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// exportConversation handles GET /export
+func (s *Server) exportConversation(ctx context.Context, input *ExportRequest) (*ExportResponse, error) {
+	sess := sessionFrom(ctx)
+	sess.mu.RLock()
+	export := newConversationExport(sess.agentType, sess.conversation.Messages(), time.Now())
+	sess.mu.RUnlock()
+
+	var body []byte
+	var err error
+	switch input.Format {
+	case ExportFormatHTML:
+		body, err = renderHTML(export)
+	case ExportFormatJSON:
+		body, err = json.MarshalIndent(export, "", "  ")
+	default:
+		body = renderMarkdown(export)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to export conversation: %w", err)
+	}
+
+	resp := &ExportResponse{}
+	resp.ContentType = input.Format.contentType()
+	resp.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("conversation-%s.%s", sess.id, input.Format),
+	})
+	resp.Body = body
+	return resp, nil
+}
@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+)
+
+// health reports the state of the session's agent process.
+func (sess *session) health() HealthBody {
+	body := HealthBody{
+		Status:            convertStatus(sess.conversation.Status()),
+		InitialPromptSent: sess.initialPromptSent.Load(),
+		Ready:             sess.everStable.Load(),
+	}
+	sess.mu.RLock()
+	process := sess.agentio
+	sess.mu.RUnlock()
+	if process != nil {
+		body.PtyReaderAlive = process.ReaderAlive()
+		if lastUpdate := process.LastScreenUpdate(); !lastUpdate.IsZero() {
+			body.LastScreenUpdate = &lastUpdate
+		}
+		if exitCode, exited := process.ExitCode(); exited {
+			body.ExitCode = &exitCode
+		}
+	}
+	body.Alive = body.PtyReaderAlive && body.ExitCode == nil
+	body.Ready = body.Alive && body.Ready && body.InitialPromptSent
+	return body
+}
+
+// getHealth handles GET /healthz
+func (s *Server) getHealth(ctx context.Context, input *struct{}) (*HealthResponse, error) {
+	resp := &HealthResponse{Body: sessionFrom(ctx).health()}
+	resp.Status = http.StatusOK
+	if !resp.Body.Alive {
+		resp.Status = http.StatusServiceUnavailable
+	}
+	return resp, nil
+}
+
+// getReadiness handles GET /readyz
+func (s *Server) getReadiness(ctx context.Context, input *struct{}) (*HealthResponse, error) {
+	resp := &HealthResponse{Body: sessionFrom(ctx).health()}
+	resp.Status = http.StatusOK
+	if !resp.Body.Ready {
+		resp.Status = http.StatusServiceUnavailable
+	}
+	return resp, nil
+}
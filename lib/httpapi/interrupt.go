@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"golang.org/x/xerrors"
+)
+
+// defaultInterruptTimeout is how long POST /interrupt waits for the agent to stop
+// if the caller doesn't set a timeout.
+const defaultInterruptTimeout = 10 * time.Second
+
+// interrupt handles POST /interrupt
+func (s *Server) interrupt(ctx context.Context, input *InterruptRequest) (*InterruptResponse, error) {
+	sess := sessionFrom(ctx)
+	resp := &InterruptResponse{}
+
+	sess.mu.Lock()
+	if sess.conversation.Status() == st.ConversationStatusStable {
+		sess.mu.Unlock()
+		// Sending Ctrl-C to an idle agent may make it exit.
+		resp.Body.Interrupted = false
+		resp.Body.Status = AgentStatusStable
+		return resp, nil
+	}
+	_, err := sess.agentio.Write([]byte(mf.ProfileOf(sess.agentType).InterruptKeys))
+	sess.mu.Unlock()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to send interrupt: %w", err)
+	}
+	sess.logger.Info("Sent interrupt")
+
+	timeout := defaultInterruptTimeout
+	if input.Timeout > 0 {
+		timeout = time.Duration(input.Timeout) * time.Second
+	}
+	stable, err := sess.waitForStable(ctx, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to wait for the agent to stop: %w", err)
+	}
+	resp.Body.Interrupted = stable
+	resp.Body.Status = convertStatus(sess.conversation.Status())
+	return resp, nil
+}
@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "agentapi"
+
+// metrics holds the Prometheus metrics of a server. Metrics that describe the
+// current state of a session, like its number of messages, are read from the
+// sessions when they are scraped.
+type metrics struct {
+	registry *prometheus.Registry
+
+	statusTransitions   *prometheus.CounterVec
+	statusSeconds       *prometheus.CounterVec
+	sendMessageDuration *prometheus.HistogramVec
+	sendMessageFailures *prometheus.CounterVec
+	snapshotDuration    *prometheus.HistogramVec
+	restarts            *prometheus.CounterVec
+	uploadBytes         prometheus.Counter
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		statusTransitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "status_transitions_total",
+			Help:      "Number of times the agent entered each status.",
+		}, []string{"session", "status"}),
+		statusSeconds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "status_seconds_total",
+			Help:      "Time the agent spent in each status.",
+		}, []string{"session", "status"}),
+		sendMessageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "send_message_duration_seconds",
+			Help:      "Time it took to send user messages to the agent, until the agent started processing them.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"session"}),
+		sendMessageFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "send_message_failures_total",
+			Help:      "Number of user messages that couldn't be sent to the agent, by reason.",
+		}, []string{"session", "reason"}),
+		snapshotDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "snapshot_loop_duration_seconds",
+			Help:      "Time it took the snapshot loop to persist and emit the state of the conversation.",
+			Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 1},
+		}, []string{"session"}),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "agent_restarts_total",
+			Help:      "Number of times the agent was restarted, either by the restart policy or through the API.",
+		}, []string{"session"}),
+		uploadBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upload_bytes_total",
+			Help:      "Number of bytes of uploaded files.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.statusTransitions,
+		m.statusSeconds,
+		m.sendMessageDuration,
+		m.sendMessageFailures,
+		m.snapshotDuration,
+		m.restarts,
+		m.uploadBytes,
+	)
+	return m
+}
+
+// collectSessions reports the state of the server's sessions when the metrics are scraped.
+func (m *metrics) collectSessions(s *Server) {
+	m.registry.MustRegister(&sessionCollector{server: s})
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeSendMessage records the outcome of sending a user message to the agent.
+func (m *metrics) observeSendMessage(sessionId string, duration time.Duration, err error) {
+	switch {
+	case err == nil:
+		m.sendMessageDuration.WithLabelValues(sessionId).Observe(duration.Seconds())
+	case errors.Is(err, st.MessageValidationErrorChanging):
+		m.sendMessageFailures.WithLabelValues(sessionId, "agent_busy").Inc()
+	case errors.Is(err, st.MessageValidationErrorEmpty), errors.Is(err, st.MessageValidationErrorWhitespace):
+		m.sendMessageFailures.WithLabelValues(sessionId, "invalid_message").Inc()
+	default:
+		// e.g. the agent didn't start processing the message in time
+		m.sendMessageFailures.WithLabelValues(sessionId, "write_failed").Inc()
+	}
+}
+
+// observeStatus records the status of the agent seen by an iteration of the
+// snapshot loop. elapsed is the time since the previous iteration, which is
+// attributed to the previous status.
+func (m *metrics) observeStatus(sessionId string, previous AgentStatus, current AgentStatus, elapsed time.Duration) {
+	if previous != "" {
+		m.statusSeconds.WithLabelValues(sessionId, string(previous)).Add(elapsed.Seconds())
+	}
+	if previous != current {
+		m.statusTransitions.WithLabelValues(sessionId, string(current)).Inc()
+	}
+}
+
+// forgetSession removes the metrics of a deleted session.
+func (m *metrics) forgetSession(sessionId string) {
+	labels := prometheus.Labels{"session": sessionId}
+	m.statusTransitions.DeletePartialMatch(labels)
+	m.statusSeconds.DeletePartialMatch(labels)
+	m.sendMessageDuration.DeletePartialMatch(labels)
+	m.sendMessageFailures.DeletePartialMatch(labels)
+	m.snapshotDuration.DeletePartialMatch(labels)
+	m.restarts.DeletePartialMatch(labels)
+}
+
+var (
+	messagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "messages"),
+		"Number of messages in the conversation, by role.",
+		[]string{"session", "role"}, nil,
+	)
+	subscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "event_subscribers"),
+		"Number of clients subscribed to events over SSE or WebSocket.",
+		[]string{"session"}, nil,
+	)
+	droppedChannelsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "event_subscribers_dropped_total"),
+		"Number of subscribers disconnected because they didn't keep up with the events.",
+		[]string{"session"}, nil,
+	)
+)
+
+// sessionCollector reports the current state of every session.
+type sessionCollector struct {
+	server *Server
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- messagesDesc
+	ch <- subscribersDesc
+	ch <- droppedChannelsDesc
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, sess := range c.server.sessionList() {
+		counts := map[st.ConversationRole]int{
+			st.ConversationRoleUser:   0,
+			st.ConversationRoleAgent:  0,
+			st.ConversationRoleSystem: 0,
+		}
+		for _, msg := range sess.conversation.Messages() {
+			counts[msg.Role]++
+		}
+		for role, count := range counts {
+			ch <- prometheus.MustNewConstMetric(messagesDesc, prometheus.GaugeValue, float64(count), sess.id, string(role))
+		}
+		subscribers, dropped := sess.emitter.Stats()
+		ch <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(subscribers), sess.id)
+		ch <- prometheus.MustNewConstMetric(droppedChannelsDesc, prometheus.CounterValue, float64(dropped), sess.id)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	mf "github.com/coder/agentapi/lib/msgfmt"
 	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/termexec"
 	"github.com/coder/agentapi/lib/util"
 	"github.com/danielgtaylor/huma/v2"
 )
@@ -30,14 +31,16 @@ type Message struct {
 	Id      int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
 	Content string              `json:"content" example:"Hello world" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
 	Role    st.ConversationRole `json:"role" doc:"Role of the message author"`
+	Parts   []mf.MessagePart    `json:"parts" nullable:"false" doc:"Message content split into text, code blocks, tool calls, tool output, and diffs."`
 	Time    time.Time           `json:"time" doc:"Timestamp of the message"`
 }
 
 // StatusResponse represents the server status
 type StatusResponse struct {
 	Body struct {
-		Status    AgentStatus  `json:"status" doc:"Current agent status. 'running' means that the agent is processing a message, 'stable' means that the agent is idle and waiting for input."`
-		AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent being used by the server."`
+		Status        AgentStatus    `json:"status" doc:"Current agent status. 'running' means that the agent is processing a message, 'stable' means that the agent is idle and waiting for input."`
+		AgentType     mf.AgentType   `json:"agent_type" doc:"Type of the agent being used by the server."`
+		PendingAction *PendingAction `json:"pending_action,omitempty" doc:"Permission prompt the agent is waiting on. Only set if the agent is stable because it needs approval to continue."`
 	}
 }
 
@@ -49,8 +52,11 @@ type MessagesResponse struct {
 }
 
 type MessageRequestBody struct {
-	Content string      `json:"content" example:"Hello, agent!" doc:"Message content"`
-	Type    MessageType `json:"type" doc:"A 'user' type message will be logged as a user message in the conversation history and submitted to the agent. AgentAPI will wait until the agent starts carrying out the task described in the message before responding. A 'raw' type message will be written directly to the agent's terminal session as keystrokes and will not be saved in the conversation history. 'raw' messages are useful for sending escape sequences to the terminal."`
+	Content     string      `json:"content" example:"Hello, agent!" doc:"Message content"`
+	Type        MessageType `json:"type" doc:"A 'user' type message will be logged as a user message in the conversation history and submitted to the agent. AgentAPI will wait until the agent starts carrying out the task described in the message before responding. A 'raw' type message will be written directly to the agent's terminal session as keystrokes and will not be saved in the conversation history. 'raw' messages are useful for sending escape sequences to the terminal."`
+	Queue       bool        `json:"queue,omitempty" doc:"If true, a 'user' type message is added to the message queue and the endpoint responds immediately, even if the agent is running. Queued messages are sent to the agent in order, each one once the agent becomes stable."`
+	Wait        bool        `json:"wait,omitempty" doc:"If true, the endpoint waits until the agent has finished replying to a 'user' type message, i.e. until its status is 'stable' again, and returns the reply. If the agent doesn't finish in time, the endpoint responds with a 504 error that contains the partial reply."`
+	WaitTimeout int         `json:"wait_timeout,omitempty" minimum:"0" doc:"Maximum number of seconds to wait for the reply when 'wait' is true. Defaults to 600."`
 }
 
 // MessageRequest represents a request to create a new message
@@ -61,17 +67,276 @@ type MessageRequest struct {
 // MessageResponse represents a newly created message
 type MessageResponse struct {
 	Body struct {
-		Ok bool `json:"ok" doc:"Indicates whether the message was sent successfully. For messages of type 'user', success means detecting that the agent began executing the task described. For messages of type 'raw', success means the keystrokes were sent to the terminal."`
+		Ok      bool     `json:"ok" doc:"Indicates whether the message was sent successfully. For messages of type 'user', success means detecting that the agent began executing the task described. For messages of type 'raw', success means the keystrokes were sent to the terminal. For queued messages, success means the message was added to the queue."`
+		QueueId int      `json:"queue_id,omitempty" doc:"ID of the queued message. Only set if the message was queued."`
+		Reply   *Message `json:"reply,omitempty" doc:"The agent's reply to the message. Only set if 'wait' was true."`
 	}
 }
 
+// ReplyTimeoutError is returned by POST /message when 'wait' is true and the
+// agent doesn't finish replying before the timeout.
+type ReplyTimeoutError struct {
+	huma.ErrorModel
+	Reply *Message `json:"reply,omitempty" doc:"The agent's reply so far."`
+}
+
+type InterruptRequest struct {
+	Timeout int `query:"timeout" minimum:"0" doc:"Maximum number of seconds to wait for the agent to stop. Defaults to 10."`
+}
+
+type InterruptResponse struct {
+	Body struct {
+		Interrupted bool        `json:"interrupted" doc:"Indicates whether the agent stopped, i.e. its status became 'stable' before the timeout. False if the agent wasn't running."`
+		Status      AgentStatus `json:"status" doc:"Agent status after the interrupt."`
+	}
+}
+
+type HealthBody struct {
+	Alive             bool        `json:"alive" doc:"Whether the agent process is running and its output is being read. False if the agent exited."`
+	Ready             bool        `json:"ready" doc:"Whether the agent is alive, has finished starting up, and has received the initial prompt, if any."`
+	Status            AgentStatus `json:"status" doc:"Current agent status."`
+	PtyReaderAlive    bool        `json:"pty_reader_alive" doc:"Whether the agent's terminal output is being read. If not, the screen and the conversation are no longer updated."`
+	LastScreenUpdate  *time.Time  `json:"last_screen_update,omitempty" doc:"Time the agent last wrote to its terminal."`
+	InitialPromptSent bool        `json:"initial_prompt_sent" doc:"Whether the initial prompt was sent to the agent. True if there is no initial prompt."`
+	ExitCode          *int        `json:"exit_code,omitempty" doc:"Exit code of the agent process. Only set once the process has exited. -1 if the process was terminated by a signal."`
+}
+
+// HealthResponse is returned with a 503 status code if the check fails.
+type HealthResponse struct {
+	Status int
+	Body   HealthBody
+}
+
+// QueuedMessage is a user message waiting to be sent to the agent
+type QueuedMessage struct {
+	Id        int       `json:"id" doc:"Unique identifier of the queued message."`
+	Content   string    `json:"content" doc:"Message content"`
+	CreatedAt time.Time `json:"created_at" doc:"Time the message was queued"`
+}
+
+// QueueResponse represents the list of queued messages
+type QueueResponse struct {
+	Body struct {
+		Messages []QueuedMessage `json:"messages" nullable:"false" doc:"Queued messages, in the order they will be sent to the agent"`
+	}
+}
+
+type DeleteQueuedMessageRequest struct {
+	Id int `path:"id" doc:"ID of the queued message"`
+}
+
+type DeleteQueuedMessageResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the message was removed from the queue."`
+	}
+}
+
+// UploadTarget selects where uploaded files are stored.
+type UploadTarget string
+
+const (
+	// UploadTargetTemp stores files in a temporary directory managed by the server.
+	UploadTargetTemp UploadTarget = "temp"
+	// UploadTargetWorkdir stores files in the agent's working directory.
+	UploadTargetWorkdir UploadTarget = "workdir"
+)
+
+var UploadTargetValues = []UploadTarget{
+	UploadTargetTemp,
+	UploadTargetWorkdir,
+}
+
+func (t UploadTarget) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "UploadTarget", UploadTargetValues)
+}
+
 type UploadResponse struct {
 	Body struct {
-		Ok       bool   `json:"ok" doc:"Indicates whether the files were uploaded successfully."`
-		FilePath string `json:"filePath" doc:"Path of the file"`
+		Ok       bool         `json:"ok" doc:"Indicates whether the files were uploaded successfully."`
+		FilePath string       `json:"filePath" doc:"Path of the first file"`
+		Files    []UploadInfo `json:"files" nullable:"false" doc:"Uploaded files, in the order they were sent"`
 	}
 }
 
 type UploadRequest struct {
-	File huma.FormFile `form:"file" required:"true" doc:"file that needs to be uploaded"`
+	Files     []huma.FormFile `form:"file" required:"true" doc:"files that need to be uploaded. Repeat the field to upload multiple files."`
+	Target    UploadTarget    `form:"target" doc:"Where the files are stored: 'temp' (the default) for a temporary directory, 'workdir' for the agent's working directory."`
+	Path      string          `form:"path" doc:"Directory the files are stored in, relative to the agent's working directory. Only used with the 'workdir' target. Defaults to the working directory itself."`
+	Overwrite bool            `form:"overwrite" doc:"Whether existing files in the working directory may be overwritten."`
+}
+
+// UploadInfo describes an uploaded file
+type UploadInfo struct {
+	Id          string       `json:"id" doc:"Unique identifier of the upload."`
+	Name        string       `json:"name" doc:"Name of the file."`
+	Path        string       `json:"path" doc:"Path of the file on the server, which can be passed to the agent."`
+	Target      UploadTarget `json:"target" doc:"Where the file is stored."`
+	Size        int64        `json:"size" doc:"Size of the file in bytes."`
+	ContentType string       `json:"content_type" doc:"Media type of the file, as sent by the client or detected from its content."`
+	Sha256      string       `json:"sha256" doc:"Hex-encoded SHA-256 checksum of the file."`
+	UploadedAt  time.Time    `json:"uploaded_at" doc:"Time the file was uploaded."`
+}
+
+// UploadsResponse represents the list of uploaded files
+type UploadsResponse struct {
+	Body struct {
+		Uploads   []UploadInfo `json:"uploads" nullable:"false" doc:"Uploaded files, oldest first."`
+		TotalSize int64        `json:"total_size" doc:"Total size of the uploaded files in bytes."`
+		Quota     int64        `json:"quota,omitempty" doc:"Maximum total size of the uploaded files in bytes. Omitted if there's no quota."`
+	}
+}
+
+// UploadRequestPath addresses a single uploaded file
+type UploadRequestPath struct {
+	Id string `path:"id" doc:"ID of the upload"`
+}
+
+// DownloadUploadResponse contains the content of an uploaded file
+type DownloadUploadResponse struct {
+	ContentType        string `header:"Content-Type"`
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               []byte
+}
+
+// DeleteUploadResponse represents the result of deleting an uploaded file
+type DeleteUploadResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the file was deleted."`
+	}
+}
+
+// ExportRequest selects the format of the exported conversation
+type ExportRequest struct {
+	Format ExportFormat `query:"format" default:"md" doc:"Format of the export: 'md' for Markdown, 'html' for a standalone HTML page, or 'json'."`
+}
+
+// ExportResponse contains the rendered conversation
+type ExportResponse struct {
+	ContentType        string `header:"Content-Type"`
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               []byte
+}
+
+// PendingAction is a permission prompt the agent is waiting on
+type PendingAction struct {
+	Id        int            `json:"id" doc:"Unique identifier of the action."`
+	Question  string         `json:"question" example:"Do you want to proceed?" doc:"Question asked by the agent."`
+	Details   string         `json:"details,omitempty" doc:"Description of the action as shown by the agent, e.g. the command it wants to run."`
+	Options   []ActionOption `json:"options" nullable:"false" doc:"Decisions the agent accepts."`
+	CreatedAt time.Time      `json:"created_at" doc:"Time the prompt was detected."`
+}
+
+// ActionOption is an answer to a permission prompt
+type ActionOption struct {
+	Decision mf.ActionDecision `json:"decision" doc:"Decision to pass to POST /actions/{id} to select the option."`
+	Label    string            `json:"label" doc:"Text of the option, as shown by the agent."`
+}
+
+type ActionRequestBody struct {
+	Decision mf.ActionDecision `json:"decision" doc:"'approve' lets the agent carry out the action once, 'deny' stops it, and 'always' lets it carry out similar actions without asking again. The decision must be one of the pending action's options."`
+}
+
+// ActionRequest represents the answer to a pending action
+type ActionRequest struct {
+	Id   int               `path:"id" doc:"ID of the pending action"`
+	Body ActionRequestBody `json:"body"`
+}
+
+type ActionResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the decision was sent to the agent."`
+	}
+}
+
+// SessionInfo describes an agent session
+type SessionInfo struct {
+	Id        string       `json:"id" doc:"Unique identifier of the session."`
+	AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent running in the session."`
+	Status    AgentStatus  `json:"status" doc:"Current agent status."`
+	CreatedAt time.Time    `json:"created_at" doc:"Timestamp of when the session was created."`
+}
+
+// SessionsResponse represents the list of sessions
+type SessionsResponse struct {
+	Body struct {
+		Sessions []SessionInfo `json:"sessions" nullable:"false" doc:"List of sessions"`
+	}
+}
+
+// SessionResponse represents a single session
+type SessionResponse struct {
+	Body SessionInfo
+}
+
+// SessionRequest addresses a single session
+type SessionRequest struct {
+	SessionID string `path:"sessionId" doc:"ID of the session"`
+}
+
+type CreateSessionRequestBody struct {
+	Program       string        `json:"program" example:"claude" doc:"Agent program to run."`
+	Args          []string      `json:"args,omitempty" doc:"Arguments passed to the agent program."`
+	AgentType     mf.AgentType  `json:"agent_type,omitempty" example:"claude" doc:"Type of the agent, the name or alias of an agent profile. Defaults to 'custom'."`
+	InitialPrompt string        `json:"initial_prompt,omitempty" doc:"Initial prompt sent to the agent once it's ready for input."`
+	Restart       RestartPolicy `json:"restart,omitempty" doc:"Whether the agent is restarted when it exits. Defaults to 'never'."`
+}
+
+// CreateSessionRequest represents a request to start a new agent session
+type CreateSessionRequest struct {
+	Body CreateSessionRequestBody `json:"body" doc:"Agent to run in the session"`
+}
+
+// DeleteSessionResponse represents the result of stopping a session
+type DeleteSessionResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the session was stopped."`
+	}
+}
+
+// RestartResponse represents the result of restarting the agent
+type RestartResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the agent was restarted."`
+	}
+}
+
+// SubscribeEventsRequest represents a request to subscribe to an event stream
+type SubscribeEventsRequest struct {
+	LastEventID int `header:"Last-Event-ID" doc:"ID of the last event received on a previous connection. If the events emitted since then are still available, only those events are sent instead of the full state."`
+}
+
+type SubscribeScreenRequest struct {
+	LastEventID int          `header:"Last-Event-ID" doc:"ID of the last event received on a previous connection. The first event after reconnecting always contains the whole screen."`
+	Mode        ScreenMode   `query:"mode" default:"full" doc:"Whether to send the whole screen on every change, or only the rows that changed."`
+	Format      ScreenFormat `query:"format" default:"text" doc:"'text' sends the screen without colors, 'ansi' keeps its colors and text attributes as ANSI escape sequences. The 'runs' format isn't supported."`
+}
+
+// ScreenRequest selects the format of the screen
+type ScreenRequest struct {
+	Format ScreenFormat `query:"format" default:"text" doc:"'text' for the text of the screen, 'ansi' for the text with ANSI escape sequences for colors and text attributes, or 'runs' for rows of styled text runs."`
+}
+
+// ScreenResponse contains the agent's terminal screen
+type ScreenResponse struct {
+	Body struct {
+		Format ScreenFormat           `json:"format" doc:"Format of the screen."`
+		Screen string                 `json:"screen" doc:"Screen as text, with ANSI escape sequences in the 'ansi' format. Empty in the 'runs' format."`
+		Rows   [][]termexec.StyledRun `json:"rows,omitempty" doc:"Rows of the screen, each made of runs of text that share the same colors and attributes. Only set in the 'runs' format. Trailing blank cells and rows are left out."`
+	}
+}
+
+// TerminalSizeRequest sets the size of the agent's terminal
+type TerminalSizeRequest struct {
+	Body struct {
+		Width  uint16 `json:"width,omitempty" minimum:"10" maximum:"1000" doc:"Width of the terminal in columns. The current width is kept if it's omitted."`
+		Height uint16 `json:"height,omitempty" minimum:"10" maximum:"2000" doc:"Height of the terminal in rows. The current height is kept if it's omitted."`
+	}
+}
+
+// TerminalSizeResponse contains the size of the agent's terminal
+type TerminalSizeResponse struct {
+	Body struct {
+		Width  uint16 `json:"width" doc:"Width of the terminal in columns."`
+		Height uint16 `json:"height" doc:"Height of the terminal in rows."`
+	}
 }
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"sync"
+	"time"
+)
+
+// messageQueue holds user messages that wait for the agent to become stable.
+// Messages are delivered by the snapshot loop in the order they were queued.
+type messageQueue struct {
+	mu     sync.Mutex
+	nextId int
+	items  []QueuedMessage
+}
+
+func newMessageQueue() *messageQueue {
+	return &messageQueue{nextId: 1}
+}
+
+func (q *messageQueue) push(content string) QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	msg := QueuedMessage{
+		Id:        q.nextId,
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	q.nextId++
+	q.items = append(q.items, msg)
+	return msg
+}
+
+// peek returns the message at the front of the queue without removing it.
+func (q *messageQueue) peek() (QueuedMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return QueuedMessage{}, false
+	}
+	return q.items[0], true
+}
+
+// remove removes the message with the given ID. It returns false if the
+// message isn't queued, e.g. because it was already delivered.
+func (q *messageQueue) remove(id int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, msg := range q.items {
+		if msg.Id == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *messageQueue) list() []QueuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]QueuedMessage, len(q.items))
+	copy(items, q.items)
+	return items
+}
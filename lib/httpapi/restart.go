@@ -0,0 +1,188 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// RestartPolicy decides whether an agent is restarted when it exits.
+type RestartPolicy string
+
+const (
+	RestartPolicyNever     RestartPolicy = "never"
+	RestartPolicyOnFailure RestartPolicy = "on-failure"
+	RestartPolicyAlways    RestartPolicy = "always"
+)
+
+var RestartPolicyValues = []RestartPolicy{
+	RestartPolicyNever,
+	RestartPolicyOnFailure,
+	RestartPolicyAlways,
+}
+
+func (p RestartPolicy) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "RestartPolicy", RestartPolicyValues)
+}
+
+// ParseRestartPolicy validates a restart policy. An empty policy means never.
+func ParseRestartPolicy(value string) (RestartPolicy, error) {
+	if value == "" {
+		return RestartPolicyNever, nil
+	}
+	for _, policy := range RestartPolicyValues {
+		if string(policy) == value {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid restart policy '%s' (one of: never, on-failure, always)", value)
+}
+
+func (p RestartPolicy) shouldRestart(exitCode int) bool {
+	switch p {
+	case RestartPolicyAlways:
+		return true
+	case RestartPolicyOnFailure:
+		return exitCode != 0
+	default:
+		return false
+	}
+}
+
+const (
+	minRestartBackoff = 1 * time.Second
+	maxRestartBackoff = 1 * time.Minute
+	// An agent that ran for longer than this is considered to have started
+	// successfully, so its next restart isn't delayed.
+	restartBackoffResetAfter = 1 * time.Minute
+)
+
+// restartBackoff returns how long to wait before the given consecutive restart.
+func restartBackoff(attempt int) time.Duration {
+	backoff := minRestartBackoff
+	for i := 1; i < attempt && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRestartBackoff)
+}
+
+// supervise restarts the agent according to the session's restart policy
+// whenever it exits. It closes done when the agent exits for good or ctx is canceled.
+func (sess *session) supervise(ctx context.Context, done chan struct{}) {
+	defer sess.loops.Done()
+	defer close(done)
+	attempt := 0
+	for {
+		sess.mu.RLock()
+		process := sess.agentio
+		startedAt := sess.agentStartedAt
+		sess.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-process.Exited():
+		}
+		sess.mu.Lock()
+		if sess.agentio != process {
+			// The agent was restarted through the API.
+			sess.mu.Unlock()
+			attempt = 0
+			continue
+		}
+		exitCode, _ := process.ExitCode()
+		if !sess.restartPolicy.shouldRestart(exitCode) {
+			sess.logger.Info("Agent exited", "exitCode", exitCode)
+			if err := process.Wait(); errors.Is(err, termexec.ErrNonZeroExitCode) {
+				sess.agentErr = xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
+			} else if err != nil {
+				sess.agentErr = xerrors.Errorf("failed to wait for process: %w", err)
+			}
+			sess.mu.Unlock()
+			return
+		}
+		sess.mu.Unlock()
+
+		if time.Since(startedAt) > restartBackoffResetAfter {
+			attempt = 0
+		}
+		reason := fmt.Sprintf("The agent exited with code %d and was restarted.", exitCode)
+		for {
+			attempt++
+			backoff := restartBackoff(attempt)
+			sess.logger.Info("Restarting agent", "exitCode", exitCode, "attempt", attempt, "backoff", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			err := sess.restartAgent(reason)
+			if err == nil {
+				break
+			}
+			sess.logger.Error("Failed to restart agent", "error", err)
+		}
+	}
+}
+
+// restartAgent stops the agent if it's still running, starts it again in a new
+// terminal, and adds a message with the given reason to the conversation.
+// If the agent had exited for good, the supervisor is started again.
+func (sess *session) restartAgent(reason string) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.loopCtx.Err() != nil {
+		return xerrors.New("the session is closed")
+	}
+	if err := sess.agentio.Close(sess.logger, 5*time.Second); err != nil {
+		sess.logger.Error("Failed to close agent process", "error", err)
+	}
+	process, err := StartAgentProcess(sess.loopCtx, sess.processConfig)
+	if err != nil {
+		return xerrors.Errorf("failed to start agent: %w", err)
+	}
+	sess.agentio = process
+	sess.agentStartedAt = time.Now()
+	sess.everStable.Store(false)
+	sess.conversation.ReplaceAgentIO(process, reason)
+	sess.emitter.EmitStatus(sess.conversation.Status(), sess.agentType)
+	sess.metrics.restarts.WithLabelValues(sess.id).Inc()
+	sess.logger.Info("Restarted agent")
+
+	select {
+	case <-sess.agentDone:
+		sess.agentDone = make(chan struct{})
+		sess.agentErr = nil
+		sess.loops.Add(1)
+		go sess.supervise(sess.loopCtx, sess.agentDone)
+	default:
+	}
+	return nil
+}
+
+// restart handles POST /restart
+func (s *Server) restart(ctx context.Context, input *struct{}) (*RestartResponse, error) {
+	sess := sessionFrom(ctx)
+	// restartAgent replaces the agent under the lock.
+	sess.mu.RLock()
+	restartable := sess.agentio != nil && sess.loopCtx != nil && sess.processConfig.Program != ""
+	sess.mu.RUnlock()
+	if !restartable {
+		return nil, huma.Error400BadRequest("the agent of this session can't be restarted")
+	}
+	if err := sess.restartAgent("The agent was restarted."); err != nil {
+		return nil, xerrors.Errorf("failed to restart agent: %w", err)
+	}
+
+	resp := &RestartResponse{}
+	resp.Body.Ok = true
+	return resp, nil
+}
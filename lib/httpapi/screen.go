@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"context"
+	"strings"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// ScreenFormat selects how the screen is encoded.
+type ScreenFormat string
+
+const (
+	// ScreenFormatText is the text of the screen, without colors.
+	ScreenFormatText ScreenFormat = "text"
+	// ScreenFormatANSI is the text of the screen with ANSI escape sequences
+	// for colors and text attributes.
+	ScreenFormatANSI ScreenFormat = "ansi"
+	// ScreenFormatRuns is a list of rows, each made of runs of text that share
+	// the same colors and attributes.
+	ScreenFormatRuns ScreenFormat = "runs"
+)
+
+var ScreenFormatValues = []ScreenFormat{
+	ScreenFormatText,
+	ScreenFormatANSI,
+	ScreenFormatRuns,
+}
+
+func (f ScreenFormat) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ScreenFormat", ScreenFormatValues)
+}
+
+// eventType returns the type of the events that carry the screen in the format.
+func (f ScreenFormat) eventType() EventType {
+	if f == ScreenFormatANSI {
+		return EventTypeStyledScreenUpdate
+	}
+	return EventTypeScreenUpdate
+}
+
+// Resolve rejects the formats that can't be streamed.
+func (r *SubscribeScreenRequest) Resolve(ctx huma.Context) []error {
+	if r.Format == ScreenFormatRuns {
+		return []error{&huma.ErrorDetail{
+			Location: "query.format",
+			Message:  "the screen can only be streamed in the 'text' and 'ansi' formats",
+			Value:    r.Format,
+		}}
+	}
+	return nil
+}
+
+// styledScreen reads the screen of the agent with its colors and attributes.
+// It returns no rows if the session has no agent.
+func (sess *session) styledScreen() [][]termexec.StyledRun {
+	sess.mu.RLock()
+	process := sess.agentio
+	sess.mu.RUnlock()
+	if process == nil {
+		return nil
+	}
+	return process.ReadStyledScreen()
+}
+
+// getScreen handles GET /screen
+func (s *Server) getScreen(ctx context.Context, input *ScreenRequest) (*ScreenResponse, error) {
+	sess := sessionFrom(ctx)
+	resp := &ScreenResponse{}
+	resp.Body.Format = input.Format
+	switch input.Format {
+	case ScreenFormatANSI:
+		resp.Body.Screen = termexec.EncodeANSI(sess.styledScreen())
+	case ScreenFormatRuns:
+		resp.Body.Rows = sess.styledScreen()
+		if resp.Body.Rows == nil {
+			resp.Body.Rows = [][]termexec.StyledRun{}
+		}
+	default:
+		resp.Body.Screen = strings.TrimRight(sess.conversation.Screen(), mf.WhiteSpaceChars)
+	}
+	return resp, nil
+}
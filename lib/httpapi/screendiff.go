@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"strings"
+
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// ScreenMode selects how screen updates are sent to subscribers.
+type ScreenMode string
+
+const (
+	// ScreenModeFull sends the whole screen on every change.
+	ScreenModeFull ScreenMode = "full"
+	// ScreenModeDiff sends the whole screen once, then only the rows that changed.
+	ScreenModeDiff ScreenMode = "diff"
+)
+
+var ScreenModeValues = []ScreenMode{
+	ScreenModeFull,
+	ScreenModeDiff,
+}
+
+func (m ScreenMode) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ScreenMode", ScreenModeValues)
+}
+
+// EventTypeScreenPatch events are sent instead of screen updates to subscribers in diff mode.
+const EventTypeScreenPatch EventType = "screen_patch"
+
+type ScreenRowPatch struct {
+	Row     int    `json:"row" doc:"Zero-based index of the row"`
+	Content string `json:"content" doc:"New content of the row"`
+}
+
+type ScreenPatchBody struct {
+	Height int              `json:"height" doc:"Number of rows of the screen after the patch is applied. Rows past the height are removed."`
+	Rows   []ScreenRowPatch `json:"rows" nullable:"false" doc:"Rows that changed since the previous update"`
+}
+
+// screenDiffer turns the screen updates sent to a subscriber into patches
+// relative to the previous update.
+type screenDiffer struct {
+	rows []string
+}
+
+// next returns the payload to send for the screen update: the full screen,
+// i.e. a keyframe, the first time, and a patch afterwards.
+func (d *screenDiffer) next(update ScreenUpdateBody) (EventType, any) {
+	rows := strings.Split(update.Screen, "\n")
+	previous := d.rows
+	d.rows = rows
+	if previous == nil {
+		return EventTypeScreenUpdate, update
+	}
+	patch := ScreenPatchBody{Height: len(rows), Rows: []ScreenRowPatch{}}
+	for i, row := range rows {
+		if i < len(previous) && previous[i] == row {
+			continue
+		}
+		patch.Rows = append(patch.Rows, ScreenRowPatch{Row: i, Content: row})
+	}
+	return EventTypeScreenPatch, patch
+}
+
+// ApplyScreenPatch applies a patch to the rows of a screen and returns the new rows.
+func ApplyScreenPatch(rows []string, patch ScreenPatchBody) []string {
+	next := make([]string, patch.Height)
+	copy(next, rows)
+	for _, row := range patch.Rows {
+		if row.Row >= 0 && row.Row < len(next) {
+			next[row.Row] = row.Content
+		}
+	}
+	return next
+}
@@ -0,0 +1,56 @@
+package httpapi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScreenDiffer(t *testing.T) {
+	t.Run("keyframe then patches", func(t *testing.T) {
+		differ := &screenDiffer{}
+
+		eventType, payload := differ.next(ScreenUpdateBody{Screen: "a\nb\nc"})
+		assert.Equal(t, EventTypeScreenUpdate, eventType)
+		assert.Equal(t, ScreenUpdateBody{Screen: "a\nb\nc"}, payload)
+
+		eventType, payload = differ.next(ScreenUpdateBody{Screen: "a\nB\nc\nd"})
+		assert.Equal(t, EventTypeScreenPatch, eventType)
+		assert.Equal(t, ScreenPatchBody{
+			Height: 4,
+			Rows:   []ScreenRowPatch{{Row: 1, Content: "B"}, {Row: 3, Content: "d"}},
+		}, payload)
+
+		eventType, payload = differ.next(ScreenUpdateBody{Screen: "a"})
+		assert.Equal(t, EventTypeScreenPatch, eventType)
+		assert.Equal(t, ScreenPatchBody{Height: 1, Rows: []ScreenRowPatch{}}, payload)
+	})
+
+	t.Run("patches reproduce the screens", func(t *testing.T) {
+		screens := []string{
+			"",
+			"$ claude",
+			"$ claude\n\n> hello\n\n● Hi! How can I help?",
+			"$ claude\n\n> hello\n\n● Hi! How can I help?\n\n> ",
+			"● Working",
+			"",
+			"line 1\nline 2\nline 3",
+		}
+		differ := &screenDiffer{}
+		var rows []string
+		for _, screen := range screens {
+			eventType, payload := differ.next(ScreenUpdateBody{Screen: screen})
+			switch eventType {
+			case EventTypeScreenUpdate:
+				rows = strings.Split(payload.(ScreenUpdateBody).Screen, "\n")
+			case EventTypeScreenPatch:
+				rows = ApplyScreenPatch(rows, payload.(ScreenPatchBody))
+			default:
+				require.Failf(t, "unexpected event type", "%s", eventType)
+			}
+			assert.Equal(t, screen, strings.Join(rows, "\n"))
+		}
+	})
+}
@@ -2,11 +2,8 @@ package httpapi
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -22,6 +19,7 @@ import (
 	"github.com/coder/agentapi/internal/version"
 	"github.com/coder/agentapi/lib/logctx"
 	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/policy"
 	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/agentapi/lib/termexec"
 	"github.com/danielgtaylor/huma/v2"
@@ -34,18 +32,29 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	router       chi.Router
-	api          huma.API
-	port         int
-	srv          *http.Server
-	mu           sync.RWMutex
-	logger       *slog.Logger
-	conversation *st.Conversation
-	agentio      *termexec.Process
-	agentType    mf.AgentType
-	emitter      *EventEmitter
-	chatBasePath string
-	tempDir      string
+	// ctx is the context the server was created with. It's used to start
+	// sessions, which outlive the requests that create them.
+	ctx             context.Context
+	router          chi.Router
+	api             huma.API
+	port            int
+	srv             *http.Server
+	logger          *slog.Logger
+	sessionsMu      sync.RWMutex
+	sessions        map[string]*session
+	defaultSession  *session
+	terminalWidth   uint16
+	terminalHeight  uint16
+	scrollbackLines int
+	chatBasePath    string
+	tempDir         string
+	uploads         *uploadStore
+	allowedOrigins  []string
+	// auth is nil if authentication is disabled.
+	auth    *bearerTokenAuth
+	metrics *metrics
+	// approvalPolicy is nil if permission prompts are only answered through the API.
+	approvalPolicy *policy.Policy
 }
 
 func (s *Server) NormalizeSchema(schema any) any {
@@ -102,12 +111,43 @@ type ServerConfig struct {
 	AllowedHosts   []string
 	AllowedOrigins []string
 	InitialPrompt  string
+	// TerminalWidth and TerminalHeight set the terminal size of agents
+	// started through the sessions API.
+	TerminalWidth  uint16
+	TerminalHeight uint16
+	// ScrollbackLines is the number of lines that scrolled off the top of the
+	// terminal of agents started through the sessions API to keep.
+	// The scrollback is disabled if it's 0.
+	ScrollbackLines int
+	// AuthToken, if set, is required as a bearer token on every API request.
+	AuthToken string
+	// StateDir, if set, is where the conversation history of the default session
+	// is persisted, so it can be restored when the server restarts.
+	StateDir string
+	// ProcessConfig is the configuration Process was started with. It's used
+	// to start the agent again when it's restarted.
+	ProcessConfig SetupProcessConfig
+	// RestartPolicy decides whether the agent is restarted when it exits.
+	RestartPolicy RestartPolicy
+	// UploadMaxFileSize is the size limit of a single uploaded file in bytes.
+	// It defaults to DefaultUploadMaxFileSize.
+	UploadMaxFileSize int64
+	// UploadQuota is the maximum total size of the uploaded files in bytes.
+	// Zero means no quota.
+	UploadQuota int64
+	// WorkDir is the agent's working directory. Files can be uploaded into it
+	// if it's set.
+	WorkDir string
+	// ApprovalPolicy, if set, answers the permission prompts of the agents
+	// of all sessions.
+	ApprovalPolicy *policy.Policy
 }
 
-// Validate allowed hosts don't contain whitespace, commas, schemes, or ports.
+// ParseAllowedHosts validates that allowed hosts don't contain whitespace, commas,
+// schemes, or ports, and returns their hostnames.
 // Viper/Cobra use different separators (space for env vars, comma for flags),
 // so these characters likely indicate user error.
-func parseAllowedHosts(input []string) ([]string, error) {
+func ParseAllowedHosts(input []string) ([]string, error) {
 	if len(input) == 0 {
 		return nil, fmt.Errorf("the list must not be empty")
 	}
@@ -156,8 +196,8 @@ func parseAllowedHosts(input []string) ([]string, error) {
 	return hostStrings, nil
 }
 
-// Validate allowed origins
-func parseAllowedOrigins(input []string) ([]string, error) {
+// ParseAllowedOrigins validates allowed origins and returns them as scheme://host.
+func ParseAllowedOrigins(input []string) ([]string, error) {
 	if len(input) == 0 {
 		return nil, fmt.Errorf("the list must not be empty")
 	}
@@ -194,11 +234,11 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 
 	logger := logctx.From(ctx)
 
-	allowedHosts, err := parseAllowedHosts(config.AllowedHosts)
+	allowedHosts, err := ParseAllowedHosts(config.AllowedHosts)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to parse allowed hosts: %w", err)
 	}
-	allowedOrigins, err := parseAllowedOrigins(config.AllowedOrigins)
+	allowedOrigins, err := ParseAllowedOrigins(config.AllowedOrigins)
 	if err != nil {
 		return nil, xerrors.Errorf("failed to parse allowed origins: %w", err)
 	}
@@ -225,20 +265,38 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 	humaConfig := huma.DefaultConfig("AgentAPI", version.Version)
 	humaConfig.Info.Description = "HTTP API for Claude Code, Goose, and Aider.\n\nhttps://github.com/coder/agentapi"
 	api := humachi.New(router, humaConfig)
-	formatMessage := func(message string, userInput string) string {
-		return mf.FormatAgentMessage(config.AgentType, message, userInput)
-	}
-	conversation := st.NewConversation(ctx, st.ConversationConfig{
-		AgentType: config.AgentType,
-		AgentIO:   config.Process,
-		GetTime: func() time.Time {
-			return time.Now()
-		},
-		SnapshotInterval:      snapshotInterval,
-		ScreenStabilityLength: 2 * time.Second,
-		FormatMessage:         formatMessage,
-	}, config.InitialPrompt)
-	emitter := NewEventEmitter(1024)
+	var auth *bearerTokenAuth
+	if config.AuthToken != "" {
+		auth = newBearerTokenAuth(config.AuthToken)
+		api.UseMiddleware(auth.middleware(api))
+		logger.Info("Bearer token authentication is enabled")
+	}
+	var journal *st.MessageJournal
+	var history []st.ConversationMessage
+	if config.StateDir != "" {
+		if err := os.MkdirAll(config.StateDir, 0o700); err != nil {
+			return nil, xerrors.Errorf("failed to create state directory: %w", err)
+		}
+		journalPath := filepath.Join(config.StateDir, "messages.jsonl")
+		journal, history, err = st.OpenMessageJournal(journalPath)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open message journal: %w", err)
+		}
+		logger.Info("Restored conversation history", "journal", journalPath, "messages", len(history))
+	}
+	metrics := newMetrics()
+	defaultSession := newSession(ctx, sessionConfig{
+		id:            DefaultSessionID,
+		agentType:     config.AgentType,
+		process:       config.Process,
+		initialPrompt: config.InitialPrompt,
+		journal:       journal,
+		history:       history,
+		metrics:       metrics,
+		processConfig: config.ProcessConfig,
+		restartPolicy: config.RestartPolicy,
+		policy:        config.ApprovalPolicy,
+	})
 
 	// Create temporary directory for uploads
 	tempDir, err := os.MkdirTemp("", "agentapi-uploads-")
@@ -247,19 +305,49 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 	}
 	logger.Info("Created temporary directory for uploads", "tempDir", tempDir)
 
-	s := &Server{
-		router:       router,
-		api:          api,
-		port:         config.Port,
-		conversation: conversation,
-		logger:       logger,
-		agentio:      config.Process,
-		agentType:    config.AgentType,
-		emitter:      emitter,
-		chatBasePath: strings.TrimSuffix(config.ChatBasePath, "/"),
-		tempDir:      tempDir,
+	var workDir string
+	if config.WorkDir != "" {
+		// Symlinks are resolved so that uploads can be checked against the real path.
+		workDir, err = filepath.EvalSymlinks(config.WorkDir)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to resolve working directory: %w", err)
+		}
+		workDir, err = filepath.Abs(workDir)
+		if err != nil {
+			return nil, xerrors.Errorf("failed to resolve working directory: %w", err)
+		}
+	}
+
+	terminalWidth := config.TerminalWidth
+	if terminalWidth == 0 {
+		terminalWidth = 80
+	}
+	terminalHeight := config.TerminalHeight
+	if terminalHeight == 0 {
+		terminalHeight = 50
 	}
 
+	s := &Server{
+		ctx:             ctx,
+		router:          router,
+		api:             api,
+		port:            config.Port,
+		logger:          logger,
+		sessions:        map[string]*session{DefaultSessionID: defaultSession},
+		defaultSession:  defaultSession,
+		terminalWidth:   terminalWidth,
+		terminalHeight:  terminalHeight,
+		scrollbackLines: config.ScrollbackLines,
+		chatBasePath:    strings.TrimSuffix(config.ChatBasePath, "/"),
+		tempDir:         tempDir,
+		uploads:         newUploadStore(tempDir, workDir, config.UploadMaxFileSize, config.UploadQuota),
+		allowedOrigins:  allowedOrigins,
+		auth:            auth,
+		metrics:         metrics,
+		approvalPolicy:  config.ApprovalPolicy,
+	}
+	metrics.collectSessions(s)
+
 	// Register API routes
 	s.registerRoutes()
 
@@ -318,76 +406,189 @@ func sseMiddleware(ctx huma.Context, next func(huma.Context)) {
 	next(ctx)
 }
 
+// StartSnapshotLoop starts tracking the screen of the default session's agent.
+// Sessions created through the API start tracking their agents on creation.
 func (s *Server) StartSnapshotLoop(ctx context.Context) {
-	s.conversation.StartSnapshotLoop(ctx)
-	go func() {
-		for {
-			currentStatus := s.conversation.Status()
-
-			// Send initial prompt when agent becomes stable for the first time
-			if !s.conversation.InitialPromptSent && convertStatus(currentStatus) == AgentStatusStable {
-				if err := s.conversation.SendMessage(FormatMessage(s.agentType, s.conversation.InitialPrompt)...); err != nil {
-					s.logger.Error("Failed to send initial prompt", "error", err)
-				} else {
-					s.conversation.InitialPromptSent = true
-					currentStatus = st.ConversationStatusChanging
-					s.logger.Info("Initial prompt sent successfully")
-				}
-			}
-			s.emitter.UpdateStatusAndEmitChanges(currentStatus, s.agentType)
-			s.emitter.UpdateMessagesAndEmitChanges(s.conversation.Messages())
-			s.emitter.UpdateScreenAndEmitChanges(s.conversation.Screen())
-			time.Sleep(snapshotInterval)
-		}
-	}()
+	s.defaultSession.startSnapshotLoop(ctx)
+}
+
+// WaitForAgent waits until the default session's agent exits and isn't
+// restarted, or the server is stopped. It returns an error if the agent
+// exited with a non-zero exit code.
+func (s *Server) WaitForAgent(ctx context.Context) error {
+	sess := s.defaultSession
+	sess.mu.RLock()
+	done := sess.agentDone
+	sess.mu.RUnlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.agentErr
 }
 
 // registerRoutes sets up all API endpoints
 func (s *Server) registerRoutes() {
+	// Session routes are served for the default session at the root of the API,
+	// and for any session under /sessions/{sessionId}.
+	sessionAPI := huma.NewGroup(s.api)
+	sessionAPI.UseModifier(sessionRoutesModifier)
+	sessionAPI.UseMiddleware(s.sessionMiddleware)
+
 	// GET /status endpoint
-	huma.Get(s.api, "/status", s.getStatus, func(o *huma.Operation) {
+	huma.Get(sessionAPI, "/status", s.getStatus, func(o *huma.Operation) {
 		o.Description = "Returns the current status of the agent."
 	})
 
 	// GET /messages endpoint
-	huma.Get(s.api, "/messages", s.getMessages, func(o *huma.Operation) {
+	huma.Get(sessionAPI, "/messages", s.getMessages, func(o *huma.Operation) {
 		o.Description = "Returns a list of messages representing the conversation history with the agent."
 	})
 
+	// GET /export endpoint
+	huma.Get(sessionAPI, "/export", s.exportConversation, func(o *huma.Operation) {
+		o.Description = "Exports the conversation history as a Markdown document, a standalone HTML page, or JSON. Messages are headed by their role and time, and code blocks, tool calls, and diffs are kept as code blocks."
+	})
+
+	// GET /screen endpoint
+	huma.Get(sessionAPI, "/screen", s.getScreen, func(o *huma.Operation) {
+		o.Description = "Returns the agent's terminal screen. The 'ansi' and 'runs' formats keep the colors and text attributes, e.g. bold or underlined, which the 'text' format drops."
+	})
+
 	// POST /message endpoint
-	huma.Post(s.api, "/message", s.createMessage, func(o *huma.Operation) {
+	huma.Post(sessionAPI, "/message", s.createMessage, func(o *huma.Operation) {
 		o.Description = "Send a message to the agent. For messages of type 'user', the agent's status must be 'stable' for the operation to complete successfully. Otherwise, this endpoint will return an error."
 	})
 
+	// POST /interrupt endpoint
+	huma.Post(sessionAPI, "/interrupt", s.interrupt, func(o *huma.Operation) {
+		o.Description = "Interrupts the agent's current task by sending the key sequence the agent uses for that: Escape for Claude Code and Codex, Ctrl-C for other agents. Then waits for the agent to stop. Nothing is sent if the agent isn't running."
+	})
+
+	// POST /actions/{id} endpoint
+	huma.Post(sessionAPI, "/actions/{id}", s.answerAction, func(o *huma.Operation) {
+		o.Description = "Answers the permission prompt the agent is waiting on, as reported in the 'pending_action' field of GET /status. The keystrokes that select the decision are sent to the agent."
+	})
+
+	// POST /restart endpoint
+	huma.Post(sessionAPI, "/restart", s.restart, func(o *huma.Operation) {
+		o.Description = "Stops the agent and starts it again in a new terminal. The conversation history is kept, and a system message marks the restart."
+	})
+
+	// POST /terminal/size endpoint
+	huma.Post(sessionAPI, "/terminal/size", s.resizeTerminal, func(o *huma.Operation) {
+		o.Description = "Resizes the agent's terminal. The agent is sent SIGWINCH and usually redraws its screen, which isn't recorded as a new message. A restarted agent keeps the size."
+	})
+
+	// GET /healthz endpoint
+	huma.Get(sessionAPI, "/healthz", s.getHealth, func(o *huma.Operation) {
+		o.Description = "Liveness check. Responds with a 503 status code if the agent process exited or its terminal output is no longer read."
+		o.Metadata[publicOperationKey] = true
+	})
+
+	// GET /readyz endpoint
+	huma.Get(sessionAPI, "/readyz", s.getReadiness, func(o *huma.Operation) {
+		o.Description = "Readiness check. Responds with a 503 status code until the agent has finished starting up and received the initial prompt, and after it exits."
+		o.Metadata[publicOperationKey] = true
+	})
+
+	// GET /queue endpoint
+	huma.Get(sessionAPI, "/queue", s.getQueue, func(o *huma.Operation) {
+		o.Description = "Returns the user messages queued with POST /message, in the order they will be sent to the agent."
+	})
+
+	// DELETE /queue/{id} endpoint
+	huma.Delete(sessionAPI, "/queue/{id}", s.deleteQueuedMessage, func(o *huma.Operation) {
+		o.Description = "Removes a message from the queue before it's sent to the agent."
+	})
+
 	huma.Post(s.api, "/upload", s.uploadFiles, func(o *huma.Operation) {
-		o.Description = "Upload files to the specified upload path."
+		o.Description = "Upload files to the specified upload path. Multiple files can be uploaded at once by repeating the 'file' field. Either all files are stored, or none if one of them exceeds the size limit or the upload quota."
+		o.Middlewares = append(o.Middlewares, s.limitUploadBody)
+	})
+
+	// GET /uploads endpoint
+	huma.Get(s.api, "/uploads", s.listUploads, func(o *huma.Operation) {
+		o.Description = "Returns the uploaded files and the space they use."
+	})
+
+	// GET /uploads/{id} endpoint
+	huma.Get(s.api, "/uploads/{id}", s.downloadUpload, func(o *huma.Operation) {
+		o.Description = "Downloads an uploaded file."
+	})
+
+	// DELETE /uploads/{id} endpoint
+	huma.Delete(s.api, "/uploads/{id}", s.deleteUpload, func(o *huma.Operation) {
+		o.Description = "Deletes an uploaded file, freeing its space in the upload quota."
 	})
 
 	// GET /events endpoint
-	sse.Register(s.api, huma.Operation{
+	sse.Register(sessionAPI, huma.Operation{
 		OperationID: "subscribeEvents",
 		Method:      http.MethodGet,
 		Path:        "/events",
 		Summary:     "Subscribe to events",
-		Description: "The events are sent as Server-Sent Events (SSE). Initially, the endpoint returns a list of events needed to reconstruct the current state of the conversation and the agent's status. After that, it only returns events that have occurred since the last event was sent.\n\nNote: When an agent is running, the last message in the conversation history is updated frequently, and the endpoint sends a new message update event each time.",
+		Description: "The events are sent as Server-Sent Events (SSE). Initially, the endpoint returns a list of events needed to reconstruct the current state of the conversation and the agent's status. After that, it only returns events that have occurred since the last event was sent.\n\nEvents carry monotonically increasing IDs. When reconnecting, clients can send the ID of the last event they received in the Last-Event-ID header to receive only the events they missed.\n\nNote: When an agent is running, the last message in the conversation history is updated frequently, and the endpoint sends a new message update event each time.",
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
 		// Mapping of event type name to Go struct for that event.
 		"message_update": MessageUpdateBody{},
 		"status_change":  StatusChangeBody{},
+		"pending_action": PendingActionBody{},
 	}, s.subscribeEvents)
 
-	sse.Register(s.api, huma.Operation{
+	sse.Register(sessionAPI, huma.Operation{
 		OperationID: "subscribeScreen",
 		Method:      http.MethodGet,
 		Path:        "/internal/screen",
 		Summary:     "Subscribe to screen",
+		Description: "In 'full' mode, every event contains the whole screen. In 'diff' mode, the first event contains the whole screen, and the following ones only contain the rows that changed. In the 'ansi' format, the screen keeps its colors and text attributes as ANSI escape sequences.",
 		Hidden:      true,
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
-		"screen": ScreenUpdateBody{},
+		"screen":       ScreenUpdateBody{},
+		"screen_patch": ScreenPatchBody{},
 	}, s.subscribeScreen)
 
+	// GET /sessions endpoint
+	huma.Get(s.api, "/sessions", s.listSessions, func(o *huma.Operation) {
+		o.Description = "Returns the list of agent sessions hosted by the server, including the default session."
+	})
+
+	// POST /sessions endpoint
+	huma.Post(s.api, "/sessions", s.createSession, func(o *huma.Operation) {
+		o.Description = "Starts a new agent in its own terminal. The session's endpoints mirror the root endpoints under /sessions/{sessionId}."
+	})
+
+	// GET /sessions/{sessionId} endpoint
+	huma.Get(s.api, "/sessions/{sessionId}", s.getSession, func(o *huma.Operation) {
+		o.Description = "Returns a single agent session."
+	})
+
+	// DELETE /sessions/{sessionId} endpoint
+	huma.Delete(s.api, "/sessions/{sessionId}", s.deleteSession, func(o *huma.Operation) {
+		o.Description = "Stops the agent of a session and removes the session. The default session can't be deleted."
+	})
+
+	// GET /ws endpoint. WebSocket upgrades are served outside of huma, so they
+	// don't appear in the OpenAPI schema.
+	var wsHandler http.Handler = http.HandlerFunc(s.handleWebSocket)
+	if s.auth != nil {
+		wsHandler = s.auth.httpMiddleware(wsHandler)
+	}
+	s.router.Method(http.MethodGet, "/ws", wsHandler)
+	s.router.Method(http.MethodGet, sessionRoutePrefix+"/ws", wsHandler)
+
+	// GET /metrics endpoint, in the Prometheus text format
+	var metricsHandler = s.metrics.handler()
+	if s.auth != nil {
+		metricsHandler = s.auth.httpMiddleware(metricsHandler)
+	}
+	s.router.Method(http.MethodGet, "/metrics", metricsHandler)
+
 	s.router.Handle("/", http.HandlerFunc(s.redirectToChat))
 
 	// Serve static files for the chat interface under /chat
@@ -396,31 +597,36 @@ func (s *Server) registerRoutes() {
 
 // getStatus handles GET /status
 func (s *Server) getStatus(ctx context.Context, input *struct{}) (*StatusResponse, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	sess := sessionFrom(ctx)
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
 
-	status := s.conversation.Status()
+	status := sess.conversation.Status()
 	agentStatus := convertStatus(status)
 
 	resp := &StatusResponse{}
 	resp.Body.Status = agentStatus
-	resp.Body.AgentType = s.agentType
+	resp.Body.AgentType = sess.agentType
+	resp.Body.PendingAction = sess.pendingAction
 
 	return resp, nil
 }
 
 // getMessages handles GET /messages
 func (s *Server) getMessages(ctx context.Context, input *struct{}) (*MessagesResponse, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	sess := sessionFrom(ctx)
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
 
+	messages := sess.conversation.Messages()
 	resp := &MessagesResponse{}
-	resp.Body.Messages = make([]Message, len(s.conversation.Messages()))
-	for i, msg := range s.conversation.Messages() {
+	resp.Body.Messages = make([]Message, len(messages))
+	for i, msg := range messages {
 		resp.Body.Messages[i] = Message{
 			Id:      msg.Id,
 			Role:    msg.Role,
 			Content: msg.Message,
+			Parts:   messageParts(sess.agentType, msg),
 			Time:    msg.Time,
 		}
 	}
@@ -428,83 +634,117 @@ func (s *Server) getMessages(ctx context.Context, input *struct{}) (*MessagesRes
 	return resp, nil
 }
 
+// defaultWaitTimeout is how long POST /message waits for the reply if the caller doesn't set a timeout.
+const defaultWaitTimeout = 10 * time.Minute
+
 // createMessage handles POST /message
 func (s *Server) createMessage(ctx context.Context, input *MessageRequest) (*MessageResponse, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	switch input.Body.Type {
-	case MessageTypeUser:
-		if err := s.conversation.SendMessage(FormatMessage(s.agentType, input.Body.Content)...); err != nil {
-			return nil, xerrors.Errorf("failed to send message: %w", err)
-		}
-	case MessageTypeRaw:
-		if _, err := s.agentio.Write([]byte(input.Body.Content)); err != nil {
-			return nil, xerrors.Errorf("failed to send message: %w", err)
+	sess := sessionFrom(ctx)
+	if input.Body.Queue {
+		if input.Body.Wait {
+			return nil, huma.Error400BadRequest("queued messages can't be waited for")
 		}
+		return s.queueMessage(sess, input.Body)
+	}
+	if input.Body.Wait && input.Body.Type != MessageTypeUser {
+		return nil, huma.Error400BadRequest("only messages of type 'user' can be waited for")
+	}
+
+	userMessageId, err := s.sendMessage(sess, input.Body)
+	if err != nil {
+		return nil, err
 	}
 
 	resp := &MessageResponse{}
 	resp.Body.Ok = true
+	if input.Body.Wait {
+		timeout := defaultWaitTimeout
+		if input.Body.WaitTimeout > 0 {
+			timeout = time.Duration(input.Body.WaitTimeout) * time.Second
+		}
+		reply, err := sess.waitForReply(ctx, userMessageId, timeout)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Reply = reply
+	}
 
 	return resp, nil
 }
 
-// uploadFiles handles POST /upload
-func (s *Server) uploadFiles(ctx context.Context, input *struct {
-	RawBody huma.MultipartFormFiles[UploadRequest]
-}) (*UploadResponse, error) {
-	formData := input.RawBody.Data()
+// sendMessage sends a message to the agent. For messages of type 'user', it
+// returns the ID of the message in the conversation.
+func (s *Server) sendMessage(sess *session, body MessageRequestBody) (int, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
 
-	file := formData.File.File
+	switch body.Type {
+	case MessageTypeUser:
+		if err := sess.sendUserMessage(body.Content); err != nil {
+			return 0, xerrors.Errorf("failed to send message: %w", err)
+		}
+		messages := sess.conversation.Messages()
+		return messages[len(messages)-1].Id, nil
+	case MessageTypeRaw:
+		if _, err := sess.agentio.Write([]byte(body.Content)); err != nil {
+			return 0, xerrors.Errorf("failed to send message: %w", err)
+		}
+	}
+	return 0, nil
+}
 
-	// Limit file size to 10MB
-	const maxFileSize = 10 << 20 // 10MB
-	buf, err := io.ReadAll(io.LimitReader(file, maxFileSize+1))
-	if err != nil {
-		return nil, xerrors.Errorf("failed to upload file: %w", err)
+func (s *Server) queueMessage(sess *session, body MessageRequestBody) (*MessageResponse, error) {
+	if body.Type != MessageTypeUser {
+		return nil, huma.Error400BadRequest("only messages of type 'user' can be queued")
 	}
-	if len(buf) > maxFileSize {
-		return nil, huma.Error400BadRequest("file size exceeds 10MB limit")
+	if mf.TrimWhitespace(body.Content) == "" {
+		return nil, huma.Error400BadRequest("message must not be empty")
 	}
+	queued := sess.queue.push(body.Content)
+	sess.logger.Info("Queued message", "queueId", queued.Id)
 
-	// Calculate checksum of the uploaded file to create unique subdirectory
-	hash := sha256.Sum256(buf)
-	checksum := hex.EncodeToString(hash[:8]) // Use first 8 bytes (16 hex chars)
-
-	// Create checksum-based subdirectory in tempDir
-	uploadDir := filepath.Join(s.tempDir, checksum)
-	err = os.MkdirAll(uploadDir, 0755)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to create upload directory: %w", err)
-	}
+	resp := &MessageResponse{}
+	resp.Body.Ok = true
+	resp.Body.QueueId = queued.Id
+	return resp, nil
+}
 
-	// Save individual file with original filename (extract just the base filename for security)
-	filename := filepath.Base(formData.File.Filename)
+// getQueue handles GET /queue
+func (s *Server) getQueue(ctx context.Context, input *struct{}) (*QueueResponse, error) {
+	sess := sessionFrom(ctx)
+	resp := &QueueResponse{}
+	resp.Body.Messages = sess.queue.list()
+	return resp, nil
+}
 
-	outPath := filepath.Join(uploadDir, filename)
-	err = os.WriteFile(outPath, buf, 0644)
-	if err != nil {
-		return nil, xerrors.Errorf("failed to write file: %w", err)
+// deleteQueuedMessage handles DELETE /queue/{id}
+func (s *Server) deleteQueuedMessage(ctx context.Context, input *DeleteQueuedMessageRequest) (*DeleteQueuedMessageResponse, error) {
+	sess := sessionFrom(ctx)
+	// Wait for a delivery in progress to finish, so a message is either
+	// removed from the queue or sent to the agent, never both.
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if !sess.queue.remove(input.Id) {
+		return nil, huma.Error404NotFound(fmt.Sprintf("queued message %d not found", input.Id))
 	}
 
-	resp := &UploadResponse{}
+	resp := &DeleteQueuedMessageResponse{}
 	resp.Body.Ok = true
-	resp.Body.FilePath = outPath
 	return resp, nil
 }
 
 // subscribeEvents is an SSE endpoint that sends events to the client
-func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.Sender) {
-	subscriberId, ch, stateEvents := s.emitter.Subscribe()
-	defer s.emitter.Unsubscribe(subscriberId)
-	s.logger.Info("New subscriber", "subscriberId", subscriberId)
+func (s *Server) subscribeEvents(ctx context.Context, input *SubscribeEventsRequest, send sse.Sender) {
+	sess := sessionFrom(ctx)
+	subscriberId, ch, stateEvents := sess.emitter.SubscribeSince(input.LastEventID)
+	defer sess.emitter.Unsubscribe(subscriberId)
+	sess.logger.Info("New subscriber", "subscriberId", subscriberId, "lastEventId", input.LastEventID)
 	for _, event := range stateEvents {
-		if event.Type == EventTypeScreenUpdate {
+		if isScreenEvent(event.Type) {
 			continue
 		}
-		if err := send.Data(event.Payload); err != nil {
-			s.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
+		if err := send(sse.Message{ID: event.Id, Data: event.Payload}); err != nil {
+			sess.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
 			return
 		}
 	}
@@ -513,33 +753,43 @@ func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.
 		select {
 		case event, ok := <-ch:
 			if !ok {
-				s.logger.Info("Channel closed", "subscriberId", subscriberId)
+				sess.logger.Info("Channel closed", "subscriberId", subscriberId)
 				return
 			}
-			if event.Type == EventTypeScreenUpdate {
+			if isScreenEvent(event.Type) {
 				continue
 			}
-			if err := send.Data(event.Payload); err != nil {
-				s.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
+			if err := send(sse.Message{ID: event.Id, Data: event.Payload}); err != nil {
+				sess.logger.Error("Failed to send event", "subscriberId", subscriberId, "error", err)
 				return
 			}
 		case <-ctx.Done():
-			s.logger.Info("Context done", "subscriberId", subscriberId)
+			sess.logger.Info("Context done", "subscriberId", subscriberId)
 			return
 		}
 	}
 }
 
-func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.Sender) {
-	subscriberId, ch, stateEvents := s.emitter.Subscribe()
-	defer s.emitter.Unsubscribe(subscriberId)
-	s.logger.Info("New screen subscriber", "subscriberId", subscriberId)
+func (s *Server) subscribeScreen(ctx context.Context, input *SubscribeScreenRequest, send sse.Sender) {
+	sess := sessionFrom(ctx)
+	subscriberId, ch, stateEvents := sess.emitter.SubscribeSince(input.LastEventID)
+	defer sess.emitter.Unsubscribe(subscriberId)
+	sess.logger.Info("New screen subscriber", "subscriberId", subscriberId, "mode", input.Mode, "format", input.Format)
+	screenEventType := input.Format.eventType()
+	differ := &screenDiffer{}
+	sendScreen := func(event Event) error {
+		payload := event.Payload
+		if input.Mode == ScreenModeDiff {
+			_, payload = differ.next(event.Payload.(ScreenUpdateBody))
+		}
+		return send(sse.Message{ID: event.Id, Data: payload})
+	}
 	for _, event := range stateEvents {
-		if event.Type != EventTypeScreenUpdate {
+		if event.Type != screenEventType {
 			continue
 		}
-		if err := send.Data(event.Payload); err != nil {
-			s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
+		if err := sendScreen(event); err != nil {
+			sess.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
 			return
 		}
 	}
@@ -547,18 +797,18 @@ func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.
 		select {
 		case event, ok := <-ch:
 			if !ok {
-				s.logger.Info("Screen channel closed", "subscriberId", subscriberId)
+				sess.logger.Info("Screen channel closed", "subscriberId", subscriberId)
 				return
 			}
-			if event.Type != EventTypeScreenUpdate {
+			if event.Type != screenEventType {
 				continue
 			}
-			if err := send.Data(event.Payload); err != nil {
-				s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
+			if err := sendScreen(event); err != nil {
+				sess.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
 				return
 			}
 		case <-ctx.Done():
-			s.logger.Info("Screen context done", "subscriberId", subscriberId)
+			sess.logger.Info("Screen context done", "subscriberId", subscriberId)
 			return
 		}
 	}
@@ -577,6 +827,8 @@ func (s *Server) Start() error {
 
 // Stop gracefully stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
+	s.closeSessions()
+
 	// Clean up temporary directory
 	s.cleanupTempDir()
 
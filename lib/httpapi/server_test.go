@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"mime/multipart"
@@ -11,12 +12,19 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/coder/agentapi/lib/httpapi"
 	"github.com/coder/agentapi/lib/logctx"
 	"github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/policy"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -956,3 +964,1799 @@ func TestServer_UploadFiles_Errors(t *testing.T) {
 		require.Contains(t, string(body), "file size exceeds 10MB limit")
 	})
 }
+
+func TestServer_UploadManagement(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:         msgfmt.AgentTypeClaude,
+		Process:           nil,
+		Port:              0,
+		ChatBasePath:      "/chat",
+		AllowedHosts:      []string{"*"},
+		AllowedOrigins:    []string{"*"},
+		UploadMaxFileSize: 1024,
+		UploadQuota:       2048,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	upload := func(t *testing.T, files map[string]string) (*http.Response, httpapi.UploadResponse) {
+		t.Helper()
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			part, err := writer.CreateFormFile("file", name)
+			require.NoError(t, err)
+			_, err = part.Write([]byte(files[name]))
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+		resp, err := tsServer.Client().Post(tsServer.URL+"/upload", writer.FormDataContentType(), &buf)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		var uploadResp httpapi.UploadResponse
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploadResp.Body))
+		}
+		return resp, uploadResp
+	}
+	listUploads := func(t *testing.T) httpapi.UploadsResponse {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + "/uploads")
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var list httpapi.UploadsResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list.Body))
+		return list
+	}
+	deleteUpload := func(t *testing.T, id string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodDelete, tsServer.URL+"/uploads/"+id, nil)
+		require.NoError(t, err)
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The subtests share the quota, so they run sequentially.
+	t.Run("multiple files", func(t *testing.T) {
+		resp, uploaded := upload(t, map[string]string{"a.txt": "first", "b.txt": "second"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Len(t, uploaded.Body.Files, 2)
+		assert.Equal(t, uploaded.Body.Files[0].Path, uploaded.Body.FilePath)
+		assert.Equal(t, "a.txt", uploaded.Body.Files[0].Name)
+		assert.EqualValues(t, 5, uploaded.Body.Files[0].Size)
+		// sha256 of "first"
+		assert.Equal(t, "a7937b64b8caa58f03721bb6bacf5c78cb235febe0e70b1b84cd99541461a08e", uploaded.Body.Files[0].Sha256)
+		assert.Equal(t, "b.txt", uploaded.Body.Files[1].Name)
+
+		list := listUploads(t)
+		require.Len(t, list.Body.Uploads, 2)
+		assert.EqualValues(t, 11, list.Body.TotalSize)
+		assert.EqualValues(t, 2048, list.Body.Quota)
+
+		resp, err := tsServer.Client().Get(tsServer.URL + "/uploads/" + uploaded.Body.Files[1].Id)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		content, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(content))
+		assert.Contains(t, resp.Header.Get("Content-Disposition"), `filename=b.txt`)
+
+		for _, file := range uploaded.Body.Files {
+			assert.Equal(t, http.StatusOK, deleteUpload(t, file.Id))
+			_, err := os.Stat(file.Path)
+			assert.True(t, os.IsNotExist(err))
+		}
+		assert.Empty(t, listUploads(t).Body.Uploads)
+	})
+
+	t.Run("file size limit", func(t *testing.T) {
+		resp, _ := upload(t, map[string]string{"small.txt": "ok", "large.txt": strings.Repeat("x", 1025)})
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "file size exceeds 1KB limit")
+		// Nothing is stored if one of the files is rejected.
+		assert.Empty(t, listUploads(t).Body.Uploads)
+	})
+
+	t.Run("quota", func(t *testing.T) {
+		resp, first := upload(t, map[string]string{"first.txt": strings.Repeat("x", 1024)})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, _ = upload(t, map[string]string{"second.txt": strings.Repeat("x", 1024)})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, _ = upload(t, map[string]string{"third.txt": "x"})
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		// Deleting a file frees its space.
+		require.Equal(t, http.StatusOK, deleteUpload(t, first.Body.Files[0].Id))
+		resp, _ = upload(t, map[string]string{"third.txt": "x"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("request size", func(t *testing.T) {
+		stored := len(listUploads(t).Body.Uploads)
+		// Files that don't fit into the quota together are rejected while
+		// they're read, even though each is within the size limit.
+		resp, _ := upload(t, map[string]string{"a.txt": strings.Repeat("x", 1000), "b.txt": strings.Repeat("x", 1000), "c.txt": strings.Repeat("x", 1000)})
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		// Request bodies that can't fit are rejected before they're parsed.
+		resp, _ = upload(t, map[string]string{"big.bin": strings.Repeat("x", 256<<10)})
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "upload request exceeds")
+		assert.Len(t, listUploads(t).Body.Uploads, stored)
+
+		files := map[string]string{}
+		for i := range 21 {
+			files[fmt.Sprintf("%d.txt", i)] = "x"
+		}
+		resp, _ = upload(t, files)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("unknown upload", func(t *testing.T) {
+		resp, err := tsServer.Client().Get(tsServer.URL + "/uploads/unknown")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, http.StatusNotFound, deleteUpload(t, "unknown"))
+	})
+}
+
+func TestServer_UploadToWorkdir(t *testing.T) {
+	t.Parallel()
+	workDir := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(workDir, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(workDir, "secret.txt")))
+	require.NoError(t, os.Mkdir(filepath.Join(workDir, "docs"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(workDir, "docs"), filepath.Join(workDir, "docs-link")))
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		WorkDir:        workDir,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	upload := func(t *testing.T, filename string, content string, fields map[string]string) *http.Response {
+		t.Helper()
+		var buf bytes.Buffer
+		writer := multipart.NewWriter(&buf)
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+		for key, value := range fields {
+			require.NoError(t, writer.WriteField(key, value))
+		}
+		require.NoError(t, writer.Close())
+		resp, err := tsServer.Client().Post(tsServer.URL+"/upload", writer.FormDataContentType(), &buf)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	t.Run("upload into working directory", func(t *testing.T) {
+		t.Parallel()
+		resp := upload(t, "root.txt", "root", map[string]string{"target": "workdir"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var uploaded httpapi.UploadResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded.Body))
+		require.Len(t, uploaded.Body.Files, 1)
+		assert.Equal(t, httpapi.UploadTargetWorkdir, uploaded.Body.Files[0].Target)
+		content, err := os.ReadFile(filepath.Join(workDir, "root.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "root", string(content))
+	})
+
+	t.Run("nested path is created", func(t *testing.T) {
+		t.Parallel()
+		resp := upload(t, "nested.txt", "nested", map[string]string{"target": "workdir", "path": "assets/images"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		content, err := os.ReadFile(filepath.Join(workDir, "assets", "images", "nested.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "nested", string(content))
+	})
+
+	t.Run("symlink inside working directory", func(t *testing.T) {
+		t.Parallel()
+		resp := upload(t, "linked.txt", "linked", map[string]string{"target": "workdir", "path": "docs-link"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_, err := os.Stat(filepath.Join(workDir, "docs", "linked.txt"))
+		require.NoError(t, err)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		t.Parallel()
+		require.NoError(t, os.WriteFile(filepath.Join(workDir, "existing.txt"), []byte("old"), 0o644))
+
+		resp := upload(t, "existing.txt", "new", map[string]string{"target": "workdir"})
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+		content, err := os.ReadFile(filepath.Join(workDir, "existing.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+
+		resp = upload(t, "existing.txt", "new", map[string]string{"target": "workdir", "overwrite": "true"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		content, err = os.ReadFile(filepath.Join(workDir, "existing.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+	})
+
+	t.Run("delete keeps what replaced the upload", func(t *testing.T) {
+		t.Parallel()
+		resp := upload(t, "replaced.txt", "replaced", map[string]string{"target": "workdir"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var uploaded httpapi.UploadResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded.Body))
+		require.Len(t, uploaded.Body.Files, 1)
+
+		// The agent replaced the uploaded file with a directory.
+		path := filepath.Join(workDir, "replaced.txt")
+		require.NoError(t, os.Remove(path))
+		require.NoError(t, os.Mkdir(path, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(path, "work.txt"), []byte("work"), 0o644))
+
+		req, err := http.NewRequest(http.MethodDelete, tsServer.URL+"/uploads/"+uploaded.Body.Files[0].Id, nil)
+		require.NoError(t, err)
+		deleteResp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		_ = deleteResp.Body.Close()
+		require.Equal(t, http.StatusOK, deleteResp.StatusCode)
+		content, err := os.ReadFile(filepath.Join(path, "work.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "work", string(content))
+	})
+
+	t.Run("download doesn't follow a symlink that replaced the upload", func(t *testing.T) {
+		t.Parallel()
+		resp := upload(t, "download.txt", "download", map[string]string{"target": "workdir"})
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var uploaded httpapi.UploadResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded.Body))
+		require.Len(t, uploaded.Body.Files, 1)
+
+		path := filepath.Join(workDir, "download.txt")
+		require.NoError(t, os.Remove(path))
+		require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), path))
+
+		downloadResp, err := tsServer.Client().Get(tsServer.URL + "/uploads/" + uploaded.Body.Files[0].Id)
+		require.NoError(t, err)
+		defer func() {
+			_ = downloadResp.Body.Close()
+		}()
+		require.Equal(t, http.StatusConflict, downloadResp.StatusCode)
+		body, err := io.ReadAll(downloadResp.Body)
+		require.NoError(t, err)
+		assert.NotContains(t, string(body), "secret")
+	})
+
+	rejected := []struct {
+		name     string
+		filename string
+		fields   map[string]string
+	}{
+		{"parent directory", "file.txt", map[string]string{"target": "workdir", "path": "../"}},
+		{"traversal in nested path", "file.txt", map[string]string{"target": "workdir", "path": "assets/../../escape"}},
+		{"absolute path", "file.txt", map[string]string{"target": "workdir", "path": outside}},
+		{"symlinked directory outside", "file.txt", map[string]string{"target": "workdir", "path": "escape"}},
+		{"symlinked file", "secret.txt", map[string]string{"target": "workdir", "overwrite": "true"}},
+		{"path without workdir target", "file.txt", map[string]string{"path": "assets"}},
+	}
+	// These subtests run sequentially so the files outside of the working
+	// directory can be checked once all of them are done.
+	for _, tc := range rejected {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := upload(t, tc.filename, "malicious", tc.fields)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	}
+
+	t.Run("files outside are untouched", func(t *testing.T) {
+		content, err := os.ReadFile(filepath.Join(outside, "secret.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(content))
+		_, err = os.Stat(filepath.Join(outside, "file.txt"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestServer_Sessions(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any) (int, []byte) {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+
+	t.Run("default session is listed", func(t *testing.T) {
+		t.Parallel()
+		status, body := doRequest(t, http.MethodGet, "/sessions", nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+		var sessions struct {
+			Sessions []httpapi.SessionInfo `json:"sessions"`
+		}
+		require.NoError(t, json.Unmarshal(body, &sessions))
+		require.NotEmpty(t, sessions.Sessions)
+		assert.Equal(t, httpapi.DefaultSessionID, sessions.Sessions[0].Id)
+		assert.Equal(t, msgfmt.AgentTypeClaude, sessions.Sessions[0].AgentType)
+	})
+
+	t.Run("default session routes are mirrored", func(t *testing.T) {
+		t.Parallel()
+		status, body := doRequest(t, http.MethodGet, "/sessions/default/messages", nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		t.Parallel()
+		status, _ := doRequest(t, http.MethodGet, "/sessions/unknown", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = doRequest(t, http.MethodGet, "/sessions/unknown/status", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = doRequest(t, http.MethodDelete, "/sessions/unknown", nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("default session can't be deleted", func(t *testing.T) {
+		t.Parallel()
+		status, _ := doRequest(t, http.MethodDelete, "/sessions/default", nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("agent type", func(t *testing.T) {
+		t.Parallel()
+		status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program:   "cat",
+			AgentType: "unknown",
+		})
+		assert.Equal(t, http.StatusBadRequest, status, string(body))
+		assert.Contains(t, string(body), `unknown agent type \"unknown\"`)
+
+		status, body = doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program:   "cat",
+			AgentType: msgfmt.AgentTypeAider,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var created httpapi.SessionInfo
+		require.NoError(t, json.Unmarshal(body, &created))
+		assert.Equal(t, msgfmt.AgentTypeAider, created.AgentType)
+		status, body = doRequest(t, http.MethodDelete, "/sessions/"+created.Id, nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+	})
+
+	t.Run("create and delete", func(t *testing.T) {
+		t.Parallel()
+		status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program: "cat",
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var created httpapi.SessionInfo
+		require.NoError(t, json.Unmarshal(body, &created))
+		require.NotEmpty(t, created.Id)
+		assert.Equal(t, msgfmt.AgentTypeCustom, created.AgentType)
+
+		status, body = doRequest(t, http.MethodGet, "/sessions/"+created.Id+"/status", nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+
+		status, body = doRequest(t, http.MethodDelete, "/sessions/"+created.Id, nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+
+		status, _ = doRequest(t, http.MethodGet, "/sessions/"+created.Id, nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
+
+func TestServer_AuthToken(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		AuthToken:      "secret",
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+
+	cases := []struct {
+		name               string
+		path               string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{"missing header", "/messages", "", http.StatusUnauthorized},
+		{"wrong token", "/messages", "Bearer wrong", http.StatusUnauthorized},
+		{"wrong scheme", "/messages", "Basic secret", http.StatusUnauthorized},
+		{"token prefix", "/messages", "Bearer secre", http.StatusUnauthorized},
+		{"valid token", "/messages", "Bearer secret", http.StatusOK},
+		{"case-insensitive scheme", "/messages", "bearer secret", http.StatusOK},
+		{"session route without token", "/sessions/default/status", "", http.StatusUnauthorized},
+		{"session route with token", "/sessions/default/status", "Bearer secret", http.StatusOK},
+		{"events without token", "/events", "", http.StatusUnauthorized},
+		{"screen without token", "/internal/screen", "", http.StatusUnauthorized},
+		{"upload without token", "/upload", "", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			method := http.MethodGet
+			if tc.path == "/upload" {
+				method = http.MethodPost
+			}
+			req, err := http.NewRequest(method, tsServer.URL+tc.path, nil)
+			require.NoError(t, err)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			resp, err := tsServer.Client().Do(req)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = resp.Body.Close()
+			})
+			require.Equal(t, tc.expectedStatusCode, resp.StatusCode)
+			if tc.expectedStatusCode == http.StatusUnauthorized {
+				require.Equal(t, `Bearer realm="agentapi"`, resp.Header.Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+		AuthToken:      "secret",
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+	authHeader := http.Header{"Authorization": []string{"Bearer secret"}}
+
+	// Start a session running cat, which echoes its input to the screen.
+	body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: "cat"})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, tsServer.URL+"/sessions", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header = authHeader.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := tsServer.Client().Do(req)
+	require.NoError(t, err)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	wsURL := tsServer.URL + "/sessions/" + created.Id + "/ws"
+
+	t.Run("unauthorized", func(t *testing.T) {
+		t.Parallel()
+		_, resp, err := websocket.Dial(context.Background(), wsURL, nil)
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("unknown session", func(t *testing.T) {
+		t.Parallel()
+		_, resp, err := websocket.Dial(context.Background(), tsServer.URL+"/sessions/unknown/ws", &websocket.DialOptions{
+			HTTPHeader: authHeader,
+		})
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("unknown event type", func(t *testing.T) {
+		t.Parallel()
+		_, resp, err := websocket.Dial(context.Background(), wsURL+"?types=unknown", &websocket.DialOptions{
+			HTTPHeader: authHeader,
+		})
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("input and screen", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, _, err := websocket.Dial(ctx, wsURL+"?types=screen_update", &websocket.DialOptions{
+			HTTPHeader: authHeader,
+		})
+		require.NoError(t, err)
+		conn.SetReadLimit(1 << 20)
+		defer func() {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}()
+
+		require.NoError(t, wsjson.Write(ctx, conn, httpapi.WebSocketInput{Type: "unknown"}))
+		require.NoError(t, wsjson.Write(ctx, conn, httpapi.WebSocketInput{
+			Type:    httpapi.WebSocketInputTypeRaw,
+			Content: "hello websocket",
+		}))
+
+		sawError := false
+		for {
+			var event struct {
+				Type httpapi.EventType `json:"type"`
+				Data json.RawMessage   `json:"data"`
+			}
+			require.NoError(t, wsjson.Read(ctx, conn, &event))
+			switch event.Type {
+			case httpapi.EventTypeError:
+				sawError = true
+			case httpapi.EventTypeScreenUpdate:
+				var screen httpapi.ScreenUpdateBody
+				require.NoError(t, json.Unmarshal(event.Data, &screen))
+				if strings.Contains(screen.Screen, "hello websocket") {
+					assert.True(t, sawError, "the error for the unknown input should arrive first")
+					return
+				}
+			default:
+				t.Fatalf("unexpected event type %q", event.Type)
+			}
+		}
+	})
+}
+
+func TestServer_MessageQueue(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any, out any) int {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if out != nil && resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp.StatusCode
+	}
+
+	var created httpapi.SessionInfo
+	require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+		Program: "cat",
+	}, &created))
+	prefix := "/sessions/" + created.Id
+
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		status := doRequest(t, http.MethodPost, prefix+"/message", httpapi.MessageRequestBody{
+			Type:    httpapi.MessageTypeRaw,
+			Content: "x",
+			Queue:   true,
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status = doRequest(t, http.MethodPost, prefix+"/message", httpapi.MessageRequestBody{
+			Type:    httpapi.MessageTypeUser,
+			Content: "  ",
+			Queue:   true,
+		}, nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status = doRequest(t, http.MethodDelete, prefix+"/queue/1000", nil, nil)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("queue, cancel and deliver", func(t *testing.T) {
+		t.Parallel()
+		queueIds := make([]int, 0, 2)
+		for _, content := range []string{"first queued message", "second queued message"} {
+			var resp struct {
+				Ok      bool `json:"ok"`
+				QueueId int  `json:"queue_id"`
+			}
+			require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, prefix+"/message", httpapi.MessageRequestBody{
+				Type:    httpapi.MessageTypeUser,
+				Content: content,
+				Queue:   true,
+			}, &resp))
+			require.True(t, resp.Ok)
+			require.NotZero(t, resp.QueueId)
+			queueIds = append(queueIds, resp.QueueId)
+		}
+
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodDelete, fmt.Sprintf("%s/queue/%d", prefix, queueIds[1]), nil, nil))
+
+		var queue struct {
+			Messages []httpapi.QueuedMessage `json:"messages"`
+		}
+		require.Eventually(t, func() bool {
+			require.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, prefix+"/queue", nil, &queue))
+			return len(queue.Messages) == 0
+		}, 15*time.Second, 100*time.Millisecond)
+
+		var messages struct {
+			Messages []httpapi.Message `json:"messages"`
+		}
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodGet, prefix+"/messages", nil, &messages))
+		userMessages := make([]string, 0)
+		for _, msg := range messages.Messages {
+			if msg.Role == "user" {
+				userMessages = append(userMessages, msg.Content)
+			}
+		}
+		assert.Equal(t, []string{"first queued message"}, userMessages)
+	})
+}
+
+func TestServer_MessageWait(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any) (int, []byte) {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+	// startSession starts an agent and waits until it's ready for input.
+	startSession := func(t *testing.T, program string, args ...string) string {
+		t.Helper()
+		status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program: program,
+			Args:    args,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var created httpapi.SessionInfo
+		require.NoError(t, json.Unmarshal(body, &created))
+		require.Eventually(t, func() bool {
+			_, body := doRequest(t, http.MethodGet, "/sessions/"+created.Id+"/status", nil)
+			var status httpapi.SessionInfo
+			require.NoError(t, json.Unmarshal(body, &status))
+			return status.Status == httpapi.AgentStatusStable
+		}, 15*time.Second, 100*time.Millisecond)
+		return "/sessions/" + created.Id
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		status, _ := doRequest(t, http.MethodPost, "/message", httpapi.MessageRequestBody{
+			Type:    httpapi.MessageTypeRaw,
+			Content: "x",
+			Wait:    true,
+		})
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = doRequest(t, http.MethodPost, "/message", httpapi.MessageRequestBody{
+			Type:    httpapi.MessageTypeUser,
+			Content: "x",
+			Wait:    true,
+			Queue:   true,
+		})
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		t.Parallel()
+		// cat replies by echoing the message.
+		prefix := startSession(t, "cat")
+		status, body := doRequest(t, http.MethodPost, prefix+"/message", httpapi.MessageRequestBody{
+			Type:    httpapi.MessageTypeUser,
+			Content: "hello",
+			Wait:    true,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var resp struct {
+			Ok    bool             `json:"ok"`
+			Reply *httpapi.Message `json:"reply"`
+		}
+		require.NoError(t, json.Unmarshal(body, &resp))
+		require.NotNil(t, resp.Reply)
+		assert.EqualValues(t, "agent", resp.Reply.Role)
+
+		_, body = doRequest(t, http.MethodGet, prefix+"/status", nil)
+		assert.Contains(t, string(body), `"stable"`)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		// The agent never becomes stable after receiving a message.
+		prefix := startSession(t, "sh", "-c", "read line; while true; do date +%N; sleep 0.1; done")
+		status, body := doRequest(t, http.MethodPost, prefix+"/message", httpapi.MessageRequestBody{
+			Type:        httpapi.MessageTypeUser,
+			Content:     "hello",
+			Wait:        true,
+			WaitTimeout: 1,
+		})
+		require.Equal(t, http.StatusGatewayTimeout, status, string(body))
+		var resp httpapi.ReplyTimeoutError
+		require.NoError(t, json.Unmarshal(body, &resp))
+		assert.Equal(t, http.StatusGatewayTimeout, resp.Status)
+		require.NotNil(t, resp.Reply)
+		assert.NotEmpty(t, resp.Reply.Content)
+	})
+}
+
+func TestServer_Interrupt(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any, out any) int {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if out != nil {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp.StatusCode
+	}
+	startSession := func(t *testing.T, program string, args ...string) string {
+		t.Helper()
+		var created httpapi.SessionInfo
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program: program,
+			Args:    args,
+		}, &created))
+		return "/sessions/" + created.Id
+	}
+	type interruptResponse struct {
+		Interrupted bool                `json:"interrupted"`
+		Status      httpapi.AgentStatus `json:"status"`
+	}
+
+	t.Run("running agent", func(t *testing.T) {
+		t.Parallel()
+		// The screen changes until the process is interrupted with Ctrl-C.
+		prefix := startSession(t, "sh", "-c", "while true; do date +%N; sleep 0.1; done")
+		var resp interruptResponse
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, prefix+"/interrupt", nil, &resp))
+		assert.True(t, resp.Interrupted)
+		assert.Equal(t, httpapi.AgentStatusStable, resp.Status)
+	})
+
+	t.Run("stable agent", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "cat")
+		require.Eventually(t, func() bool {
+			var status httpapi.SessionInfo
+			doRequest(t, http.MethodGet, prefix+"/status", nil, &status)
+			return status.Status == httpapi.AgentStatusStable
+		}, 15*time.Second, 100*time.Millisecond)
+		var resp interruptResponse
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, prefix+"/interrupt", nil, &resp))
+		assert.False(t, resp.Interrupted)
+		assert.Equal(t, httpapi.AgentStatusStable, resp.Status)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		// The process ignores Ctrl-C and keeps changing the screen.
+		prefix := startSession(t, "sh", "-c", "trap '' INT; while true; do date +%N; sleep 0.1; done")
+		var resp interruptResponse
+		require.Equal(t, http.StatusOK, doRequest(t, http.MethodPost, prefix+"/interrupt?timeout=1", nil, &resp))
+		assert.False(t, resp.Interrupted)
+		assert.NotEqual(t, httpapi.AgentStatusStable, resp.Status)
+	})
+}
+
+func TestServer_Metrics(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+		AuthToken:      "secret",
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any, token string) (int, string) {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	status, _ := doRequest(t, http.MethodGet, "/metrics", nil, "")
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{Program: "cat"}, "secret")
+	require.Equal(t, http.StatusOK, status, body)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+
+	require.Eventually(t, func() bool {
+		status, body = doRequest(t, http.MethodGet, "/metrics", nil, "secret")
+		require.Equal(t, http.StatusOK, status)
+		return strings.Contains(body, fmt.Sprintf(`agentapi_status_transitions_total{session="%s",status="stable"} 1`, created.Id))
+	}, 15*time.Second, 100*time.Millisecond, body)
+
+	for _, line := range []string{
+		`agentapi_messages{role="user",session="default"} 0`,
+		fmt.Sprintf(`agentapi_messages{role="agent",session="%s"} 1`, created.Id),
+		fmt.Sprintf(`agentapi_event_subscribers{session="%s"} 0`, created.Id),
+		fmt.Sprintf(`agentapi_event_subscribers_dropped_total{session="%s"} 0`, created.Id),
+		fmt.Sprintf(`agentapi_status_seconds_total{session="%s",status="running"}`, created.Id),
+		fmt.Sprintf(`agentapi_snapshot_loop_duration_seconds_count{session="%s"}`, created.Id),
+		`agentapi_upload_bytes_total 0`,
+	} {
+		assert.Contains(t, body, line)
+	}
+
+	status, body = doRequest(t, http.MethodDelete, "/sessions/"+created.Id, nil, "secret")
+	require.Equal(t, http.StatusOK, status, body)
+	_, body = doRequest(t, http.MethodGet, "/metrics", nil, "secret")
+	assert.NotContains(t, body, created.Id)
+}
+
+func TestServer_Health(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+		AuthToken:      "secret",
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	startSession := func(t *testing.T, program string, args ...string) string {
+		t.Helper()
+		body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: program, Args: args})
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, tsServer.URL+"/sessions", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var created httpapi.SessionInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return "/sessions/" + created.Id
+	}
+	// Health checks don't require authentication.
+	getHealth := func(t *testing.T, path string) (int, httpapi.HealthBody) {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + path)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var body httpapi.HealthBody
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp.StatusCode, body
+	}
+
+	t.Run("running agent", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "cat")
+
+		status, body := getHealth(t, prefix+"/healthz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.True(t, body.Alive)
+		assert.True(t, body.PtyReaderAlive)
+		assert.True(t, body.InitialPromptSent)
+		assert.Nil(t, body.ExitCode)
+
+		require.Eventually(t, func() bool {
+			status, body = getHealth(t, prefix+"/readyz")
+			return status == http.StatusOK
+		}, 15*time.Second, 100*time.Millisecond)
+		assert.True(t, body.Ready)
+		assert.Equal(t, httpapi.AgentStatusStable, body.Status)
+	})
+
+	t.Run("exited agent", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "sh", "-c", "echo bye; exit 3")
+
+		var body httpapi.HealthBody
+		require.Eventually(t, func() bool {
+			var status int
+			status, body = getHealth(t, prefix+"/healthz")
+			return status == http.StatusServiceUnavailable
+		}, 15*time.Second, 100*time.Millisecond)
+		assert.False(t, body.Alive)
+		assert.False(t, body.Ready)
+		require.NotNil(t, body.ExitCode)
+		assert.Equal(t, 3, *body.ExitCode)
+
+		status, _ := getHealth(t, prefix+"/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+	})
+
+	t.Run("other routes require authentication", func(t *testing.T) {
+		t.Parallel()
+		resp, err := tsServer.Client().Get(tsServer.URL + "/status")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestServer_Restart(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	startSession := func(t *testing.T, restart httpapi.RestartPolicy, program string, args ...string) string {
+		t.Helper()
+		body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: program, Args: args, Restart: restart})
+		require.NoError(t, err)
+		resp, err := tsServer.Client().Post(tsServer.URL+"/sessions", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var created httpapi.SessionInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		return "/sessions/" + created.Id
+	}
+	systemMessages := func(t *testing.T, prefix string) []string {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + "/messages")
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var body struct {
+			Messages []httpapi.Message `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		var contents []string
+		for _, msg := range body.Messages {
+			if msg.Role == "system" {
+				contents = append(contents, msg.Content)
+			}
+		}
+		return contents
+	}
+
+	t.Run("restart on failure", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, httpapi.RestartPolicyOnFailure, "sh", "-c", "echo started; sleep 0.2; exit 1")
+
+		require.Eventually(t, func() bool {
+			return len(systemMessages(t, prefix)) > 0
+		}, 15*time.Second, 100*time.Millisecond)
+		assert.Equal(t, "The agent exited with code 1 and was restarted.", systemMessages(t, prefix)[0])
+	})
+
+	t.Run("restart on demand", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, httpapi.RestartPolicyNever, "cat")
+
+		resp, err := tsServer.Client().Post(tsServer.URL+prefix+"/restart", "application/json", nil)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"The agent was restarted."}, systemMessages(t, prefix))
+
+		resp, err = tsServer.Client().Get(tsServer.URL + prefix + "/healthz")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("default session without agent", func(t *testing.T) {
+		t.Parallel()
+		resp, err := tsServer.Client().Post(tsServer.URL+"/restart", "application/json", nil)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestServer_PendingAction(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any) (int, []byte) {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+	// startSession starts an agent that asks for permission and prints the key it receives.
+	startSession := func(t *testing.T) string {
+		t.Helper()
+		script := `stty -icanon -echo
+printf 'Bash command\n\n  git status\n\nDo you want to proceed?\n\342\235\257 1. Yes\n  2. No\n'
+key=$(dd bs=1 count=1 2>/dev/null)
+printf '\033[2J\033[Hanswer: %s\n' "$key"
+exec sleep 60`
+		status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program:   "sh",
+			Args:      []string{"-c", script},
+			AgentType: msgfmt.AgentTypeClaude,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var created httpapi.SessionInfo
+		require.NoError(t, json.Unmarshal(body, &created))
+		return "/sessions/" + created.Id
+	}
+	getStatus := func(t *testing.T, prefix string) httpapi.StatusResponse {
+		t.Helper()
+		status, body := doRequest(t, http.MethodGet, prefix+"/status", nil)
+		require.Equal(t, http.StatusOK, status, string(body))
+		var resp httpapi.StatusResponse
+		require.NoError(t, json.Unmarshal(body, &resp.Body))
+		return resp
+	}
+	waitForAction := func(t *testing.T, prefix string) *httpapi.PendingAction {
+		t.Helper()
+		var action *httpapi.PendingAction
+		require.Eventually(t, func() bool {
+			action = getStatus(t, prefix).Body.PendingAction
+			return action != nil
+		}, 15*time.Second, 100*time.Millisecond)
+		return action
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t)
+		action := waitForAction(t, prefix)
+		assert.Equal(t, "Do you want to proceed?", action.Question)
+		assert.Equal(t, "git status", action.Details)
+		assert.Equal(t, []httpapi.ActionOption{
+			{Decision: msgfmt.ActionDecisionApprove, Label: "Yes"},
+			{Decision: msgfmt.ActionDecisionDeny, Label: "No"},
+		}, action.Options)
+
+		status, body := doRequest(t, http.MethodPost, fmt.Sprintf("%s/actions/%d", prefix, action.Id), httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionApprove,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		assert.Nil(t, getStatus(t, prefix).Body.PendingAction)
+
+		require.Eventually(t, func() bool {
+			_, body := doRequest(t, http.MethodGet, prefix+"/messages", nil)
+			return strings.Contains(string(body), "answer: 1")
+		}, 15*time.Second, 100*time.Millisecond)
+
+		// The prompt was answered, so it isn't reported again.
+		status, _ = doRequest(t, http.MethodPost, fmt.Sprintf("%s/actions/%d", prefix, action.Id), httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionApprove,
+		})
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t)
+		action := waitForAction(t, prefix)
+
+		// The prompt has no option to always allow the action.
+		status, _ := doRequest(t, http.MethodPost, fmt.Sprintf("%s/actions/%d", prefix, action.Id), httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionAlways,
+		})
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = doRequest(t, http.MethodPost, fmt.Sprintf("%s/actions/%d", prefix, action.Id+1), httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionDeny,
+		})
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.NotNil(t, getStatus(t, prefix).Body.PendingAction)
+
+		status, _ = doRequest(t, http.MethodPost, fmt.Sprintf("%s/actions/%d", prefix, action.Id), httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionDeny,
+		})
+		assert.Equal(t, http.StatusOK, status)
+		require.Eventually(t, func() bool {
+			_, body := doRequest(t, http.MethodGet, prefix+"/messages", nil)
+			return strings.Contains(string(body), "answer: 2")
+		}, 15*time.Second, 100*time.Millisecond)
+	})
+}
+
+func TestServer_ApprovalPolicy(t *testing.T) {
+	t.Parallel()
+	approvalPolicy, err := policy.Parse([]byte("rules:\n  - command: git status\n    decision: approve\n  - command: rm -rf *\n    decision: deny\n"))
+	require.NoError(t, err)
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+		ApprovalPolicy: approvalPolicy,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	doRequest := func(t *testing.T, method, path string, body any) (int, []byte) {
+		t.Helper()
+		var reqBody io.Reader
+		if body != nil {
+			bodyBytes, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+		req, err := http.NewRequest(method, tsServer.URL+path, reqBody)
+		require.NoError(t, err)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := tsServer.Client().Do(req)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, respBody
+	}
+	// startSession starts an agent that asks for permission to run the command
+	// and prints the key it receives.
+	startSession := func(t *testing.T, command string) string {
+		t.Helper()
+		script := `stty -icanon -echo
+printf 'Bash command\n\n  %s\n\nDo you want to proceed?\n\342\235\257 1. Yes\n  2. No\n' "$0"
+key=$(dd bs=1 count=1 2>/dev/null)
+printf '\033[2J\033[Hanswer: %s\n' "$key"
+exec sleep 60`
+		status, body := doRequest(t, http.MethodPost, "/sessions", httpapi.CreateSessionRequestBody{
+			Program:   "sh",
+			Args:      []string{"-c", script, command},
+			AgentType: msgfmt.AgentTypeClaude,
+		})
+		require.Equal(t, http.StatusOK, status, string(body))
+		var created httpapi.SessionInfo
+		require.NoError(t, json.Unmarshal(body, &created))
+		return "/sessions/" + created.Id
+	}
+	waitForMessage := func(t *testing.T, prefix string, content string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			_, body := doRequest(t, http.MethodGet, prefix+"/messages", nil)
+			return strings.Contains(string(body), content)
+		}, 15*time.Second, 100*time.Millisecond)
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "git status")
+		waitForMessage(t, prefix, "answer: 1")
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "rm -rf build")
+		waitForMessage(t, prefix, "answer: 2")
+	})
+
+	t.Run("ask", func(t *testing.T) {
+		t.Parallel()
+		prefix := startSession(t, "git push")
+		require.Eventually(t, func() bool {
+			_, body := doRequest(t, http.MethodGet, prefix+"/status", nil)
+			var status httpapi.StatusResponse
+			require.NoError(t, json.Unmarshal(body, &status.Body))
+			return status.Body.PendingAction != nil
+		}, 15*time.Second, 100*time.Millisecond)
+
+		// Unblock the agent so the session can be closed quickly.
+		status, _ := doRequest(t, http.MethodPost, prefix+"/actions/1", httpapi.ActionRequestBody{
+			Decision: msgfmt.ActionDecisionDeny,
+		})
+		assert.Equal(t, http.StatusOK, status)
+	})
+}
+
+func TestServer_Export(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeCustom,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	script := "printf 'Run it with:\\n\\n```sh\\ngo run .\\n```\\n'; exec sleep 60"
+	body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: "sh", Args: []string{"-c", script}})
+	require.NoError(t, err)
+	resp, err := tsServer.Client().Post(tsServer.URL+"/sessions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+	prefix := "/sessions/" + created.Id
+
+	export := func(t *testing.T, format string) (*http.Response, string) {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + "/export?format=" + format)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(respBody)
+	}
+	require.Eventually(t, func() bool {
+		_, body := export(t, "md")
+		return strings.Contains(body, "go run .")
+	}, 15*time.Second, 100*time.Millisecond)
+
+	t.Run("markdown", func(t *testing.T) {
+		resp, body := export(t, "md")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/markdown; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, fmt.Sprintf("attachment; filename=conversation-%s.md", created.Id), resp.Header.Get("Content-Disposition"))
+		assert.True(t, strings.HasPrefix(body, "# Conversation with custom\n"), body)
+		assert.Regexp(t, `(?m)^## Agent · \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} UTC$`, body)
+		assert.Contains(t, body, "Run it with:\n\n```sh\ngo run .\n```\n")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		resp, body := export(t, "html")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, body, "<h1>Conversation with custom</h1>")
+		assert.Regexp(t, `<h2>Agent <time datetime="[^"]+">[^<]+ UTC</time></h2>`, body)
+		assert.Contains(t, body, `<pre><code class="language-sh">go run .</code></pre>`)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		resp, body := export(t, "json")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var exported struct {
+			AgentType msgfmt.AgentType  `json:"agent_type"`
+			Messages  []httpapi.Message `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &exported))
+		assert.Equal(t, msgfmt.AgentTypeCustom, exported.AgentType)
+		require.Len(t, exported.Messages, 1)
+		assert.Contains(t, exported.Messages[0].Parts, msgfmt.MessagePart{Type: msgfmt.MessagePartTypeCode, Content: "go run .", Language: "sh"})
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		resp, _ := export(t, "pdf")
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+}
+
+func TestServer_Screen(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeCustom,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	script := `printf '\033[1;31merror\033[0m: failed\n'; exec sleep 60`
+	body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: "sh", Args: []string{"-c", script}})
+	require.NoError(t, err)
+	resp, err := tsServer.Client().Post(tsServer.URL+"/sessions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+	prefix := "/sessions/" + created.Id
+
+	getScreen := func(t *testing.T, format httpapi.ScreenFormat) httpapi.ScreenResponse {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + "/screen?format=" + string(format))
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var screen httpapi.ScreenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&screen.Body))
+		return screen
+	}
+	require.Eventually(t, func() bool {
+		return getScreen(t, httpapi.ScreenFormatText).Body.Screen == "error: failed"
+	}, 15*time.Second, 100*time.Millisecond)
+
+	t.Run("ansi", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "\x1b[0;1;91merror\x1b[0m: failed", getScreen(t, httpapi.ScreenFormatANSI).Body.Screen)
+	})
+
+	t.Run("runs", func(t *testing.T) {
+		t.Parallel()
+		screen := getScreen(t, httpapi.ScreenFormatRuns)
+		assert.Equal(t, [][]termexec.StyledRun{{
+			{Text: "error", Style: termexec.Style{Fg: 9, Bg: termexec.ColorDefault, Bold: true}},
+			{Text: ": failed", Style: termexec.DefaultStyle},
+		}}, screen.Body.Rows)
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, _, err := websocket.Dial(ctx, tsServer.URL+prefix+"/ws?types=screen_update&screen_format=ansi", nil)
+		require.NoError(t, err)
+		defer func() {
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+		}()
+		for {
+			var event struct {
+				Type httpapi.EventType        `json:"type"`
+				Data httpapi.ScreenUpdateBody `json:"data"`
+			}
+			require.NoError(t, wsjson.Read(ctx, conn, &event))
+			require.Equal(t, httpapi.EventTypeScreenUpdate, event.Type)
+			if event.Data.Screen != "" {
+				assert.Equal(t, "\x1b[0;1;91merror\x1b[0m: failed", event.Data.Screen)
+				return
+			}
+		}
+	})
+
+	t.Run("stream runs", func(t *testing.T) {
+		t.Parallel()
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + "/internal/screen?format=runs")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+}
+
+func TestServer_TerminalSize(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeCustom,
+		Process:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	// The agent clears the screen and prints its size whenever it's resized.
+	script := `trap 'clear; stty size' WINCH; stty size; while :; do sleep 0.1; done`
+	body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: "sh", Args: []string{"-c", script}})
+	require.NoError(t, err)
+	resp, err := tsServer.Client().Post(tsServer.URL+"/sessions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+	prefix := "/sessions/" + created.Id
+
+	resize := func(t *testing.T, prefix string, body string) *http.Response {
+		t.Helper()
+		resp, err := tsServer.Client().Post(tsServer.URL+prefix+"/terminal/size", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+	getJSON := func(t *testing.T, path string, v any) {
+		t.Helper()
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + path)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	screen := func(t *testing.T) string {
+		t.Helper()
+		var body httpapi.ScreenResponse
+		getJSON(t, "/screen", &body.Body)
+		return body.Body.Screen
+	}
+	agentMessages := func(t *testing.T) []string {
+		t.Helper()
+		var body struct {
+			Messages []httpapi.Message `json:"messages"`
+		}
+		getJSON(t, "/messages", &body)
+		var contents []string
+		for _, msg := range body.Messages {
+			if msg.Role == "agent" {
+				contents = append(contents, strings.TrimSpace(msg.Content))
+			}
+		}
+		return contents
+	}
+	stable := func(t *testing.T) bool {
+		t.Helper()
+		var body httpapi.StatusResponse
+		getJSON(t, "/status", &body.Body)
+		return body.Body.Status == httpapi.AgentStatusStable
+	}
+	require.Eventually(t, func() bool {
+		return stable(t) && screen(t) == "24 80"
+	}, 15*time.Second, 100*time.Millisecond)
+	require.Equal(t, []string{"24 80"}, agentMessages(t))
+
+	resp = resize(t, prefix, `{"width": 100}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var size httpapi.TerminalSizeResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&size.Body))
+	assert.Equal(t, uint16(100), size.Body.Width)
+	assert.Equal(t, uint16(24), size.Body.Height)
+
+	require.Eventually(t, func() bool {
+		return stable(t) && screen(t) == "24 100"
+	}, 15*time.Second, 100*time.Millisecond)
+	// The redrawn screen isn't mistaken for a reply.
+	assert.Equal(t, []string{"24 80"}, agentMessages(t))
+
+	t.Run("invalid size", func(t *testing.T) {
+		t.Parallel()
+		resp := resize(t, prefix, `{"width": 5}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("no agent", func(t *testing.T) {
+		t.Parallel()
+		resp := resize(t, "", `{"width": 100}`)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestServer_Scrollback(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:       msgfmt.AgentTypeCustom,
+		Process:         nil,
+		Port:            0,
+		ChatBasePath:    "/chat",
+		AllowedHosts:    []string{"*"},
+		AllowedOrigins:  []string{"*"},
+		TerminalWidth:   80,
+		TerminalHeight:  24,
+		ScrollbackLines: 1000,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+	t.Cleanup(func() {
+		_ = srv.Stop(context.Background())
+	})
+
+	// The reply is longer than the terminal is high.
+	script := `echo ready; read line; seq 1 100; exec sleep 60`
+	body, err := json.Marshal(httpapi.CreateSessionRequestBody{Program: "sh", Args: []string{"-c", script}})
+	require.NoError(t, err)
+	resp, err := tsServer.Client().Post(tsServer.URL+"/sessions", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var created httpapi.SessionInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	_ = resp.Body.Close()
+	prefix := "/sessions/" + created.Id
+
+	require.Eventually(t, func() bool {
+		resp, err := tsServer.Client().Get(tsServer.URL + prefix + "/status")
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var status httpapi.SessionInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		return status.Status == httpapi.AgentStatusStable
+	}, 15*time.Second, 100*time.Millisecond)
+
+	body, err = json.Marshal(httpapi.MessageRequestBody{Type: httpapi.MessageTypeUser, Content: "count", Wait: true})
+	require.NoError(t, err)
+	resp, err = tsServer.Client().Post(tsServer.URL+prefix+"/message", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var reply struct {
+		Reply *httpapi.Message `json:"reply"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&reply))
+	require.NotNil(t, reply.Reply)
+
+	var lines []string
+	for _, line := range strings.Split(reply.Reply.Content, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	var expected []string
+	for i := 1; i <= 100; i++ {
+		expected = append(expected, strconv.Itoa(i))
+	}
+	assert.Equal(t, expected, lines)
+}
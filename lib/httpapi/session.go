@@ -0,0 +1,563 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/policy"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// DefaultSessionID is the ID of the session that owns the agent passed to NewServer.
+// Its routes are served both at the root of the API and under /sessions/default.
+const DefaultSessionID = "default"
+
+// sessionRoutePrefix is prepended to every session route to address a specific session.
+const sessionRoutePrefix = "/sessions/{sessionId}"
+
+// session is a single agent process together with the conversation tracked
+// on its screen and the emitter that streams the conversation to subscribers.
+type session struct {
+	id           string
+	mu           sync.RWMutex
+	logger       *slog.Logger
+	conversation *st.Conversation
+	agentio      *termexec.Process
+	agentType    mf.AgentType
+	emitter      *EventEmitter
+	queue        *messageQueue
+	createdAt    time.Time
+	// journal persists the conversation history. It's nil if persistence is disabled.
+	journal *st.MessageJournal
+	// loopCtx is canceled to stop the snapshot loop and the supervisor.
+	// It's nil until the loop is started.
+	loopCtx context.Context
+	cancel  context.CancelFunc
+	// loops tracks the snapshot loop and the supervisor.
+	loops   sync.WaitGroup
+	metrics *metrics
+	// processConfig is used to start the agent again when it's restarted.
+	// Its program is empty if the session's agent can't be restarted.
+	processConfig  SetupProcessConfig
+	restartPolicy  RestartPolicy
+	agentStartedAt time.Time
+	// agentDone is closed when the supervisor exits, i.e. when the agent exited
+	// and wasn't restarted. agentErr is set before if the exit code wasn't zero.
+	agentDone chan struct{}
+	agentErr  error
+	closeOnce sync.Once
+	closeErr  error
+	// everStable is set once the agent has been stable, i.e. has finished starting up.
+	everStable atomic.Bool
+	// initialPromptSent mirrors conversation.InitialPromptSent, which is only
+	// safe to access from the snapshot loop.
+	initialPromptSent atomic.Bool
+	// pendingAction is the permission prompt the agent is waiting on, and prompt
+	// the keystrokes that answer it. Both are nil if there's no prompt.
+	pendingAction *PendingAction
+	prompt        *mf.PermissionPrompt
+	lastActionId  int
+	// answeredScreen is the screen at the time the last prompt was answered.
+	answeredScreen string
+	// policy answers permission prompts. It's nil if there's no approval policy.
+	policy *policy.Policy
+}
+
+type sessionConfig struct {
+	id            string
+	agentType     mf.AgentType
+	process       *termexec.Process
+	initialPrompt string
+	journal       *st.MessageJournal
+	// history contains the messages restored from the journal.
+	history []st.ConversationMessage
+	metrics *metrics
+	// processConfig is the configuration the process was started with.
+	processConfig SetupProcessConfig
+	restartPolicy RestartPolicy
+	policy        *policy.Policy
+}
+
+func newSession(ctx context.Context, cfg sessionConfig) *session {
+	formatMessage := func(message string, userInput string) string {
+		return mf.FormatAgentMessage(cfg.agentType, message, userInput)
+	}
+	conversation := st.NewConversation(ctx, st.ConversationConfig{
+		AgentType: cfg.agentType,
+		AgentIO:   cfg.process,
+		GetTime: func() time.Time {
+			return time.Now()
+		},
+		SnapshotInterval:      snapshotInterval,
+		ScreenStabilityLength: 2 * time.Second,
+		FormatMessage:         formatMessage,
+		History:               cfg.history,
+	}, cfg.initialPrompt)
+
+	sess := &session{
+		id:           cfg.id,
+		logger:       logctx.From(ctx).With("sessionId", cfg.id),
+		conversation: conversation,
+		agentio:      cfg.process,
+		agentType:    cfg.agentType,
+		emitter:      NewEventEmitter(1024, 1024),
+		queue:        newMessageQueue(),
+		createdAt:    time.Now(),
+		journal:      cfg.journal,
+		metrics:      cfg.metrics,
+
+		processConfig:  cfg.processConfig,
+		restartPolicy:  cfg.restartPolicy,
+		agentStartedAt: time.Now(),
+		agentDone:      make(chan struct{}),
+		policy:         cfg.policy,
+	}
+	sess.initialPromptSent.Store(conversation.InitialPromptSent)
+	return sess
+}
+
+func (sess *session) startSnapshotLoop(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	sess.loopCtx = ctx
+	sess.cancel = cancel
+	sess.conversation.StartSnapshotLoop(ctx)
+	if sess.agentio != nil {
+		sess.loops.Add(1)
+		go sess.supervise(ctx, sess.agentDone)
+	}
+	sess.loops.Add(1)
+	go func() {
+		defer sess.loops.Done()
+		var previousStatus AgentStatus
+		previousTime := time.Now()
+		var styledScreenTime time.Time
+		for {
+			currentStatus := sess.conversation.Status()
+			if currentStatus == st.ConversationStatusStable {
+				sess.everStable.Store(true)
+			}
+
+			// Nothing is sent to the agent while it waits for permission, since the
+			// keystrokes would answer the prompt.
+			pendingAction := sess.updatePendingAction(currentStatus)
+			readyForInput := pendingAction == nil && convertStatus(currentStatus) == AgentStatusStable
+
+			// Send initial prompt when agent becomes stable for the first time
+			if !sess.conversation.InitialPromptSent && readyForInput {
+				if err := sess.sendUserMessage(sess.conversation.InitialPrompt); err != nil {
+					sess.logger.Error("Failed to send initial prompt", "error", err)
+				} else {
+					sess.conversation.InitialPromptSent = true
+					sess.initialPromptSent.Store(true)
+					currentStatus = st.ConversationStatusChanging
+					sess.logger.Info("Initial prompt sent successfully")
+				}
+			} else if readyForInput && sess.deliverQueuedMessage() {
+				currentStatus = st.ConversationStatusChanging
+			}
+			now := time.Now()
+			agentStatus := convertStatus(currentStatus)
+			sess.metrics.observeStatus(sess.id, previousStatus, agentStatus, now.Sub(previousTime))
+			previousStatus, previousTime = agentStatus, now
+
+			messages := sess.conversation.Messages()
+			if sess.journal != nil {
+				if err := sess.journal.Sync(messages, currentStatus == st.ConversationStatusStable); err != nil {
+					sess.logger.Error("Failed to persist messages", "error", err)
+				}
+			}
+			sess.emitter.UpdateStatusAndEmitChanges(currentStatus, sess.agentType)
+			sess.emitter.UpdatePendingActionAndEmitChanges(pendingAction)
+			sess.emitter.UpdateMessagesAndEmitChanges(messages)
+			sess.emitter.UpdateScreenAndEmitChanges(sess.conversation.Screen())
+			styledScreenTime = sess.updateStyledScreen(styledScreenTime)
+			sess.metrics.snapshotDuration.WithLabelValues(sess.id).Observe(time.Since(now).Seconds())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(snapshotInterval):
+			}
+		}
+	}()
+}
+
+// styledScreenInterval is how often the styled screen is read while the agent
+// keeps writing to the terminal.
+const styledScreenInterval = 100 * time.Millisecond
+
+// updateStyledScreen emits the styled screen if the agent wrote to the terminal since
+// the screen was last read at lastRead, and returns the time of the last read.
+// To keep the snapshot loop from waiting for the screen to be stable, the screen
+// is only read once the agent paused writing, or after styledScreenInterval.
+func (sess *session) updateStyledScreen(lastRead time.Time) time.Time {
+	sess.mu.RLock()
+	process := sess.agentio
+	sess.mu.RUnlock()
+	if process == nil {
+		return lastRead
+	}
+	lastUpdate := process.LastScreenUpdate()
+	if !lastUpdate.After(lastRead) {
+		return lastRead
+	}
+	if time.Since(lastUpdate) < 16*time.Millisecond && time.Since(lastRead) < styledScreenInterval {
+		return lastRead
+	}
+	now := time.Now()
+	sess.emitter.UpdateStyledScreenAndEmitChanges(termexec.EncodeANSI(process.ReadStyledScreen()))
+	return now
+}
+
+// sendUserMessage sends a user message to the agent and records the outcome in the metrics.
+func (sess *session) sendUserMessage(content string) error {
+	start := time.Now()
+	err := sess.conversation.SendMessage(FormatMessage(sess.agentType, content)...)
+	sess.metrics.observeSendMessage(sess.id, time.Since(start), err)
+	return err
+}
+
+// deliverQueuedMessage sends the message at the front of the queue to the agent.
+// It returns true if a message was sent.
+func (sess *session) deliverQueuedMessage() bool {
+	// Holding the lock for the whole delivery ensures that a message can't be
+	// removed from the queue by a client while it's being sent.
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	msg, ok := sess.queue.peek()
+	if !ok {
+		return false
+	}
+	err := sess.sendUserMessage(msg.Content)
+	if errors.Is(err, st.MessageValidationErrorChanging) {
+		// The agent started running since its status was checked.
+		// The message will be retried once the agent is stable again.
+		return false
+	}
+	sess.queue.remove(msg.Id)
+	if err != nil {
+		sess.logger.Error("Failed to send queued message, dropping it", "queueId", msg.Id, "error", err)
+		return false
+	}
+	sess.logger.Info("Sent queued message", "queueId", msg.Id)
+	return true
+}
+
+// waitForReply waits until the agent is stable after receiving the user message
+// with the given ID, and returns the agent's reply. If the timeout expires first,
+// it returns a ReplyTimeoutError containing the reply so far.
+func (sess *session) waitForReply(ctx context.Context, userMessageId int, timeout time.Duration) (*Message, error) {
+	stable, err := sess.waitForStable(ctx, timeout)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to wait for reply: %w", err)
+	}
+	if !stable {
+		return nil, &ReplyTimeoutError{
+			ErrorModel: huma.ErrorModel{
+				Status: http.StatusGatewayTimeout,
+				Title:  http.StatusText(http.StatusGatewayTimeout),
+				Detail: fmt.Sprintf("the agent didn't finish replying within %s", timeout),
+			},
+			Reply: sess.replyTo(userMessageId),
+		}
+	}
+	return sess.replyTo(userMessageId), nil
+}
+
+// waitForStable waits until the conversation is stable. It returns false if
+// the timeout expires first.
+func (sess *session) waitForStable(ctx context.Context, timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		if sess.conversation.Status() == st.ConversationStatusStable {
+			return true, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timer.C:
+			return false, nil
+		case <-time.After(snapshotInterval):
+		}
+	}
+}
+
+// replyTo returns the agent message that follows the user message with the
+// given ID, or nil if the agent hasn't replied yet.
+func (sess *session) replyTo(userMessageId int) *Message {
+	for _, msg := range sess.conversation.Messages() {
+		if msg.Id > userMessageId && msg.Role == st.ConversationRoleAgent {
+			return &Message{
+				Id:      msg.Id,
+				Role:    msg.Role,
+				Content: msg.Message,
+				Parts:   messageParts(sess.agentType, msg),
+				Time:    msg.Time,
+			}
+		}
+	}
+	return nil
+}
+
+// close stops the snapshot loop, the supervisor, and the agent process.
+// It's safe to call more than once.
+func (sess *session) close() error {
+	sess.closeOnce.Do(func() {
+		if sess.cancel != nil {
+			// Canceling with the lock held ensures that restartAgent doesn't
+			// start a new supervisor once the loops are being waited for.
+			sess.mu.Lock()
+			sess.cancel()
+			sess.mu.Unlock()
+			sess.loops.Wait()
+		}
+		sess.closeJournal()
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		if sess.agentio != nil {
+			sess.closeErr = sess.agentio.Close(sess.logger, 5*time.Second)
+		}
+	})
+	return sess.closeErr
+}
+
+func (sess *session) closeJournal() {
+	if sess.journal == nil {
+		return
+	}
+	if err := sess.journal.Close(); err != nil {
+		sess.logger.Error("Failed to close message journal", "error", err)
+	}
+}
+
+func (sess *session) info() SessionInfo {
+	return SessionInfo{
+		Id:        sess.id,
+		AgentType: sess.agentType,
+		Status:    convertStatus(sess.conversation.Status()),
+		CreatedAt: sess.createdAt,
+	}
+}
+
+type sessionContextKey struct{}
+
+// sessionFrom returns the session resolved by sessionMiddleware.
+func sessionFrom(ctx context.Context) *session {
+	sess, ok := ctx.Value(sessionContextKey{}).(*session)
+	if !ok {
+		panic("no session found in context")
+	}
+	return sess
+}
+
+// sessionMiddleware resolves the session addressed by the request and stores it
+// in the request context. Routes without a session ID use the default session.
+func (s *Server) sessionMiddleware(ctx huma.Context, next func(huma.Context)) {
+	id := ctx.Param("sessionId")
+	if id == "" {
+		id = DefaultSessionID
+	}
+	sess, ok := s.lookupSession(id)
+	if !ok {
+		_ = huma.WriteErr(s.api, ctx, http.StatusNotFound, fmt.Sprintf("session %q not found", id))
+		return
+	}
+	next(huma.WithValue(ctx, sessionContextKey{}, sess))
+}
+
+// sessionRoutesModifier registers every session operation twice: once at the
+// root of the API for the default session, and once under /sessions/{sessionId}.
+func sessionRoutesModifier(o *huma.Operation, next func(*huma.Operation)) {
+	next(o)
+
+	sessionOp := *o
+	sessionOp.Path = sessionRoutePrefix + o.Path
+	// Operation IDs generated by the convenience functions are regenerated from the
+	// new path by huma. Explicit operation IDs must be made unique here.
+	if o.Metadata == nil || o.Metadata["_convenience_id"] != o.OperationID {
+		sessionOp.OperationID = "session" + strings.ToUpper(o.OperationID[:1]) + o.OperationID[1:]
+	}
+	sessionOp.Parameters = append(slices.Clone(o.Parameters), &huma.Param{
+		Name:        "sessionId",
+		In:          "path",
+		Description: fmt.Sprintf("ID of the session. The session of the agent the server was started with is '%s'.", DefaultSessionID),
+		Required:    true,
+		Schema:      &huma.Schema{Type: "string"},
+	})
+	next(&sessionOp)
+}
+
+func (s *Server) lookupSession(id string) (*session, bool) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	sess, ok := s.sessions[id]
+	return sess, ok
+}
+
+// sessionList returns all sessions, ordered by creation time.
+func (s *Server) sessionList() []*session {
+	s.sessionsMu.RLock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.sessionsMu.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].createdAt.Before(sessions[j].createdAt)
+	})
+	return sessions
+}
+
+// newRandomID returns a random hex-encoded identifier.
+func newRandomID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// listSessions handles GET /sessions
+func (s *Server) listSessions(ctx context.Context, input *struct{}) (*SessionsResponse, error) {
+	sessions := s.sessionList()
+	resp := &SessionsResponse{}
+	resp.Body.Sessions = make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		resp.Body.Sessions = append(resp.Body.Sessions, sess.info())
+	}
+	return resp, nil
+}
+
+// getSession handles GET /sessions/{sessionId}
+func (s *Server) getSession(ctx context.Context, input *SessionRequest) (*SessionResponse, error) {
+	sess, ok := s.lookupSession(input.SessionID)
+	if !ok {
+		return nil, huma.Error404NotFound(fmt.Sprintf("session %q not found", input.SessionID))
+	}
+	resp := &SessionResponse{}
+	resp.Body = sess.info()
+	return resp, nil
+}
+
+// createSession handles POST /sessions
+func (s *Server) createSession(ctx context.Context, input *CreateSessionRequest) (*SessionResponse, error) {
+	if input.Body.Program == "" {
+		return nil, huma.Error400BadRequest("program must not be empty")
+	}
+	agentType := mf.AgentTypeCustom
+	if input.Body.AgentType != "" {
+		// Profiles can also be selected by their aliases.
+		profile, ok := mf.ProfileByName(string(input.Body.AgentType))
+		if !ok {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("unknown agent type %q", input.Body.AgentType))
+		}
+		agentType = profile.Name
+	}
+
+	restartPolicy, err := ParseRestartPolicy(string(input.Body.Restart))
+	if err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+
+	id, err := newRandomID()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to generate session id: %w", err)
+	}
+	processConfig := SetupProcessConfig{
+		Program:         input.Body.Program,
+		ProgramArgs:     input.Body.Args,
+		TerminalWidth:   s.terminalWidth,
+		TerminalHeight:  s.terminalHeight,
+		ScrollbackLines: s.scrollbackLines,
+		AgentType:       agentType,
+	}
+	process, err := StartAgentProcess(s.ctx, processConfig)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to start agent: %w", err)
+	}
+
+	sess := newSession(s.ctx, sessionConfig{
+		id:            id,
+		agentType:     agentType,
+		process:       process,
+		initialPrompt: input.Body.InitialPrompt,
+		metrics:       s.metrics,
+		processConfig: processConfig,
+		restartPolicy: restartPolicy,
+		policy:        s.approvalPolicy,
+	})
+	sess.startSnapshotLoop(s.ctx)
+
+	s.sessionsMu.Lock()
+	s.sessions[id] = sess
+	s.sessionsMu.Unlock()
+	s.logger.Info("Created session", "sessionId", id, "program", input.Body.Program)
+
+	resp := &SessionResponse{}
+	resp.Body = sess.info()
+	return resp, nil
+}
+
+// deleteSession handles DELETE /sessions/{sessionId}
+func (s *Server) deleteSession(ctx context.Context, input *SessionRequest) (*DeleteSessionResponse, error) {
+	if input.SessionID == DefaultSessionID {
+		return nil, huma.Error400BadRequest("the default session can't be deleted")
+	}
+	s.sessionsMu.Lock()
+	sess, ok := s.sessions[input.SessionID]
+	delete(s.sessions, input.SessionID)
+	s.sessionsMu.Unlock()
+	if !ok {
+		return nil, huma.Error404NotFound(fmt.Sprintf("session %q not found", input.SessionID))
+	}
+
+	if err := sess.close(); err != nil {
+		s.logger.Error("Failed to close session", "sessionId", sess.id, "error", err)
+	}
+	s.metrics.forgetSession(sess.id)
+	s.logger.Info("Deleted session", "sessionId", sess.id)
+
+	resp := &DeleteSessionResponse{}
+	resp.Body.Ok = true
+	return resp, nil
+}
+
+// closeSessions closes every session, including the default one.
+func (s *Server) closeSessions() {
+	s.sessionsMu.Lock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for id, sess := range s.sessions {
+		sessions = append(sessions, sess)
+		// The default session is kept so its routes keep resolving until the
+		// HTTP server is shut down.
+		if id != DefaultSessionID {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessionsMu.Unlock()
+
+	for _, sess := range sessions {
+		if err := sess.close(); err != nil {
+			s.logger.Error("Failed to close session", "sessionId", sess.id, "error", err)
+		}
+	}
+}
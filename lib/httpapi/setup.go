@@ -3,11 +3,7 @@ package httpapi
 import (
 	"context"
 	"fmt"
-	"os"
-	"os/signal"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/coder/agentapi/lib/logctx"
 	mf "github.com/coder/agentapi/lib/msgfmt"
@@ -19,42 +15,41 @@ type SetupProcessConfig struct {
 	ProgramArgs    []string
 	TerminalWidth  uint16
 	TerminalHeight uint16
-	AgentType      mf.AgentType
+	// ScrollbackLines is the number of lines that scrolled off the top of the
+	// terminal to keep. The scrollback is disabled if it's 0.
+	ScrollbackLines int
+	AgentType       mf.AgentType
+	// Recorder, if set, records the agent's terminal. It's shared by the agents
+	// started with the config, so restarts are recorded in the same file.
+	Recorder *termexec.Recorder
 }
 
-func SetupProcess(ctx context.Context, config SetupProcessConfig) (*termexec.Process, error) {
+// StartAgentProcess starts the agent in a new pseudo terminal and sends it the
+// startup keys of its profile. Agents are started on demand while the server is
+// running, so errors are returned to the caller and no signal handlers are installed.
+func StartAgentProcess(ctx context.Context, config SetupProcessConfig) (*termexec.Process, error) {
 	logger := logctx.From(ctx)
 
 	logger.Info(fmt.Sprintf("Running: %s %s", config.Program, strings.Join(config.ProgramArgs, " ")))
 
 	process, err := termexec.StartProcess(ctx, termexec.StartProcessConfig{
-		Program:        config.Program,
-		Args:           config.ProgramArgs,
-		TerminalWidth:  config.TerminalWidth,
-		TerminalHeight: config.TerminalHeight,
+		Program:         config.Program,
+		Args:            config.ProgramArgs,
+		TerminalWidth:   config.TerminalWidth,
+		TerminalHeight:  config.TerminalHeight,
+		ScrollbackLines: config.ScrollbackLines,
+		Recorder:        config.Recorder,
 	})
 	if err != nil {
-		logger.Error(fmt.Sprintf("Error starting process: %v", err))
-		os.Exit(1)
+		return nil, err
 	}
 
-	// Hack for sourcegraph amp to stop the animation.
-	if config.AgentType == mf.AgentTypeAmp {
-		_, err = process.Write([]byte(" \b"))
+	if keys := mf.ProfileOf(config.AgentType).StartupKeys; keys != "" {
+		_, err = process.Write([]byte(keys))
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// Handle SIGINT (Ctrl+C) and send it to the process
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-signalCh
-		if err := process.Close(logger, 5*time.Second); err != nil {
-			logger.Error("Error closing process", "error", err)
-		}
-	}()
-
 	return process, nil
 }
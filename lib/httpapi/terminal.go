@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"context"
+
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// resizeTerminal handles POST /terminal/size
+func (s *Server) resizeTerminal(ctx context.Context, input *TerminalSizeRequest) (*TerminalSizeResponse, error) {
+	sess := sessionFrom(ctx)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.agentio == nil {
+		return nil, huma.Error400BadRequest("the session has no agent")
+	}
+
+	width, height := sess.agentio.Size()
+	if input.Body.Width != 0 {
+		width = input.Body.Width
+	}
+	if input.Body.Height != 0 {
+		height = input.Body.Height
+	}
+	if err := sess.agentio.Resize(width, height); err != nil {
+		return nil, xerrors.Errorf("failed to resize terminal: %w", err)
+	}
+	// The agent redraws the screen after it's resized. The conversation must
+	// not mistake the redrawn screen for new output.
+	sess.conversation.Resized()
+	// A restarted agent gets a terminal of the same size.
+	sess.processConfig.TerminalWidth = width
+	sess.processConfig.TerminalHeight = height
+	sess.logger.Info("Resized terminal", "width", width, "height", height)
+
+	resp := &TerminalSizeResponse{}
+	resp.Body.Width = width
+	resp.Body.Height = height
+	return resp, nil
+}
@@ -0,0 +1,767 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// DefaultUploadMaxFileSize is the size limit of a single uploaded file.
+	DefaultUploadMaxFileSize = 10 << 20
+	// maxUploadFiles is the maximum number of files uploaded at once.
+	maxUploadFiles = 20
+	// uploadRequestOverhead is the space the multipart encoding and the form
+	// fields of an upload request may take up in addition to the files.
+	uploadRequestOverhead = 64 << 10
+)
+
+// uploadStore keeps track of the files uploaded to the server. Every upload to
+// the temporary directory is stored in its own directory under dir, so files
+// with the same name don't collide.
+type uploadStore struct {
+	dir string
+	// workDir is the agent's working directory, with symlinks resolved. Files
+	// uploaded to the workdir target must stay inside it.
+	workDir     string
+	maxFileSize int64
+	// quota is the maximum total size of the stored files. Zero means no quota.
+	quota int64
+
+	mu        sync.Mutex
+	uploads   map[string]UploadInfo
+	totalSize int64
+}
+
+func newUploadStore(dir string, workDir string, maxFileSize int64, quota int64) *uploadStore {
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultUploadMaxFileSize
+	}
+	return &uploadStore{
+		dir:         dir,
+		workDir:     workDir,
+		maxFileSize: maxFileSize,
+		quota:       quota,
+		uploads:     map[string]UploadInfo{},
+	}
+}
+
+// pendingUpload is a file that was read from the request but isn't stored yet.
+type pendingUpload struct {
+	name        string
+	contentType string
+	data        []byte
+	// path is where the file is written in the working directory. It's empty
+	// for files stored in the temporary directory.
+	path string
+}
+
+// uploadOptions are the options of an upload request that apply to all its files.
+type uploadOptions struct {
+	target    UploadTarget
+	path      string
+	overwrite bool
+}
+
+// save stores the files. The files are checked against the size limit, the
+// quota and their destination before any of them is written. Files uploaded
+// into the working directory are written to temporary files next to their
+// destination first, and only moved into place once all of them were written.
+// The files they replace are backed up until all of them are in place, so
+// either all of them are stored or none is.
+func (u *uploadStore) save(files []pendingUpload, opts uploadOptions) ([]UploadInfo, error) {
+	var size int64
+	for _, file := range files {
+		if err := u.checkFileSize(int64(len(file.data))); err != nil {
+			return nil, err
+		}
+		size += int64(len(file.data))
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var dest *workdirDestination
+	if opts.target == UploadTargetWorkdir {
+		var err error
+		if dest, err = u.prepareWorkdirUpload(files, opts); err != nil {
+			return nil, err
+		}
+	}
+	// Overwritten files no longer take up space for their previous uploads.
+	var replaced []string
+	for _, file := range files {
+		for id, info := range u.uploads {
+			if file.path != "" && info.Path == file.path {
+				replaced = append(replaced, id)
+				size -= info.Size
+			}
+		}
+	}
+	if u.quota > 0 && u.totalSize+size > u.quota {
+		return nil, u.quotaErrorLocked(size)
+	}
+
+	// The destination directory is only created once the upload was accepted.
+	if dest != nil {
+		if err := dest.create(); err != nil {
+			return nil, err
+		}
+	}
+	staged := make([]stagedUpload, 0, len(files))
+	discard := func() {
+		for _, file := range staged {
+			file.discard()
+		}
+		if dest != nil {
+			dest.removeCreated()
+		}
+	}
+	for _, file := range files {
+		s, err := u.stage(file)
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		staged = append(staged, s)
+	}
+	if err := commitAll(staged, opts.overwrite); err != nil {
+		discard()
+		return nil, err
+	}
+
+	for _, id := range replaced {
+		u.totalSize -= u.uploads[id].Size
+		delete(u.uploads, id)
+	}
+	infos := make([]UploadInfo, 0, len(staged))
+	for _, file := range staged {
+		u.uploads[file.info.Id] = file.info
+		u.totalSize += file.info.Size
+		infos = append(infos, file.info)
+	}
+	return infos, nil
+}
+
+func (u *uploadStore) checkFileSize(size int64) error {
+	if size > u.maxFileSize {
+		return huma.Error400BadRequest(fmt.Sprintf("file size exceeds %s limit", formatSize(u.maxFileSize)))
+	}
+	return nil
+}
+
+// quotaError returns the error for an upload of size bytes that doesn't fit
+// into the quota.
+func (u *uploadStore) quotaError(size int64) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.quotaErrorLocked(size)
+}
+
+func (u *uploadStore) quotaErrorLocked(size int64) error {
+	return huma.NewError(http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("upload quota of %s exceeded: %s used, %s uploaded", formatSize(u.quota), formatSize(u.totalSize), formatSize(size)))
+}
+
+// available returns how many bytes an upload may add to the stored files, or
+// false if there's no quota. Uploads into the working directory may replace
+// earlier ones, so the space of those counts as available too.
+func (u *uploadStore) available() (int64, bool) {
+	if u.quota <= 0 {
+		return 0, false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	available := u.quota - u.totalSize
+	for _, info := range u.uploads {
+		if info.Target == UploadTargetWorkdir {
+			available += info.Size
+		}
+	}
+	return available, true
+}
+
+// maxRequestSize is the size limit of the body of an upload request: the
+// files can't exceed the available space, nor the size limit of each file.
+func (u *uploadStore) maxRequestSize() int64 {
+	// Files are read one byte past the limit to detect files that exceed it.
+	size := maxUploadFiles * (u.maxFileSize + 1)
+	if available, ok := u.available(); ok {
+		size = min(size, available+1)
+	}
+	return size + uploadRequestOverhead
+}
+
+// commitAll moves the staged files into place. If one of them fails, the
+// files that were committed are reverted, restoring the files they replaced,
+// which are backed up first. The staged files must be discarded then.
+func commitAll(staged []stagedUpload, overwrite bool) error {
+	if overwrite {
+		for i := range staged {
+			if err := staged[i].backup(); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range staged {
+		if err := staged[i].commit(overwrite); err != nil {
+			for j := range staged[:i] {
+				staged[j].revert()
+			}
+			return err
+		}
+	}
+	for i := range staged {
+		staged[i].removeBackup()
+	}
+	return nil
+}
+
+// stagedUpload is a file that was written, but not moved into place yet.
+type stagedUpload struct {
+	info UploadInfo
+	// tmpPath is the temporary file an upload into the working directory is
+	// written to. It's moved to info.Path when the upload is committed.
+	tmpPath string
+	// backupPath is a hard link to the file the upload replaces, which is
+	// restored if the upload fails.
+	backupPath string
+}
+
+// backup links the file at the destination to a hidden file next to it, so
+// that it can be restored if the upload fails after replacing it.
+func (s *stagedUpload) backup() error {
+	if s.tmpPath == "" {
+		return nil
+	}
+	if _, err := os.Lstat(s.info.Path); os.IsNotExist(err) {
+		return nil
+	}
+	backupPath := filepath.Join(filepath.Dir(s.info.Path), ".agentapi-backup-"+s.info.Id)
+	// Linking doesn't follow a symlink at the destination.
+	if err := os.Link(s.info.Path, backupPath); err != nil {
+		return xerrors.Errorf("failed to back up %s: %w", s.info.Name, err)
+	}
+	s.backupPath = backupPath
+	return nil
+}
+
+// revert undoes a commit: the file that was replaced is restored, and a file
+// that didn't replace anything is removed.
+func (s *stagedUpload) revert() {
+	if s.info.Target != UploadTargetWorkdir || s.tmpPath != "" {
+		return
+	}
+	if s.backupPath != "" {
+		_ = os.Rename(s.backupPath, s.info.Path)
+		s.backupPath = ""
+	} else {
+		_ = os.Remove(s.info.Path)
+	}
+}
+
+// removeBackup removes the backup once the upload succeeded.
+func (s *stagedUpload) removeBackup() {
+	if s.backupPath != "" {
+		_ = os.Remove(s.backupPath)
+		s.backupPath = ""
+	}
+}
+
+// commit moves the file into place. Unless overwrite is set, it fails if a
+// file was created at the destination after it was checked.
+func (s *stagedUpload) commit(overwrite bool) error {
+	if s.tmpPath == "" {
+		return nil
+	}
+	if overwrite {
+		// Renaming replaces a symlink at the destination rather than following it.
+		if err := os.Rename(s.tmpPath, s.info.Path); err != nil {
+			return xerrors.Errorf("failed to write file: %w", err)
+		}
+	} else {
+		// Unlike renaming, linking fails if the destination exists.
+		if err := os.Link(s.tmpPath, s.info.Path); err != nil {
+			if os.IsExist(err) {
+				return huma.Error409Conflict(fmt.Sprintf("%q already exists, set overwrite to replace it", s.info.Name))
+			}
+			return xerrors.Errorf("failed to write file: %w", err)
+		}
+		_ = os.Remove(s.tmpPath)
+	}
+	s.tmpPath = ""
+	return nil
+}
+
+// discard removes the written file if it wasn't committed yet.
+func (s *stagedUpload) discard() {
+	s.removeBackup()
+	if s.tmpPath != "" {
+		_ = os.Remove(s.tmpPath)
+	} else if s.info.Target == UploadTargetTemp {
+		_ = os.RemoveAll(filepath.Dir(s.info.Path))
+	}
+}
+
+// stage writes a single file. Files uploaded to the temporary directory are
+// written in place, since nothing else is there. The caller must hold the lock.
+func (u *uploadStore) stage(file pendingUpload) (stagedUpload, error) {
+	id, err := newRandomID()
+	if err != nil {
+		return stagedUpload{}, xerrors.Errorf("failed to generate upload id: %w", err)
+	}
+	target := UploadTargetTemp
+	outPath := file.path
+	var tmpPath string
+	if outPath != "" {
+		target = UploadTargetWorkdir
+		if tmpPath, err = writeTempFile(filepath.Dir(outPath), file.data); err != nil {
+			return stagedUpload{}, err
+		}
+	} else {
+		uploadDir := filepath.Join(u.dir, id)
+		if err := os.MkdirAll(uploadDir, 0755); err != nil {
+			return stagedUpload{}, xerrors.Errorf("failed to create upload directory: %w", err)
+		}
+		outPath = filepath.Join(uploadDir, file.name)
+		if err := os.WriteFile(outPath, file.data, 0644); err != nil {
+			_ = os.RemoveAll(uploadDir)
+			return stagedUpload{}, xerrors.Errorf("failed to write file: %w", err)
+		}
+	}
+
+	hash := sha256.Sum256(file.data)
+	return stagedUpload{
+		info: UploadInfo{
+			Id:          id,
+			Name:        file.name,
+			Path:        outPath,
+			Target:      target,
+			Size:        int64(len(file.data)),
+			ContentType: file.contentType,
+			Sha256:      hex.EncodeToString(hash[:]),
+			UploadedAt:  time.Now(),
+		},
+		tmpPath: tmpPath,
+	}, nil
+}
+
+// writeTempFile writes data to a new hidden file in dir and returns its path.
+// The file is created exclusively, so it can't be a symlink planted in dir.
+func writeTempFile(dir string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, ".agentapi-upload-*")
+	if err != nil {
+		return "", xerrors.Errorf("failed to write file: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", xerrors.Errorf("failed to write file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func (u *uploadStore) get(id string) (UploadInfo, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	info, ok := u.uploads[id]
+	return info, ok
+}
+
+// list returns the uploaded files, oldest first, and their total size.
+func (u *uploadStore) list() ([]UploadInfo, int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	infos := make([]UploadInfo, 0, len(u.uploads))
+	for _, info := range u.uploads {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UploadedAt.Before(infos[j].UploadedAt)
+	})
+	return infos, u.totalSize
+}
+
+// remove deletes an uploaded file. It returns false if there's no such upload.
+func (u *uploadStore) remove(id string) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.removeLocked(id)
+}
+
+func (u *uploadStore) removeLocked(id string) (bool, error) {
+	info, ok := u.uploads[id]
+	if !ok {
+		return false, nil
+	}
+	if info.Target == UploadTargetTemp {
+		// The upload's directory in the temporary directory belongs to the server.
+		if err := os.RemoveAll(filepath.Dir(info.Path)); err != nil {
+			return true, xerrors.Errorf("failed to remove upload: %w", err)
+		}
+	} else if err := removeWorkdirFile(info.Path); err != nil {
+		return true, err
+	}
+	delete(u.uploads, id)
+	u.totalSize -= info.Size
+	return true, nil
+}
+
+// removeWorkdirFile removes a file uploaded into the working directory. The
+// agent may have replaced it since, so only regular files are removed, and
+// never the directory they were uploaded to, which may contain other files.
+func removeWorkdirFile(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to remove upload: %w", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return xerrors.Errorf("failed to remove upload: %w", err)
+	}
+	return nil
+}
+
+// readUploadedFile reads an uploaded file. The agent may have replaced a file
+// uploaded into the working directory since, e.g. with a symlink to a file
+// outside of it, so only regular files are read.
+func readUploadedFile(path string) ([]byte, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read upload: %w", err)
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, huma.Error409Conflict(fmt.Sprintf("%s is no longer a regular file", filepath.Base(path)))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read upload: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	// The file may have been replaced between the check and opening it.
+	opened, err := f.Stat()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read upload: %w", err)
+	}
+	if !os.SameFile(fi, opened) {
+		return nil, huma.Error409Conflict(fmt.Sprintf("%s is no longer a regular file", filepath.Base(path)))
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read upload: %w", err)
+	}
+	return data, nil
+}
+
+// workdirDestination is the directory in the working directory that files
+// are uploaded to.
+type workdirDestination struct {
+	// existing is the deepest directory of the destination that exists.
+	existing string
+	// missing are the directories below existing that are created for the upload.
+	missing []string
+	// created are the directories that were created, in order.
+	created []string
+}
+
+func (d *workdirDestination) path() string {
+	return filepath.Join(append([]string{d.existing}, d.missing...)...)
+}
+
+// create creates the missing directories of the destination.
+func (d *workdirDestination) create() error {
+	dir := d.existing
+	for _, part := range d.missing {
+		dir = filepath.Join(dir, part)
+		if err := os.Mkdir(dir, 0755); err == nil {
+			d.created = append(d.created, dir)
+			continue
+		} else if !os.IsExist(err) {
+			d.removeCreated()
+			return xerrors.Errorf("failed to create directory: %w", err)
+		}
+		// The directory was created in the meantime. It must not be a symlink
+		// that leaves the working directory.
+		if fi, err := os.Lstat(dir); err != nil || !fi.IsDir() {
+			d.removeCreated()
+			return huma.Error409Conflict(fmt.Sprintf("%s is not a directory", part))
+		}
+	}
+	return nil
+}
+
+// removeCreated removes the directories that create created, if they're still empty.
+func (d *workdirDestination) removeCreated() {
+	for i := len(d.created) - 1; i >= 0; i-- {
+		_ = os.Remove(d.created[i])
+	}
+	d.created = nil
+}
+
+// prepareWorkdirUpload resolves the paths the files are written to in the
+// working directory, without creating anything. It fails if the destination
+// is outside of the working directory, or if a file already exists and may
+// not be overwritten.
+func (u *uploadStore) prepareWorkdirUpload(files []pendingUpload, opts uploadOptions) (*workdirDestination, error) {
+	if u.workDir == "" {
+		return nil, huma.Error400BadRequest("uploading to the working directory is not enabled")
+	}
+	existing, missing, err := resolveInRoot(u.workDir, opts.path)
+	if err != nil {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("invalid path %q: %s", opts.path, err))
+	}
+	dest := &workdirDestination{existing: existing, missing: missing}
+	dir := dest.path()
+	seen := map[string]bool{}
+	for i := range files {
+		path := filepath.Join(dir, files[i].name)
+		if seen[path] {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("file %q is uploaded more than once", files[i].name))
+		}
+		seen[path] = true
+		files[i].path = path
+		if len(missing) > 0 {
+			// Nothing exists in a directory that doesn't exist yet.
+			continue
+		}
+		fi, err := os.Lstat(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, xerrors.Errorf("failed to check destination: %w", err)
+		case fi.Mode()&os.ModeSymlink != 0:
+			// Writing through a symlink could escape the working directory.
+			return nil, huma.Error400BadRequest(fmt.Sprintf("%q is a symlink", filepath.Join(opts.path, files[i].name)))
+		case fi.IsDir():
+			return nil, huma.Error409Conflict(fmt.Sprintf("%q is a directory", filepath.Join(opts.path, files[i].name)))
+		case !opts.overwrite:
+			return nil, huma.Error409Conflict(fmt.Sprintf("%q already exists, set overwrite to replace it", filepath.Join(opts.path, files[i].name)))
+		}
+	}
+	return dest, nil
+}
+
+// resolveInRoot resolves the directory at the relative path rel inside root.
+// It returns the deepest directory of the path that exists, and the names of
+// the directories below it that are missing. root must not contain symlinks.
+// Paths that leave root, either with ".." or through a symlink, are rejected.
+func resolveInRoot(root string, rel string) (string, []string, error) {
+	if filepath.IsAbs(rel) {
+		return "", nil, xerrors.New("the path must be relative")
+	}
+	rel = filepath.Clean(rel)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, xerrors.New("the path must not leave the working directory")
+	}
+	dir := root
+	if rel == "." {
+		return dir, nil, nil
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		next := filepath.Join(dir, part)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			return dir, parts[i:], nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(next)
+			if err != nil {
+				return "", nil, err
+			}
+			if !isInside(root, resolved) {
+				return "", nil, xerrors.New("the path must not leave the working directory")
+			}
+			next = resolved
+			if fi, err = os.Stat(next); err != nil {
+				return "", nil, err
+			}
+		}
+		if !fi.IsDir() {
+			return "", nil, xerrors.Errorf("%s is not a directory", part)
+		}
+		dir = next
+	}
+	return dir, nil, nil
+}
+
+// isInside reports whether path is root or inside of it.
+func isInside(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// formatSize formats a size in bytes for error messages, e.g. "10MB".
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", size>>20)
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", size>>10)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
+
+// limitUploadBody bounds the size of upload requests, which huma's
+// MaxBodyBytes doesn't apply to. The multipart form is parsed here, so that
+// requests that exceed the limit are rejected before the handler runs, and
+// the temporary files the form is buffered in are removed after it.
+func (s *Server) limitUploadBody(ctx huma.Context, next func(huma.Context)) {
+	r, w := humachi.Unwrap(ctx)
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploads.maxRequestSize())
+	if err := r.ParseMultipartForm(humachi.MultipartMaxMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if xerrors.As(err, &maxBytesErr) {
+			_ = huma.WriteErr(s.api, ctx, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("upload request exceeds %s limit", formatSize(maxBytesErr.Limit)))
+			return
+		}
+		// Other errors are reported by huma, which parses the form again.
+	}
+	if r.MultipartForm != nil {
+		defer func() {
+			_ = r.MultipartForm.RemoveAll()
+		}()
+	}
+	next(ctx)
+}
+
+// uploadFiles handles POST /upload
+func (s *Server) uploadFiles(ctx context.Context, input *struct {
+	RawBody huma.MultipartFormFiles[UploadRequest]
+}) (*UploadResponse, error) {
+	formData := input.RawBody.Data()
+	if len(formData.Files) > maxUploadFiles {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("at most %d files can be uploaded at once", maxUploadFiles))
+	}
+
+	available, limited := s.uploads.available()
+	var size int64
+	files := make([]pendingUpload, 0, len(formData.Files))
+	for _, formFile := range formData.Files {
+		// Read one byte past the limit to detect files that exceed it.
+		buf, err := io.ReadAll(io.LimitReader(formFile.File, s.uploads.maxFileSize+1))
+		if err != nil {
+			return nil, xerrors.Errorf("failed to upload file: %w", err)
+		}
+		if err := s.uploads.checkFileSize(int64(len(buf))); err != nil {
+			return nil, err
+		}
+		// The quota is checked exactly when the files are saved, but
+		// requests that can't fit are rejected without reading further.
+		size += int64(len(buf))
+		if limited && size > available {
+			return nil, s.uploads.quotaError(size)
+		}
+		// Save the file with its original filename (extract just the base filename for security)
+		name := filepath.Base(formFile.Filename)
+		if name == "." || name == string(filepath.Separator) {
+			name = "uploaded_file"
+		}
+		files = append(files, pendingUpload{
+			name:        name,
+			contentType: formFile.ContentType,
+			data:        buf,
+		})
+	}
+
+	target := formData.Target
+	if target == "" {
+		target = UploadTargetTemp
+	}
+	if target != UploadTargetWorkdir && (formData.Path != "" || formData.Overwrite) {
+		return nil, huma.Error400BadRequest("path and overwrite can only be used with the 'workdir' target")
+	}
+	infos, err := s.uploads.save(files, uploadOptions{
+		target:    target,
+		path:      formData.Path,
+		overwrite: formData.Overwrite,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		s.metrics.uploadBytes.Add(float64(info.Size))
+	}
+
+	resp := &UploadResponse{}
+	resp.Body.Ok = true
+	resp.Body.Files = infos
+	if len(infos) > 0 {
+		resp.Body.FilePath = infos[0].Path
+	}
+	return resp, nil
+}
+
+// listUploads handles GET /uploads
+func (s *Server) listUploads(ctx context.Context, input *struct{}) (*UploadsResponse, error) {
+	resp := &UploadsResponse{}
+	resp.Body.Uploads, resp.Body.TotalSize = s.uploads.list()
+	resp.Body.Quota = s.uploads.quota
+	return resp, nil
+}
+
+// downloadUpload handles GET /uploads/{id}
+func (s *Server) downloadUpload(ctx context.Context, input *UploadRequestPath) (*DownloadUploadResponse, error) {
+	info, ok := s.uploads.get(input.Id)
+	if !ok {
+		return nil, huma.Error404NotFound(fmt.Sprintf("upload %q not found", input.Id))
+	}
+	data, err := readUploadedFile(info.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &DownloadUploadResponse{Body: data}
+	resp.ContentType = info.ContentType
+	if resp.ContentType == "" {
+		resp.ContentType = "application/octet-stream"
+	}
+	resp.ContentDisposition = mime.FormatMediaType("attachment", map[string]string{"filename": info.Name})
+	return resp, nil
+}
+
+// deleteUpload handles DELETE /uploads/{id}
+func (s *Server) deleteUpload(ctx context.Context, input *UploadRequestPath) (*DeleteUploadResponse, error) {
+	ok, err := s.uploads.remove(input.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, huma.Error404NotFound(fmt.Sprintf("upload %q not found", input.Id))
+	}
+
+	resp := &DeleteUploadResponse{}
+	resp.Body.Ok = true
+	return resp, nil
+}
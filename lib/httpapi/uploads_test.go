@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadStore_Workdir(t *testing.T) {
+	newStore := func(t *testing.T, quota int64) (*uploadStore, string) {
+		t.Helper()
+		workDir, err := filepath.EvalSymlinks(t.TempDir())
+		require.NoError(t, err)
+		return newUploadStore(t.TempDir(), workDir, 0, quota), workDir
+	}
+	readFile := func(t *testing.T, path string) string {
+		t.Helper()
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	t.Run("rejected uploads don't create directories", func(t *testing.T) {
+		u, workDir := newStore(t, 4)
+		_, err := u.save([]pendingUpload{{name: "big.txt", data: []byte("too big")}}, uploadOptions{target: UploadTargetWorkdir, path: "new/dir"})
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, statusErr.GetStatus())
+
+		_, err = u.save([]pendingUpload{{name: "a.txt"}, {name: "a.txt"}}, uploadOptions{target: UploadTargetWorkdir, path: "new/dir"})
+		require.Error(t, err)
+
+		_, err = os.Stat(filepath.Join(workDir, "new"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("nested directories are created", func(t *testing.T) {
+		u, workDir := newStore(t, 0)
+		infos, err := u.save([]pendingUpload{{name: "a.txt", data: []byte("a")}}, uploadOptions{target: UploadTargetWorkdir, path: "new/dir"})
+		require.NoError(t, err)
+		require.Len(t, infos, 1)
+		assert.Equal(t, filepath.Join(workDir, "new", "dir", "a.txt"), infos[0].Path)
+		assert.Equal(t, "a", readFile(t, infos[0].Path))
+	})
+
+	t.Run("originals are only replaced on commit", func(t *testing.T) {
+		u, workDir := newStore(t, 0)
+		path := filepath.Join(workDir, "a.txt")
+		require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+
+		staged, err := u.stage(pendingUpload{name: "a.txt", data: []byte("new"), path: path})
+		require.NoError(t, err)
+		assert.Equal(t, "old", readFile(t, path))
+		// A failed upload discards the staged file and keeps the original.
+		staged.discard()
+		assert.Equal(t, "old", readFile(t, path))
+		entries, err := os.ReadDir(workDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		staged, err = u.stage(pendingUpload{name: "a.txt", data: []byte("new"), path: path})
+		require.NoError(t, err)
+		require.NoError(t, staged.commit(true))
+		assert.Equal(t, "new", readFile(t, path))
+	})
+
+	t.Run("commit doesn't replace files created in the meantime", func(t *testing.T) {
+		u, workDir := newStore(t, 0)
+		path := filepath.Join(workDir, "a.txt")
+		staged, err := u.stage(pendingUpload{name: "a.txt", data: []byte("new"), path: path})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte("agent"), 0o644))
+
+		err = staged.commit(false)
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusConflict, statusErr.GetStatus())
+		staged.discard()
+		assert.Equal(t, "agent", readFile(t, path))
+	})
+
+	t.Run("overwritten files are restored if a commit fails", func(t *testing.T) {
+		u, workDir := newStore(t, 0)
+		pathA := filepath.Join(workDir, "a.txt")
+		pathB := filepath.Join(workDir, "b.txt")
+		require.NoError(t, os.WriteFile(pathA, []byte("old"), 0o644))
+		stage := func() []stagedUpload {
+			var staged []stagedUpload
+			for _, path := range []string{pathA, pathB} {
+				s, err := u.stage(pendingUpload{name: filepath.Base(path), data: []byte("new"), path: path})
+				require.NoError(t, err)
+				staged = append(staged, s)
+			}
+			return staged
+		}
+		names := func() []string {
+			entries, err := os.ReadDir(workDir)
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			return names
+		}
+
+		// b.txt can't be replaced once a directory took its place.
+		staged := stage()
+		require.NoError(t, os.MkdirAll(filepath.Join(pathB, "dir"), 0o755))
+		require.Error(t, commitAll(staged, true))
+		for i := range staged {
+			staged[i].discard()
+		}
+		assert.Equal(t, "old", readFile(t, pathA))
+		assert.Equal(t, []string{"a.txt", "b.txt"}, names())
+
+		require.NoError(t, os.RemoveAll(pathB))
+		staged = stage()
+		require.NoError(t, commitAll(staged, true))
+		assert.Equal(t, "new", readFile(t, pathA))
+		assert.Equal(t, "new", readFile(t, pathB))
+		assert.Equal(t, []string{"a.txt", "b.txt"}, names())
+	})
+}
@@ -0,0 +1,236 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/xerrors"
+)
+
+// WebSocketInputType is the type of a frame sent by the client over the WebSocket connection.
+type WebSocketInputType string
+
+const (
+	// WebSocketInputTypeRaw frames are written directly to the agent's terminal
+	// as keystrokes, like messages of type 'raw' sent to POST /message.
+	WebSocketInputTypeRaw WebSocketInputType = "raw"
+)
+
+// WebSocketInput is a frame sent by the client over the WebSocket connection.
+type WebSocketInput struct {
+	Type    WebSocketInputType `json:"type"`
+	Content string             `json:"content"`
+}
+
+// EventTypeError is only sent over the WebSocket connection, in response to invalid input.
+const EventTypeError EventType = "error"
+
+// WebSocketEvent is a frame sent by the server over the WebSocket connection.
+// Data has the same shape as the payload of the SSE event of the same type.
+type WebSocketEvent struct {
+	Type EventType `json:"type"`
+	// Id is the sequence number of the event. It can be passed as the
+	// last_event_id query parameter when reconnecting.
+	Id   int `json:"id,omitempty"`
+	Data any `json:"data"`
+}
+
+type WebSocketErrorBody struct {
+	Message string `json:"message"`
+}
+
+var webSocketEventTypes = []EventType{
+	EventTypeMessageUpdate,
+	EventTypeStatusChange,
+	EventTypeScreenUpdate,
+	EventTypePendingAction,
+}
+
+// parseWebSocketEventTypes parses the comma-separated list of event types the client subscribes to.
+// All event types are sent if the list is empty.
+func parseWebSocketEventTypes(input string) (map[EventType]bool, error) {
+	types := make(map[EventType]bool)
+	if input == "" {
+		for _, eventType := range webSocketEventTypes {
+			types[eventType] = true
+		}
+		return types, nil
+	}
+	for _, item := range strings.Split(input, ",") {
+		eventType := EventType(strings.TrimSpace(item))
+		if !slices.Contains(webSocketEventTypes, eventType) {
+			return nil, fmt.Errorf("unknown event type '%s'", eventType)
+		}
+		types[eventType] = true
+	}
+	return types, nil
+}
+
+// webSocketOriginOptions allows the same cross-origin clients as the CORS configuration.
+func webSocketOriginOptions(allowedOrigins []string) *websocket.AcceptOptions {
+	if slices.Contains(allowedOrigins, "*") {
+		return &websocket.AcceptOptions{InsecureSkipVerify: true}
+	}
+	return &websocket.AcceptOptions{OriginPatterns: allowedOrigins}
+}
+
+// handleWebSocket handles GET /ws. It streams the same events as /events and
+// /internal/screen, and accepts raw keystrokes, over a single connection, which
+// preserves the order of the input.
+//
+// Query parameters:
+//   - types: comma-separated list of event types to receive. Defaults to all.
+//   - last_event_id: resume a previous connection, like the Last-Event-ID header of /events.
+//   - screen_mode: 'full' (default) or 'diff', like the mode parameter of /internal/screen.
+//   - screen_format: 'text' (default) or 'ansi', like the format parameter of /internal/screen.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "sessionId")
+	if id == "" {
+		id = DefaultSessionID
+	}
+	sess, ok := s.lookupSession(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("session %q not found", id), http.StatusNotFound)
+		return
+	}
+	eventTypes, err := parseWebSocketEventTypes(r.URL.Query().Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	screenMode := ScreenMode(r.URL.Query().Get("screen_mode"))
+	if screenMode == "" {
+		screenMode = ScreenModeFull
+	}
+	if !slices.Contains(ScreenModeValues, screenMode) {
+		http.Error(w, fmt.Sprintf("unknown screen mode '%s'", screenMode), http.StatusBadRequest)
+		return
+	}
+	screenFormat := ScreenFormat(r.URL.Query().Get("screen_format"))
+	if screenFormat == "" {
+		screenFormat = ScreenFormatText
+	}
+	if screenFormat != ScreenFormatText && screenFormat != ScreenFormatANSI {
+		http.Error(w, fmt.Sprintf("unknown screen format '%s'", screenFormat), http.StatusBadRequest)
+		return
+	}
+	lastEventId := 0
+	if value := r.URL.Query().Get("last_event_id"); value != "" {
+		lastEventId, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "last_event_id must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	conn, err := websocket.Accept(w, r, webSocketOriginOptions(s.allowedOrigins))
+	if err != nil {
+		sess.logger.Error("Failed to accept WebSocket connection", "error", err)
+		return
+	}
+	defer func() {
+		_ = conn.CloseNow()
+	}()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	subscriberId, ch, stateEvents := sess.emitter.SubscribeSince(lastEventId)
+	defer sess.emitter.Unsubscribe(subscriberId)
+	sess.logger.Info("New WebSocket subscriber", "subscriberId", subscriberId, "lastEventId", lastEventId)
+
+	go func() {
+		// Stop streaming events once the client disconnects.
+		defer cancel()
+		if err := s.readWebSocketInput(ctx, sess, conn); err != nil {
+			sess.logger.Info("WebSocket input closed", "subscriberId", subscriberId, "error", err)
+		}
+	}()
+
+	differ := &screenDiffer{}
+	send := func(event Event) error {
+		eventType, payload := event.Type, event.Payload
+		if isScreenEvent(event.Type) {
+			if event.Type != screenFormat.eventType() {
+				return nil
+			}
+			// Styled screens are sent as regular screen updates.
+			eventType = EventTypeScreenUpdate
+		}
+		if !eventTypes[eventType] {
+			return nil
+		}
+		if eventType == EventTypeScreenUpdate && screenMode == ScreenModeDiff {
+			eventType, payload = differ.next(payload.(ScreenUpdateBody))
+		}
+		return wsjson.Write(ctx, conn, WebSocketEvent{Type: eventType, Id: event.Id, Data: payload})
+	}
+	for _, event := range stateEvents {
+		if err := send(event); err != nil {
+			sess.logger.Error("Failed to send WebSocket event", "subscriberId", subscriberId, "error", err)
+			return
+		}
+	}
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				sess.logger.Info("WebSocket channel closed", "subscriberId", subscriberId)
+				_ = conn.Close(websocket.StatusTryAgainLater, "event buffer overflow")
+				return
+			}
+			if err := send(event); err != nil {
+				sess.logger.Error("Failed to send WebSocket event", "subscriberId", subscriberId, "error", err)
+				return
+			}
+		case <-ctx.Done():
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+	}
+}
+
+// readWebSocketInput writes raw input frames to the agent's terminal in the order they're received.
+func (s *Server) readWebSocketInput(ctx context.Context, sess *session, conn *websocket.Conn) error {
+	for {
+		var input WebSocketInput
+		if err := wsjson.Read(ctx, conn, &input); err != nil {
+			var closeErr websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.StatusNormalClosure {
+				return nil
+			}
+			return xerrors.Errorf("failed to read input: %w", err)
+		}
+		switch input.Type {
+		case WebSocketInputTypeRaw:
+			if err := s.writeRawInput(sess, input.Content); err != nil {
+				return xerrors.Errorf("failed to write input: %w", err)
+			}
+		default:
+			if err := wsjson.Write(ctx, conn, WebSocketEvent{
+				Type: EventTypeError,
+				Data: WebSocketErrorBody{Message: fmt.Sprintf("unknown input type '%s'", input.Type)},
+			}); err != nil {
+				return xerrors.Errorf("failed to send error: %w", err)
+			}
+		}
+	}
+}
+
+func (s *Server) writeRawInput(sess *session, content string) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.agentio == nil {
+		return xerrors.New("the session has no agent process")
+	}
+	_, err := sess.agentio.Write([]byte(content))
+	return err
+}
@@ -0,0 +1,350 @@
+package msgfmt
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// ActionDecision is an answer to a permission prompt.
+type ActionDecision string
+
+const (
+	// ActionDecisionApprove lets the agent carry out the action once.
+	ActionDecisionApprove ActionDecision = "approve"
+	// ActionDecisionDeny stops the agent from carrying out the action.
+	ActionDecisionDeny ActionDecision = "deny"
+	// ActionDecisionAlways lets the agent carry out the action and similar
+	// actions without asking again.
+	ActionDecisionAlways ActionDecision = "always"
+)
+
+var ActionDecisionValues = []ActionDecision{
+	ActionDecisionApprove,
+	ActionDecisionDeny,
+	ActionDecisionAlways,
+}
+
+func (d ActionDecision) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ActionDecision", ActionDecisionValues)
+}
+
+// PermissionPrompt is a question the agent asks before carrying out an
+// action, e.g. running a command or editing a file.
+type PermissionPrompt struct {
+	// Question is the question asked by the agent, e.g. "Do you want to proceed?".
+	Question string
+	// Details describe the action, e.g. the command the agent wants to run.
+	Details string
+	// Options are the answers the agent accepts, in the order they're shown.
+	Options []PermissionOption
+}
+
+type PermissionOption struct {
+	Decision ActionDecision
+	// Label is the text of the option, as shown by the agent.
+	Label string
+	// Keys are the keystrokes that select the option.
+	Keys string
+}
+
+// Option returns the option for the given decision, or false if the agent
+// doesn't offer it.
+func (p *PermissionPrompt) Option(decision ActionDecision) (PermissionOption, bool) {
+	for _, option := range p.Options {
+		if option.Decision == decision {
+			return option, true
+		}
+	}
+	return PermissionOption{}, false
+}
+
+// permissionPromptLines is how many lines at the bottom of the screen are searched
+// for a permission prompt. Agents show prompts right above their input area,
+// so older prompts that scrolled up are ignored.
+const permissionPromptLines = 40
+
+// DetectPermissionPrompt returns the permission prompt shown at the bottom of the
+// screen, or nil if the agent isn't waiting for permission.
+func DetectPermissionPrompt(agentType AgentType, screen string) *PermissionPrompt {
+	lines := strings.Split(screen, "\n")
+	if len(lines) > permissionPromptLines {
+		lines = lines[len(lines)-permissionPromptLines:]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], WhiteSpaceChars)
+	}
+
+	var detectors []func(lines []string) *PermissionPrompt
+	switch baseAgentType(agentType) {
+	case AgentTypeAider:
+		detectors = append(detectors, detectAiderPrompt)
+	case AgentTypeCursor:
+		detectors = append(detectors, detectCursorPrompt)
+	case AgentTypeAmazonQ:
+		detectors = append(detectors, detectYesNoPrompt)
+	default:
+		// Claude Code, Codex, Gemini, Copilot, and most other agents show a
+		// numbered menu under the question.
+		detectors = append(detectors, detectMenuPrompt, detectYesNoPrompt)
+	}
+	for _, detect := range detectors {
+		if prompt := detect(lines); prompt != nil {
+			return prompt
+		}
+	}
+	return nil
+}
+
+// boxContent strips the borders of a TUI box from a line.
+// The second return value is true if the line was inside a box.
+func boxContent(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	inner, ok := strings.CutPrefix(trimmed, "│")
+	if !ok {
+		return trimmed, false
+	}
+	inner = strings.TrimSuffix(inner, "│")
+	return strings.TrimSpace(inner), true
+}
+
+func isBoxBorder(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "╭") || strings.HasPrefix(trimmed, "┌") ||
+		strings.HasPrefix(trimmed, "╰") || strings.HasPrefix(trimmed, "└")
+}
+
+// promptDetails returns the lines above the question that describe the action.
+// If the question is inside a box, these are the lines of the box above it.
+// Otherwise, it's the paragraph above it.
+func promptDetails(lines []string, questionIdx int) string {
+	_, boxed := boxContent(lines[questionIdx])
+	start := questionIdx
+	for start > 0 {
+		line := lines[start-1]
+		content, inBox := boxContent(line)
+		if boxed && (!inBox || isBoxBorder(line)) {
+			break
+		}
+		if !boxed && content == "" && start < questionIdx {
+			break
+		}
+		start--
+	}
+	details := make([]string, 0, questionIdx-start)
+	for _, line := range lines[start:questionIdx] {
+		content, _ := boxContent(line)
+		// Some agents show the command in a nested box.
+		if isBoxBorder(content) {
+			continue
+		}
+		content, _ = boxContent(content)
+		details = append(details, content)
+	}
+	return trimEmptyLines(strings.Join(details, "\n"))
+}
+
+// e.g. "❯ 1. Yes" or "  2. No, and tell Claude what to do differently (esc)"
+var menuOptionRe = regexp.MustCompile(`^(?:[❯>›▶→●○]\s*)?(\d)\.\s+(.+)$`)
+
+// classifyOption guesses the decision an option of a menu stands for from its label.
+// It returns false if the option is none of them, e.g. "Edit the command".
+func classifyOption(label string) (ActionDecision, bool) {
+	lower := strings.ToLower(label)
+	switch {
+	case hasAnyPrefix(lower, "no", "reject", "deny", "cancel", "don't"):
+		return ActionDecisionDeny, true
+	case strings.Contains(lower, "always") || strings.Contains(lower, "don't ask again") ||
+		strings.Contains(lower, "for the rest of") || strings.Contains(lower, "for this session"):
+		return ActionDecisionAlways, true
+	case hasAnyPrefix(lower, "yes", "allow", "approve", "run", "proceed"):
+		return ActionDecisionApprove, true
+	default:
+		return "", false
+	}
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectMenuPrompt detects a question followed by a numbered list of answers:
+//
+//	Do you want to proceed?
+//	❯ 1. Yes
+//	  2. Yes, and don't ask again for git status commands
+//	  3. No, and tell Claude what to do differently (esc)
+//
+// Typing the number of an answer selects it.
+func detectMenuPrompt(lines []string) *PermissionPrompt {
+	for i := len(lines) - 1; i >= 0; i-- {
+		question, _ := boxContent(lines[i])
+		if !strings.HasSuffix(question, "?") {
+			continue
+		}
+		var options []PermissionOption
+		seen := make(map[ActionDecision]bool)
+		blankLines := 0
+		end := i + 1
+		for ; end < len(lines); end++ {
+			content, _ := boxContent(lines[end])
+			match := menuOptionRe.FindStringSubmatch(content)
+			if match == nil {
+				// The options may be separated from the question by a blank line.
+				if content == "" && len(options) == 0 && blankLines < 2 {
+					blankLines++
+					continue
+				}
+				break
+			}
+			decision, ok := classifyOption(match[2])
+			if !ok || seen[decision] {
+				continue
+			}
+			seen[decision] = true
+			options = append(options, PermissionOption{Decision: decision, Label: match[2], Keys: match[1]})
+		}
+		if !seen[ActionDecisionApprove] {
+			continue
+		}
+		if !isBottomOfScreen(lines[end:]) {
+			// The prompt was already answered and the agent went on.
+			return nil
+		}
+		return &PermissionPrompt{
+			Question: question,
+			Details:  promptDetails(lines, i),
+			Options:  options,
+		}
+	}
+	return nil
+}
+
+// maxLinesBelowPrompt is how many lines may follow a prompt, e.g. hints on how
+// to answer it or a spinner.
+const maxLinesBelowPrompt = 3
+
+// isBottomOfScreen returns true if lines only contain the rest of a prompt,
+// and not output the agent printed after the prompt was answered.
+func isBottomOfScreen(lines []string) bool {
+	count := 0
+	for _, line := range lines {
+		content, _ := boxContent(line)
+		if content != "" && !isBoxBorder(content) {
+			count++
+		}
+	}
+	return count <= maxLinesBelowPrompt
+}
+
+// e.g. "Allow this action? Use 't' to trust (always allow) this tool for the session. [y/n/t]:"
+var yesNoPromptRe = regexp.MustCompile(`(?i)^(.*?)\s*[\[(]y/n(?:/([ta]))?[\])]:?$`)
+
+// detectYesNoPrompt detects a question that is answered by typing a letter.
+// The question must be on the last non-empty lines of the screen, followed
+// at most by the agent's input prompt.
+func detectYesNoPrompt(lines []string) *PermissionPrompt {
+	for i := len(lines) - 1; i >= 0; i-- {
+		content, _ := boxContent(lines[i])
+		if content == "" || content == ">" {
+			continue
+		}
+		match := yesNoPromptRe.FindStringSubmatch(content)
+		if match == nil {
+			return nil
+		}
+		options := []PermissionOption{
+			{Decision: ActionDecisionApprove, Label: "y", Keys: "y\r"},
+			{Decision: ActionDecisionDeny, Label: "n", Keys: "n\r"},
+		}
+		if letter := strings.ToLower(match[2]); letter != "" {
+			options = append(options, PermissionOption{Decision: ActionDecisionAlways, Label: letter, Keys: letter + "\r"})
+		}
+		return &PermissionPrompt{
+			Question: match[1],
+			Details:  promptDetails(lines, i),
+			Options:  options,
+		}
+	}
+	return nil
+}
+
+// e.g. "Run shell command? (Y)es/(N)o/(D)on't ask again [Yes]:"
+var aiderPromptRe = regexp.MustCompile(`^(.*\?) \(Y\)es/\(N\)o(/.*)? \[(Yes|No)\]:`)
+
+// detectAiderPrompt detects Aider's questions, which are always on the last line.
+func detectAiderPrompt(lines []string) *PermissionPrompt {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i] == "" {
+			continue
+		}
+		match := aiderPromptRe.FindStringSubmatch(lines[i])
+		if match == nil {
+			return nil
+		}
+		options := []PermissionOption{
+			{Decision: ActionDecisionApprove, Label: "Yes", Keys: "y\r"},
+			{Decision: ActionDecisionDeny, Label: "No", Keys: "n\r"},
+		}
+		if strings.Contains(match[2], "(A)ll") {
+			options = append(options, PermissionOption{Decision: ActionDecisionAlways, Label: "All", Keys: "a\r"})
+		}
+		return &PermissionPrompt{
+			Question: match[1],
+			Details:  promptDetails(lines, i),
+			Options:  options,
+		}
+	}
+	return nil
+}
+
+// detectCursorPrompt detects the approval box of Cursor CLI:
+//
+//	Run this command?
+//	Not in allowlist: git
+//	 → Run (y) (enter)
+//	   Reject (esc or p)
+//	   Add Shell(git) to allowlist? (tab)
+func detectCursorPrompt(lines []string) *PermissionPrompt {
+	for i := len(lines) - 1; i >= 0; i-- {
+		question, _ := boxContent(lines[i])
+		if !strings.HasSuffix(question, "?") {
+			continue
+		}
+		var options []PermissionOption
+		var details []string
+		for _, line := range lines[i+1:] {
+			content, boxed := boxContent(line)
+			if !boxed {
+				break
+			}
+			content = strings.TrimSpace(strings.TrimPrefix(content, "→"))
+			switch {
+			case strings.HasSuffix(content, "(y) (enter)"):
+				options = append(options, PermissionOption{Decision: ActionDecisionApprove, Label: content, Keys: "y"})
+			case strings.HasPrefix(content, "Reject ("):
+				options = append(options, PermissionOption{Decision: ActionDecisionDeny, Label: content, Keys: "\x1b"})
+			case strings.HasSuffix(content, "to allowlist? (tab)"):
+				options = append(options, PermissionOption{Decision: ActionDecisionAlways, Label: content, Keys: "\t"})
+			case len(options) == 0:
+				details = append(details, content)
+			}
+		}
+		if len(options) == 0 {
+			continue
+		}
+		return &PermissionPrompt{
+			Question: question,
+			Details:  trimEmptyLines(strings.Join(details, "\n")),
+			Options:  options,
+		}
+	}
+	return nil
+}
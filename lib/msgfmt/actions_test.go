@@ -0,0 +1,58 @@
+package msgfmt
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPermissionPrompt(t *testing.T) {
+	dir := "testdata/actions"
+	agentDirs, err := testdataDir.ReadDir(dir)
+	require.NoError(t, err)
+	for _, agentDir := range agentDirs {
+		agentType := AgentType(agentDir.Name())
+		t.Run(string(agentType), func(t *testing.T) {
+			cases, err := testdataDir.ReadDir(path.Join(dir, string(agentType)))
+			require.NoError(t, err)
+			for _, c := range cases {
+				t.Run(c.Name(), func(t *testing.T) {
+					screen, err := testdataDir.ReadFile(path.Join(dir, string(agentType), c.Name(), "screen.txt"))
+					require.NoError(t, err)
+					expectedJSON, err := testdataDir.ReadFile(path.Join(dir, string(agentType), c.Name(), "expected.json"))
+					require.NoError(t, err)
+					// expected.json contains null if there's no prompt on the screen.
+					var expected *PermissionPrompt
+					require.NoError(t, json.Unmarshal(expectedJSON, &expected))
+					assert.Equal(t, expected, DetectPermissionPrompt(agentType, string(screen)))
+				})
+			}
+		})
+	}
+
+	t.Run("scrolled-up", func(t *testing.T) {
+		screen := "Do you want to proceed?\n❯ 1. Yes\n  2. No\n"
+		for range permissionPromptLines {
+			screen += "output\n"
+		}
+		assert.Nil(t, DetectPermissionPrompt(AgentTypeClaude, screen))
+	})
+
+	t.Run("answered", func(t *testing.T) {
+		screen := "Do you want to proceed?\n❯ 1. Yes\n  2. No\n\nRunning tests...\nok  lib/msgfmt\nok  lib/httpapi\nAll tests passed."
+		assert.Nil(t, DetectPermissionPrompt(AgentTypeClaude, screen))
+	})
+
+	t.Run("option", func(t *testing.T) {
+		prompt := DetectPermissionPrompt(AgentTypeClaude, "Do you want to proceed?\n❯ 1. Yes\n  2. No")
+		require.NotNil(t, prompt)
+		option, ok := prompt.Option(ActionDecisionDeny)
+		assert.True(t, ok)
+		assert.Equal(t, "2", option.Keys)
+		_, ok = prompt.Option(ActionDecisionAlways)
+		assert.False(t, ok)
+	})
+}
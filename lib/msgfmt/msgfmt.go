@@ -141,8 +141,8 @@ func findUserInputEndIdx(userInputStartIdx int, msg []rune, userInput []rune) in
 }
 
 // skipTrailingInputBoxLine checks if the next line contains all the given markers
-// and returns the incremented index if found. In case of Gemini and Cursor, the user
-// input is echoed back in a box. This function searches for the markers passed by the
+// and returns the incremented index if found. Some agents, e.g. Gemini and Cursor,
+// echo the user input back in a box. This function searches for the markers passed by the
 // caller and returns (currentIdx+1, true) if the next line contains all of them,
 // otherwise returns (currentIdx, false).
 func skipTrailingInputBoxLine(lines []string, currentIdx int, markers ...string) (idx int, found bool) {
@@ -187,22 +187,17 @@ func RemoveUserInput(msgRaw string, userInputRaw string, agentType AgentType) st
 	// that doesn't contain the echoed user input.
 	lastUserInputLineIdx := msgRuneLineLocations[userInputEndIdx]
 
-	// Skip Gemini/Cursor trailing input box line
-	if agentType == AgentTypeGemini || agentType == AgentTypeCopilot {
-		if idx, found := skipTrailingInputBoxLine(msgLines, lastUserInputLineIdx, "╯", "╰"); found {
+	// Skip the bottom border of the box the input is echoed in, and the
+	// lines the agent shows after the input.
+	profile := ProfileOf(agentType)
+	for _, markers := range profile.InputBoxMarkers {
+		if idx, found := skipTrailingInputBoxLine(msgLines, lastUserInputLineIdx, markers...); found {
 			lastUserInputLineIdx = idx
+			break
 		}
-	} else if agentType == AgentTypeCursor {
-		if idx, found := skipTrailingInputBoxLine(msgLines, lastUserInputLineIdx, "┘", "└"); found {
-			lastUserInputLineIdx = idx
-		}
-	} else if agentType == AgentTypeOpencode {
-		// skip +2 lines after the input
-		//   ┃  jkmr (08:46 PM)                                                     ┃
-		//   ┃                                                                      ┃
-		if lastUserInputLineIdx+2 < len(msgLines) {
-			lastUserInputLineIdx += 2
-		}
+	}
+	if n := profile.EchoTrailingLines; n > 0 && lastUserInputLineIdx+n < len(msgLines) {
+		lastUserInputLineIdx += n
 	}
 
 	return strings.Join(msgLines[lastUserInputLineIdx+1:], "\n")
@@ -231,6 +226,7 @@ func trimEmptyLines(message string) string {
 
 type AgentType string
 
+// The built-in agent types. Each has a profile in the profiles directory.
 // Remember to add the display name to the agentapi/chat/src/components/chat-provider.tsx
 const (
 	AgentTypeClaude   AgentType = "claude"
@@ -254,47 +250,33 @@ func formatGenericMessage(message string, userInput string, agentType AgentType)
 	return message
 }
 
-func formatCodexMessage(message string, userInput string) string {
-	message = RemoveUserInput(message, userInput, AgentTypeCodex)
+func formatCodexMessage(message string, userInput string, agentType AgentType) string {
+	message = RemoveUserInput(message, userInput, agentType)
 	message = removeCodexInputBox(message)
 	message = trimEmptyLines(message)
 	return message
 }
 
-func formatOpencodeMessage(message string, userInput string) string {
-	message = RemoveUserInput(message, userInput, AgentTypeOpencode)
+func formatOpencodeMessage(message string, userInput string, agentType AgentType) string {
+	message = RemoveUserInput(message, userInput, agentType)
 	message = removeOpencodeMessageBox(message)
 	message = trimEmptyLines(message)
 	return message
 }
 
+// FormatAgentMessage removes the echoed user input and the agent's UI elements
+// from a message. Messages of agent types without a profile are returned as is.
 func FormatAgentMessage(agentType AgentType, message string, userInput string) string {
-	switch agentType {
-	case AgentTypeClaude:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeGoose:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeAider:
-		return formatGenericMessage(message, userInput, agentType)
+	profile, ok := LookupProfile(agentType)
+	if !ok {
+		return message
+	}
+	switch profile.base {
 	case AgentTypeCodex:
-		return formatCodexMessage(message, userInput)
-	case AgentTypeGemini:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeCopilot:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeAmp:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeCursor:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeAuggie:
-		return formatGenericMessage(message, userInput, agentType)
-	case AgentTypeAmazonQ:
-		return formatGenericMessage(message, userInput, agentType)
+		return formatCodexMessage(message, userInput, agentType)
 	case AgentTypeOpencode:
-		return formatOpencodeMessage(message, userInput)
-	case AgentTypeCustom:
-		return formatGenericMessage(message, userInput, agentType)
+		return formatOpencodeMessage(message, userInput, agentType)
 	default:
-		return message
+		return formatGenericMessage(message, userInput, agentType)
 	}
 }
@@ -0,0 +1,319 @@
+package msgfmt
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/coder/agentapi/lib/util"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+type MessagePartType string
+
+const (
+	MessagePartTypeText       MessagePartType = "text"
+	MessagePartTypeCode       MessagePartType = "code"
+	MessagePartTypeToolCall   MessagePartType = "tool_call"
+	MessagePartTypeToolOutput MessagePartType = "tool_output"
+	MessagePartTypeDiff       MessagePartType = "diff"
+)
+
+var MessagePartTypeValues = []MessagePartType{
+	MessagePartTypeText,
+	MessagePartTypeCode,
+	MessagePartTypeToolCall,
+	MessagePartTypeToolOutput,
+	MessagePartTypeDiff,
+}
+
+func (t MessagePartType) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "MessagePartType", MessagePartTypeValues)
+}
+
+// MessagePart is a typed section of a message, e.g. prose or a code block.
+type MessagePart struct {
+	Type    MessagePartType `json:"type"`
+	Content string          `json:"content"`
+	// Language is the language of a code block or diff, if the agent specified it.
+	Language string `json:"language,omitempty"`
+	// Tool is the name of the tool of a tool call.
+	Tool string `json:"tool,omitempty"`
+}
+
+var (
+	codeFenceRe = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)\\s*$")
+	// e.g. "⏺ Search(pattern: "foo")…"
+	claudeToolCallRe = regexp.MustCompile(`^⏺ ([A-Z][\w-]*)\((.*)$`)
+	// e.g. "─── shell | developer ──────────"
+	gooseToolCallRe = regexp.MustCompile(`^─── (\S+) \| \S+ ─+$`)
+)
+
+// ParseMessageParts splits a message formatted by FormatAgentMessage into an
+// ordered list of parts. Lines that aren't recognized as part of a code block,
+// diff, or tool invocation are returned as text. Concatenating the content of
+// the parts doesn't necessarily reproduce the message: markers like code
+// fences and tool bullets are removed, and so are trailing spaces.
+func ParseMessageParts(agentType AgentType, message string) []MessagePart {
+	lines := strings.Split(message, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], WhiteSpaceChars)
+	}
+	p := &partsParser{agentType: agentType, lines: lines}
+	for p.idx < len(p.lines) {
+		if !p.parseCodeBlock() && !p.parseSearchReplaceBlock() && !p.parseUnifiedDiff() && !p.parseAgentSpecific() {
+			p.addText(p.lines[p.idx])
+			p.idx++
+		}
+	}
+	p.flushText()
+	return p.parts
+}
+
+type partsParser struct {
+	agentType AgentType
+	lines     []string
+	idx       int
+	parts     []MessagePart
+	text      []string
+}
+
+func (p *partsParser) addText(line string) {
+	p.text = append(p.text, line)
+}
+
+func (p *partsParser) flushText() {
+	text := trimEmptyLines(strings.Join(p.text, "\n"))
+	p.text = nil
+	if text == "" {
+		return
+	}
+	p.parts = append(p.parts, MessagePart{Type: MessagePartTypeText, Content: text})
+}
+
+func (p *partsParser) add(part MessagePart) {
+	p.flushText()
+	p.parts = append(p.parts, part)
+}
+
+// parseCodeBlock parses a fenced code block, as used in markdown.
+func (p *partsParser) parseCodeBlock() bool {
+	match := codeFenceRe.FindStringSubmatch(p.lines[p.idx])
+	if match == nil || match[2] == "" && !p.hasClosingFence(match[1]) {
+		return false
+	}
+	end := p.idx + 1
+	for end < len(p.lines) && strings.TrimSpace(p.lines[end]) != match[1] {
+		end++
+	}
+	if end == len(p.lines) {
+		// The agent may still be writing the block.
+		return false
+	}
+	// Claude Code indents the blocks in its replies.
+	indent := len(p.lines[p.idx]) - len(strings.TrimLeft(p.lines[p.idx], " "))
+	content := make([]string, 0, end-p.idx-1)
+	for _, line := range p.lines[p.idx+1 : end] {
+		content = append(content, dedent(line, indent))
+	}
+	part := MessagePart{Type: MessagePartTypeCode, Content: strings.Join(content, "\n"), Language: match[2]}
+	switch {
+	case part.Language == "diff" || part.Language == "patch":
+		part.Type = MessagePartTypeDiff
+		part.Language = ""
+	case strings.HasPrefix(part.Content, "<<<<<<< SEARCH"):
+		// Aider wraps its edit blocks in a code block in the language of the edited file.
+		part.Type = MessagePartTypeDiff
+	}
+	p.add(part)
+	p.idx = end + 1
+	return true
+}
+
+func (p *partsParser) hasClosingFence(fence string) bool {
+	for _, line := range p.lines[p.idx+1:] {
+		if strings.TrimSpace(line) == fence {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearchReplaceBlock parses the edit blocks used by Aider.
+func (p *partsParser) parseSearchReplaceBlock() bool {
+	if strings.TrimSpace(p.lines[p.idx]) != "<<<<<<< SEARCH" {
+		return false
+	}
+	for end := p.idx + 1; end < len(p.lines); end++ {
+		if strings.HasPrefix(strings.TrimSpace(p.lines[end]), ">>>>>>> REPLACE") {
+			p.add(MessagePart{Type: MessagePartTypeDiff, Content: strings.Join(p.lines[p.idx:end+1], "\n")})
+			p.idx = end + 1
+			return true
+		}
+	}
+	return false
+}
+
+// parseUnifiedDiff parses a diff in the unified format, starting with its file headers.
+func (p *partsParser) parseUnifiedDiff() bool {
+	if p.idx+2 >= len(p.lines) ||
+		!strings.HasPrefix(p.lines[p.idx], "--- ") ||
+		!strings.HasPrefix(p.lines[p.idx+1], "+++ ") ||
+		!strings.HasPrefix(p.lines[p.idx+2], "@@") {
+		return false
+	}
+	end := p.idx + 3
+	for end < len(p.lines) {
+		line := p.lines[end]
+		if line == "" || !strings.ContainsAny(line[:1], " +-@\\") {
+			break
+		}
+		end++
+	}
+	p.add(MessagePart{Type: MessagePartTypeDiff, Content: strings.Join(p.lines[p.idx:end], "\n")})
+	p.idx = end
+	return true
+}
+
+func (p *partsParser) parseAgentSpecific() bool {
+	switch baseAgentType(p.agentType) {
+	case AgentTypeClaude:
+		return p.parseClaudeToolCall() || p.parseToolOutput() || p.parseClaudeText()
+	case AgentTypeCodex:
+		return p.parseCodexToolCall() || p.parseToolOutput() || p.skipCodexLabel()
+	case AgentTypeGoose:
+		return p.parseGooseToolCall()
+	default:
+		return false
+	}
+}
+
+// parseClaudeToolCall parses lines like "⏺ Bash(git status)…". The arguments
+// may be wrapped over multiple lines.
+func (p *partsParser) parseClaudeToolCall() bool {
+	match := claudeToolCallRe.FindStringSubmatch(p.lines[p.idx])
+	if match == nil {
+		return false
+	}
+	args := []string{match[2]}
+	end := p.idx
+	for !isClosedToolCall(args[len(args)-1]) && end+1 < len(p.lines) && strings.HasPrefix(p.lines[end+1], "  ") {
+		end++
+		args = append(args, strings.TrimSpace(p.lines[end]))
+	}
+	content := strings.Join(args, "\n")
+	content = strings.TrimSuffix(content, "…")
+	content = strings.TrimSuffix(content, ")")
+	p.add(MessagePart{Type: MessagePartTypeToolCall, Tool: match[1], Content: content})
+	p.idx = end + 1
+	return true
+}
+
+func isClosedToolCall(line string) bool {
+	return strings.HasSuffix(line, ")") || strings.HasSuffix(line, ")…")
+}
+
+// parseClaudeText strips the bullet of prose paragraphs, e.g. "⏺ Let me check.".
+// The following lines of the paragraph are indented by two spaces.
+func (p *partsParser) parseClaudeText() bool {
+	line, ok := strings.CutPrefix(p.lines[p.idx], "⏺ ")
+	if !ok {
+		return false
+	}
+	p.addText(line)
+	p.idx++
+	for p.idx < len(p.lines) && strings.HasPrefix(p.lines[p.idx], "  ") && !isToolOutputStart(p.lines[p.idx]) {
+		p.addText(p.lines[p.idx][2:])
+		p.idx++
+	}
+	return true
+}
+
+func isToolOutputStart(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "⎿")
+}
+
+// parseToolOutput parses the output of a tool, which Claude Code and Codex
+// print under the tool call, e.g.
+//
+//	⎿  Found 1 file
+//	   lib/msgfmt/msgfmt.go
+func (p *partsParser) parseToolOutput() bool {
+	line := p.lines[p.idx]
+	if !isToolOutputStart(line) {
+		return false
+	}
+	// The content of the following lines is aligned with the first line.
+	_, rest, _ := strings.Cut(line, "⎿")
+	indent := len([]rune(line)) - len([]rune(rest)) + len(rest) - len(strings.TrimLeft(rest, " "))
+	output := []string{strings.TrimLeft(rest, " ")}
+	end := p.idx + 1
+	for end < len(p.lines) && strings.HasPrefix(p.lines[end], "    ") {
+		output = append(output, dedent(p.lines[end], indent))
+		end++
+	}
+	p.add(MessagePart{Type: MessagePartTypeToolOutput, Content: strings.Join(output, "\n")})
+	p.idx = end
+	return true
+}
+
+// dedent removes up to n leading spaces from line.
+func dedent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
+}
+
+// parseCodexToolCall parses lines like "⚡ Ran command git status".
+func (p *partsParser) parseCodexToolCall() bool {
+	command, ok := strings.CutPrefix(p.lines[p.idx], "⚡ Ran command ")
+	if !ok {
+		return false
+	}
+	p.add(MessagePart{Type: MessagePartTypeToolCall, Tool: "shell", Content: command})
+	p.idx++
+	return true
+}
+
+// skipCodexLabel skips the "codex" line that Codex prints above its replies.
+func (p *partsParser) skipCodexLabel() bool {
+	if p.lines[p.idx] != "codex" {
+		return false
+	}
+	p.flushText()
+	p.idx++
+	return true
+}
+
+// parseGooseToolCall parses a tool call box and the output that follows it:
+//
+//	─── shell | developer ──────────────────────────
+//	command: git status
+//
+//	On branch main
+func (p *partsParser) parseGooseToolCall() bool {
+	match := gooseToolCallRe.FindStringSubmatch(p.lines[p.idx])
+	if match == nil {
+		return false
+	}
+	end := p.idx + 1
+	for end < len(p.lines) && p.lines[end] != "" {
+		end++
+	}
+	p.add(MessagePart{Type: MessagePartTypeToolCall, Tool: match[1], Content: strings.Join(p.lines[p.idx+1:end], "\n")})
+
+	// The output is separated from the call by empty lines, and ends with an empty line.
+	for end < len(p.lines) && p.lines[end] == "" {
+		end++
+	}
+	start := end
+	for end < len(p.lines) && p.lines[end] != "" && !gooseToolCallRe.MatchString(p.lines[end]) {
+		end++
+	}
+	if end > start {
+		p.add(MessagePart{Type: MessagePartTypeToolOutput, Content: strings.Join(p.lines[start:end], "\n")})
+	}
+	p.idx = end
+	return true
+}
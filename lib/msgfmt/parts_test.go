@@ -0,0 +1,46 @@
+package msgfmt
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageParts(t *testing.T) {
+	dir := "testdata/parts"
+	agentDirs, err := testdataDir.ReadDir(dir)
+	require.NoError(t, err)
+	for _, agentDir := range agentDirs {
+		agentType := AgentType(agentDir.Name())
+		t.Run(string(agentType), func(t *testing.T) {
+			cases, err := testdataDir.ReadDir(path.Join(dir, string(agentType)))
+			require.NoError(t, err)
+			for _, c := range cases {
+				t.Run(c.Name(), func(t *testing.T) {
+					msg, err := testdataDir.ReadFile(path.Join(dir, string(agentType), c.Name(), "msg.txt"))
+					require.NoError(t, err)
+					expectedJSON, err := testdataDir.ReadFile(path.Join(dir, string(agentType), c.Name(), "expected.json"))
+					require.NoError(t, err)
+					var expected []MessagePart
+					require.NoError(t, json.Unmarshal(expectedJSON, &expected))
+					assert.Equal(t, expected, ParseMessageParts(agentType, string(msg)))
+				})
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, ParseMessageParts(AgentTypeClaude, "  \n\n"))
+	})
+
+	t.Run("unterminated-code-block", func(t *testing.T) {
+		// The agent is still writing the block.
+		msg := "Here you go:\n```go\nfunc main() {"
+		assert.Equal(t, []MessagePart{
+			{Type: MessagePartTypeText, Content: msg},
+		}, ParseMessageParts(AgentTypeCustom, msg))
+	})
+}
@@ -0,0 +1,219 @@
+package msgfmt
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// InputEncoding is how messages are written to the agent's terminal.
+type InputEncoding string
+
+const (
+	// InputEncodingPaste pastes messages with bracketed paste, so that agents
+	// don't submit multi-line messages line by line.
+	InputEncodingPaste InputEncoding = "paste"
+	// InputEncodingRaw types messages as they are. Newlines press enter.
+	InputEncodingRaw InputEncoding = "raw"
+)
+
+// Profile declares how agentapi interacts with an agent: how the agent type is
+// selected, which parts of the screen aren't part of the agent's messages, and
+// which keys are sent to the agent. Profiles are read from YAML or JSON.
+type Profile struct {
+	// Name is the agent type the profile defines.
+	Name AgentType `yaml:"name" json:"name"`
+	// Aliases are other names that select the agent type, e.g. the name of the
+	// agent's executable.
+	Aliases []string `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	// Base is the agent type whose message formatting, message parsing and
+	// permission prompt detection the profile uses. These are built into
+	// agentapi, so custom profiles can reuse those of a built-in agent.
+	// Defaults to the profile itself, which gets the generic ones if it isn't built in.
+	Base AgentType `yaml:"base,omitempty" json:"base,omitempty"`
+	// HeaderRows is the number of rows at the top of the screen that are ignored
+	// when looking for new messages, e.g. because they show a token count that
+	// changes all the time.
+	HeaderRows int `yaml:"header_rows,omitempty" json:"header_rows,omitempty"`
+	// InputBoxMarkers are sets of strings that mark the bottom border of a box
+	// the agent echoes the user input in. The line after the echoed input is
+	// removed from the agent's message if it contains all strings of a set.
+	InputBoxMarkers [][]string `yaml:"input_box_markers,omitempty" json:"input_box_markers,omitempty"`
+	// EchoTrailingLines is the number of lines after the echoed user input that
+	// are removed from the agent's message with it, e.g. a line with the time
+	// the message was sent.
+	EchoTrailingLines int `yaml:"echo_trailing_lines,omitempty" json:"echo_trailing_lines,omitempty"`
+	// StartupKeys are written to the agent's terminal right after it starts.
+	StartupKeys string `yaml:"startup_keys,omitempty" json:"startup_keys,omitempty"`
+	// InputEncoding is how messages are written to the agent's terminal.
+	// Defaults to InputEncodingPaste.
+	InputEncoding InputEncoding `yaml:"input_encoding,omitempty" json:"input_encoding,omitempty"`
+	// InterruptKeys make the agent stop what it's doing. Defaults to Ctrl-C.
+	InterruptKeys string `yaml:"interrupt_keys,omitempty" json:"interrupt_keys,omitempty"`
+
+	// base is the built-in agent type Base resolves to, set when the profile is registered.
+	base AgentType
+}
+
+// ParseProfile parses a profile in YAML or JSON, which is a subset of YAML.
+func ParseProfile(data []byte) (*Profile, error) {
+	var p Profile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil {
+		return nil, xerrors.Errorf("failed to parse profile: %w", err)
+	}
+	if p.Name == "" {
+		return nil, xerrors.New("name must be set")
+	}
+	p.setDefaults()
+	if p.InputEncoding != InputEncodingPaste && p.InputEncoding != InputEncodingRaw {
+		return nil, xerrors.Errorf("invalid input encoding %q: must be paste or raw", p.InputEncoding)
+	}
+	if p.HeaderRows < 0 {
+		return nil, xerrors.New("header_rows must not be negative")
+	}
+	if p.EchoTrailingLines < 0 {
+		return nil, xerrors.New("echo_trailing_lines must not be negative")
+	}
+	for i, markers := range p.InputBoxMarkers {
+		if len(markers) == 0 {
+			return nil, xerrors.Errorf("input box marker set %d is empty", i+1)
+		}
+	}
+	return &p, nil
+}
+
+func (p *Profile) setDefaults() {
+	if p.InputEncoding == "" {
+		p.InputEncoding = InputEncodingPaste
+	}
+	if p.InterruptKeys == "" {
+		// Ctrl-C
+		p.InterruptKeys = "\x03"
+	}
+}
+
+// LoadProfile reads a profile from a YAML or JSON file.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read profile file: %w", err)
+	}
+	p, err := ParseProfile(data)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid profile file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   = map[AgentType]*Profile{}
+	// profileNames maps the names and aliases of the profiles to their agent types.
+	profileNames = map[string]AgentType{}
+)
+
+//go:embed profiles/*.yaml
+var builtinProfiles embed.FS
+
+func init() {
+	entries, err := builtinProfiles.ReadDir("profiles")
+	if err != nil {
+		panic(fmt.Sprintf("failed to read built-in profiles: %v", err))
+	}
+	for _, entry := range entries {
+		data, err := builtinProfiles.ReadFile(path.Join("profiles", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("failed to read built-in profile %s: %v", entry.Name(), err))
+		}
+		p, err := ParseProfile(data)
+		if err != nil {
+			panic(fmt.Sprintf("invalid built-in profile %s: %v", entry.Name(), err))
+		}
+		if err := RegisterProfile(p); err != nil {
+			panic(fmt.Sprintf("failed to register built-in profile %s: %v", entry.Name(), err))
+		}
+	}
+}
+
+// RegisterProfile makes the agent type of the profile available. Its name and
+// aliases must not be used by another profile, and its base must be registered.
+func RegisterProfile(p *Profile) error {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	for _, name := range append([]string{string(p.Name)}, p.Aliases...) {
+		if other, ok := profileNames[name]; ok {
+			return xerrors.Errorf("%q is already used by the %s profile", name, other)
+		}
+	}
+	p.setDefaults()
+	p.base = p.Name
+	if p.Base != "" && p.Base != p.Name {
+		base, ok := profiles[p.Base]
+		if !ok {
+			return xerrors.Errorf("unknown base agent type %q", p.Base)
+		}
+		p.base = base.base
+	}
+	profiles[p.Name] = p
+	profileNames[string(p.Name)] = p.Name
+	for _, alias := range p.Aliases {
+		profileNames[alias] = p.Name
+	}
+	return nil
+}
+
+// LookupProfile returns the profile of an agent type.
+func LookupProfile(agentType AgentType) (*Profile, bool) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	p, ok := profiles[agentType]
+	return p, ok
+}
+
+// ProfileByName returns the profile with the given name or alias.
+func ProfileByName(name string) (*Profile, bool) {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	agentType, ok := profileNames[name]
+	if !ok {
+		return nil, false
+	}
+	return profiles[agentType], true
+}
+
+// ProfileNames returns the sorted names and aliases of the registered profiles.
+func ProfileNames() []string {
+	profilesMu.RLock()
+	defer profilesMu.RUnlock()
+	names := make([]string, 0, len(profileNames))
+	for name := range profileNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileOf returns the profile of an agent type. Agent types without a
+// profile get a profile with the defaults.
+func ProfileOf(agentType AgentType) *Profile {
+	if p, ok := LookupProfile(agentType); ok {
+		return p
+	}
+	p := &Profile{Name: agentType, base: agentType}
+	p.setDefaults()
+	return p
+}
+
+// baseAgentType returns the agent type whose built-in behavior agentType uses.
+func baseAgentType(agentType AgentType) AgentType {
+	return ProfileOf(agentType).base
+}
@@ -0,0 +1,106 @@
+package msgfmt
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinProfiles(t *testing.T) {
+	agentTypes := []AgentType{AgentTypeClaude, AgentTypeGoose, AgentTypeAider, AgentTypeGemini, AgentTypeCopilot, AgentTypeAmp, AgentTypeCodex, AgentTypeCursor, AgentTypeAuggie, AgentTypeAmazonQ, AgentTypeOpencode, AgentTypeCustom}
+	for _, agentType := range agentTypes {
+		p, ok := LookupProfile(agentType)
+		require.True(t, ok, "missing profile for %s", agentType)
+		assert.Equal(t, agentType, p.base)
+	}
+
+	p, ok := ProfileByName("cursor-agent")
+	require.True(t, ok)
+	assert.Equal(t, AgentTypeCursor, p.Name)
+	assert.Equal(t, "\x1b", ProfileOf(AgentTypeClaude).InterruptKeys)
+	assert.Equal(t, " \b", ProfileOf(AgentTypeAmp).StartupKeys)
+
+	// Agent types without a profile get the defaults.
+	p = ProfileOf("unknown")
+	assert.Equal(t, InputEncodingPaste, p.InputEncoding)
+	assert.Equal(t, "\x03", p.InterruptKeys)
+	assert.Equal(t, "msg", FormatAgentMessage("unknown", "msg", "input"))
+}
+
+func TestParseProfile(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		p, err := ParseProfile([]byte("name: mybot\naliases: [bot]\nheader_rows: 1\ninput_box_markers: [[\"╯\", \"╰\"]]\nstartup_keys: \"\\x1b\"\ninput_encoding: raw\n"))
+		require.NoError(t, err)
+		assert.Equal(t, &Profile{
+			Name:            "mybot",
+			Aliases:         []string{"bot"},
+			HeaderRows:      1,
+			InputBoxMarkers: [][]string{{"╯", "╰"}},
+			StartupKeys:     "\x1b",
+			InputEncoding:   InputEncodingRaw,
+			InterruptKeys:   "\x03",
+		}, p)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		p, err := ParseProfile([]byte(`{"name": "mybot", "base": "codex", "interrupt_keys": "\u001b"}`))
+		require.NoError(t, err)
+		assert.Equal(t, &Profile{
+			Name:          "mybot",
+			Base:          AgentTypeCodex,
+			InputEncoding: InputEncodingPaste,
+			InterruptKeys: "\x1b",
+		}, p)
+	})
+
+	invalid := map[string]string{
+		"no name":          "aliases: [bot]\n",
+		"unknown field":    "name: mybot\nheader: 1\n",
+		"input encoding":   "name: mybot\ninput_encoding: typed\n",
+		"header rows":      "name: mybot\nheader_rows: -1\n",
+		"echo lines":       "name: mybot\necho_trailing_lines: -1\n",
+		"empty marker set": "name: mybot\ninput_box_markers: [[]]\n",
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseProfile([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRegisterProfile(t *testing.T) {
+	t.Run("base", func(t *testing.T) {
+		require.NoError(t, RegisterProfile(&Profile{Name: "test-codex", Base: AgentTypeCodex}))
+		// The profile formats messages like the agent it's based on.
+		dir := "testdata/format/codex/first_message"
+		msg, err := testdataDir.ReadFile(path.Join(dir, "msg.txt"))
+		require.NoError(t, err)
+		userInput, err := testdataDir.ReadFile(path.Join(dir, "user.txt"))
+		require.NoError(t, err)
+		expected, err := testdataDir.ReadFile(path.Join(dir, "expected.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), FormatAgentMessage("test-codex", string(msg), string(userInput)))
+	})
+
+	t.Run("echo removal", func(t *testing.T) {
+		require.NoError(t, RegisterProfile(&Profile{
+			Name:              "test-echo",
+			InputBoxMarkers:   [][]string{{"+--", "--+"}},
+			EchoTrailingLines: 1,
+		}))
+		msg := "| hello there |\n+-----------+\n(sent at 10:00)\nreply"
+		assert.Equal(t, "reply", RemoveUserInput(msg, "hello there", "test-echo"))
+		assert.Equal(t, "+-----------+\n(sent at 10:00)\nreply", RemoveUserInput(msg, "hello there", AgentTypeCustom))
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		assert.Error(t, RegisterProfile(&Profile{Name: AgentTypeClaude}))
+		assert.Error(t, RegisterProfile(&Profile{Name: "test-alias", Aliases: []string{"q"}}))
+		assert.Error(t, RegisterProfile(&Profile{Name: "test-base", Base: "unknown"}))
+		_, ok := LookupProfile("test-alias")
+		assert.False(t, ok)
+	})
+}
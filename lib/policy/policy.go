@@ -0,0 +1,325 @@
+// Package policy decides how to answer the permission prompts of an agent
+// based on rules, so that agents can run unattended.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v3"
+)
+
+// Decision is what the policy does with a permission prompt.
+type Decision string
+
+const (
+	// DecisionApprove approves the action once.
+	DecisionApprove Decision = "approve"
+	// DecisionDeny denies the action.
+	DecisionDeny Decision = "deny"
+	// DecisionAsk leaves the prompt to be answered through the API.
+	DecisionAsk Decision = "ask"
+)
+
+// Rule matches the permission prompts that show a command or a file path.
+// Exactly one of Command and Path is set.
+type Rule struct {
+	// Command is a pattern matched against the command the agent wants to run,
+	// e.g. "go test *". '*' matches any sequence of characters except newlines,
+	// and '?' matches a single character. The pattern must match the whole
+	// command, except in deny rules, which match anywhere in the prompt.
+	// Approve rules never match commands that run more than one program or
+	// redirect output, i.e. that contain ';', '&', '|', '`', '$(', '<', '>'
+	// or a newline.
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// Path is a glob matched against the file paths in the prompt, e.g. "docs/**/*.md".
+	// '*' and '?' don't match '/', while '**' matches any number of directories.
+	// Patterns without a '/' are matched against the base name of the file.
+	// Path rules only match prompts to edit or create files, and approve rules
+	// must match every file path in the prompt.
+	Path     string   `yaml:"path,omitempty" json:"path,omitempty"`
+	Decision Decision `yaml:"decision" json:"decision"`
+
+	re *regexp.Regexp
+}
+
+// Policy is an ordered list of rules. The first rule that matches a prompt
+// decides how it's answered. Prompts that no rule matches get the default decision.
+type Policy struct {
+	Default Decision `yaml:"default,omitempty" json:"default,omitempty"`
+	Rules   []Rule   `yaml:"rules" json:"rules"`
+}
+
+// Result is the outcome of evaluating a policy.
+type Result struct {
+	Decision Decision
+	// Rule is the index of the rule that matched, or -1 if the default decision was used.
+	Rule int
+	// Match is the command or path the rule matched.
+	Match string
+}
+
+func (r Result) String() string {
+	if r.Rule < 0 {
+		return fmt.Sprintf("%s (default)", r.Decision)
+	}
+	return fmt.Sprintf("%s (rule %d matched %q)", r.Decision, r.Rule+1, r.Match)
+}
+
+// Load reads a policy from a YAML or JSON file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read policy file: %w", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse parses a policy in YAML or JSON, which is a subset of YAML.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil {
+		return nil, xerrors.Errorf("failed to parse policy: %w", err)
+	}
+	if p.Default == "" {
+		p.Default = DecisionAsk
+	}
+	if !validDecision(p.Default) {
+		return nil, xerrors.Errorf("invalid default decision %q: must be approve, deny, or ask", p.Default)
+	}
+	for i := range p.Rules {
+		if err := p.Rules[i].compile(); err != nil {
+			return nil, xerrors.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return &p, nil
+}
+
+func validDecision(d Decision) bool {
+	return d == DecisionApprove || d == DecisionDeny || d == DecisionAsk
+}
+
+func (r *Rule) compile() error {
+	if !validDecision(r.Decision) {
+		return xerrors.Errorf("invalid decision %q: must be approve, deny, or ask", r.Decision)
+	}
+	switch {
+	case r.Command != "" && r.Path != "":
+		return xerrors.New("only one of command and path can be set")
+	case r.Command != "" && r.Decision == DecisionDeny:
+		r.re = regexp.MustCompile(globToRegexp(r.Command, false))
+	case r.Command != "":
+		r.re = regexp.MustCompile("^" + globToRegexp(r.Command, false) + "$")
+	case r.Path != "":
+		r.re = regexp.MustCompile("^" + globToRegexp(r.Path, true) + "$")
+	default:
+		return xerrors.New("either command or path must be set")
+	}
+	return nil
+}
+
+// globToRegexp converts a pattern to a regular expression. In path patterns,
+// wildcards other than '**' don't match the path separator.
+func globToRegexp(pattern string, isPath bool) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case isPath && strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case isPath && strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*' && isPath:
+			b.WriteString("[^/]*")
+		case pattern[i] == '*':
+			b.WriteString(".*")
+		case pattern[i] == '?' && isPath:
+			b.WriteString("[^/]")
+		case pattern[i] == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// Evaluate decides how to answer the prompt.
+func (p *Policy) Evaluate(prompt *mf.PermissionPrompt) Result {
+	a := parseAction(prompt)
+	for i, rule := range p.Rules {
+		if match, ok := rule.match(a); ok {
+			return Result{Decision: rule.Decision, Rule: i, Match: match}
+		}
+	}
+	return Result{Decision: p.Default, Rule: -1}
+}
+
+// action is what a permission prompt asks for: either running a command or
+// changing files.
+type action struct {
+	// isFile is true if the prompt asks to edit or create files.
+	isFile bool
+	// paths are the words of the prompt that may name the files.
+	paths []string
+	// command is the command the prompt asks to run. Commands that span
+	// several lines keep their newlines.
+	command string
+	// details is the full description of the action.
+	details string
+}
+
+var (
+	// e.g. "Do you want to make this edit to README.md?" or "Create new file?"
+	fileQuestionRe = regexp.MustCompile(`(?i)\b(?:edits?|write|create|overwrite|changes?|apply)\b`)
+	// Prompts that mention a command are about running it, even if it edits files.
+	commandWordRe = regexp.MustCompile(`(?i)\b(?:commands?|run|execute|execution|shell|bash)\b`)
+	// e.g. "Show the changed files", which Claude Code shows below the command.
+	descriptionRe = regexp.MustCompile(`^[A-Z][a-z]*(?: [A-Za-z0-9',.-]+)+$`)
+)
+
+func parseAction(prompt *mf.PermissionPrompt) *action {
+	paragraphs := promptParagraphs(prompt.Details)
+	// The first paragraph is a header, e.g. "Bash command" or "Edit file"
+	// followed by the path of the file.
+	var header []string
+	if len(paragraphs) > 0 {
+		header = paragraphs[0]
+	}
+	a := &action{details: prompt.Details}
+	a.isFile = fileQuestionRe.MatchString(prompt.Question) &&
+		!commandWordRe.MatchString(prompt.Question+"\n"+strings.Join(header, "\n"))
+	if a.isFile {
+		// File contents, e.g. the diff of an edit, aren't searched for paths.
+		for _, line := range header {
+			a.paths = append(a.paths, pathCandidates(line)...)
+		}
+		a.paths = append(a.paths, pathCandidates(prompt.Question)...)
+		return a
+	}
+	if len(paragraphs) > 1 {
+		// Commands shown on their own have no header.
+		paragraphs = paragraphs[1:]
+	}
+	var lines []string
+	for _, paragraph := range paragraphs {
+		lines = append(lines, paragraph...)
+	}
+	// Claude Code describes the command on the line below it.
+	if len(lines) > 1 && descriptionRe.MatchString(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	a.command = strings.Join(lines, "\n")
+	return a
+}
+
+// promptParagraphs splits the details of a prompt into paragraphs of trimmed lines.
+func promptParagraphs(details string) [][]string {
+	var paragraphs [][]string
+	var current []string
+	for _, line := range strings.Split(details, "\n") {
+		line = strings.TrimSpace(line)
+		// Some agents show commands with a shell prompt.
+		line = strings.TrimPrefix(line, "$ ")
+		if line == "" {
+			if current != nil {
+				paragraphs = append(paragraphs, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if current != nil {
+		paragraphs = append(paragraphs, current)
+	}
+	return paragraphs
+}
+
+func pathCandidates(line string) []string {
+	var candidates []string
+	for _, field := range strings.Fields(line) {
+		filePath := strings.TrimSuffix(strings.Trim(field, "\"'`()[]{}<>,;:?!"), ".")
+		if filePath != "" {
+			candidates = append(candidates, filePath)
+		}
+	}
+	return candidates
+}
+
+// isSimpleCommand returns true if the command runs a single program, so that
+// matching its beginning is enough to know what it does.
+func isSimpleCommand(command string) bool {
+	return command != "" && !strings.ContainsAny(command, ";&|`<>\n") && !strings.Contains(command, "$(")
+}
+
+func (r *Rule) match(a *action) (string, bool) {
+	if r.Path != "" {
+		return r.matchPaths(a)
+	}
+	if a.isFile {
+		return "", false
+	}
+	if r.Decision == DecisionDeny {
+		// Deny rules match anywhere, e.g. "rm -rf *" matches "cd build && rm -rf .".
+		loc := r.re.FindStringIndex(a.details)
+		if loc == nil {
+			return "", false
+		}
+		return strings.TrimSpace(a.details[loc[0]:loc[1]]), true
+	}
+	if r.Decision == DecisionApprove && !isSimpleCommand(a.command) {
+		return "", false
+	}
+	if a.command == "" || !r.re.MatchString(a.command) {
+		return "", false
+	}
+	return a.command, true
+}
+
+func (r *Rule) matchPaths(a *action) (string, bool) {
+	if !a.isFile {
+		return "", false
+	}
+	var matched, unmatched []string
+	for _, filePath := range a.paths {
+		// Paths like "docs/../main.go" must not match "docs/**".
+		candidate := path.Clean(filePath)
+		if !strings.Contains(r.Path, "/") {
+			candidate = path.Base(candidate)
+		}
+		switch {
+		case r.re.MatchString(candidate) && r.Decision != DecisionApprove:
+			return filePath, true
+		case r.re.MatchString(candidate):
+			matched = append(matched, filePath)
+		case strings.ContainsAny(filePath, "./"):
+			unmatched = append(unmatched, filePath)
+		}
+	}
+	if len(matched) == 0 {
+		return "", false
+	}
+	// Approve rules must match every file of the prompt. Agents often name a
+	// file again by its base name, e.g. in the question.
+	for _, filePath := range unmatched {
+		if !slices.ContainsFunc(matched, func(m string) bool { return path.Base(m) == filePath }) {
+			return "", false
+		}
+	}
+	return matched[0], true
+}
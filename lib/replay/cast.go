@@ -0,0 +1,93 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// CastHeader is the first line of an asciicast v2 file.
+type CastHeader struct {
+	Version   int    `json:"version"`
+	Width     uint16 `json:"width"`
+	Height    uint16 `json:"height"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// The types of the events of an asciicast v2 file.
+const (
+	EventTypeOutput = "o"
+	EventTypeInput  = "i"
+	EventTypeResize = "r"
+	EventTypeMarker = "m"
+)
+
+// CastEvent is something that happened in the terminal, Time after the
+// recording started.
+type CastEvent struct {
+	Time time.Duration
+	Type string
+	Data string
+}
+
+// Cast is a recording of a terminal in the asciicast v2 format, as written by
+// termexec.Recorder and asciinema.
+type Cast struct {
+	Header CastHeader
+	Events []CastEvent
+}
+
+// ParseCast reads an asciicast v2 file.
+func ParseCast(r io.Reader) (*Cast, error) {
+	scanner := bufio.NewScanner(r)
+	// A single event can hold a lot of output.
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, xerrors.Errorf("failed to read cast: %w", err)
+		}
+		return nil, xerrors.New("the cast is empty")
+	}
+	cast := &Cast{}
+	if err := json.Unmarshal(scanner.Bytes(), &cast.Header); err != nil {
+		return nil, xerrors.Errorf("failed to parse cast header: %w", err)
+	}
+	if cast.Header.Version != 2 {
+		return nil, xerrors.Errorf("unsupported cast version %d, only version 2 is supported", cast.Header.Version)
+	}
+	if cast.Header.Width == 0 || cast.Header.Height == 0 {
+		return nil, xerrors.New("the cast header doesn't contain the terminal size")
+	}
+
+	line := 1
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var fields []json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil || len(fields) != 3 {
+			return nil, xerrors.Errorf("line %d: an event must be an array of time, type and data", line)
+		}
+		var seconds float64
+		var event CastEvent
+		if err := json.Unmarshal(fields[0], &seconds); err != nil {
+			return nil, xerrors.Errorf("line %d: invalid event time: %w", line, err)
+		}
+		if err := json.Unmarshal(fields[1], &event.Type); err != nil {
+			return nil, xerrors.Errorf("line %d: invalid event type: %w", line, err)
+		}
+		if err := json.Unmarshal(fields[2], &event.Data); err != nil {
+			return nil, xerrors.Errorf("line %d: invalid event data: %w", line, err)
+		}
+		event.Time = time.Duration(seconds * float64(time.Second))
+		cast.Events = append(cast.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, xerrors.Errorf("failed to read cast: %w", err)
+	}
+	return cast, nil
+}
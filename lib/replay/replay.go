@@ -0,0 +1,188 @@
+// Package replay feeds recordings of an agent's terminal through the screen
+// tracker offline, to find the messages the server would have found in them.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/termexec"
+	"golang.org/x/xerrors"
+)
+
+// The defaults match the settings of the server.
+const (
+	DefaultSnapshotInterval      = 25 * time.Millisecond
+	DefaultScreenStabilityLength = 2 * time.Second
+)
+
+type Config struct {
+	// AgentType selects how the agent's messages are formatted.
+	AgentType mf.AgentType
+	// SnapshotInterval is how often the screen is snapshotted. Defaults to DefaultSnapshotInterval.
+	SnapshotInterval time.Duration
+	// ScreenStabilityLength is how long the screen must not change to be
+	// considered stable. Defaults to DefaultScreenStabilityLength.
+	ScreenStabilityLength time.Duration
+	// ScrollbackLines is the number of lines that scrolled off the top of the
+	// terminal to keep. The scrollback is disabled if it's 0.
+	ScrollbackLines int
+}
+
+// The sequences the server wraps messages in when it pastes them into the agent's terminal.
+const (
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+	// pastePrefix is written before the paste, see httpapi.FormatMessage.
+	pastePrefix = "x\b"
+)
+
+// userMessages finds the messages the server sent to the agent in the input
+// events. Messages are pasted with bracketed paste, which sets them apart from
+// keystrokes, e.g. ones sent by `agentapi attach`, which don't become messages.
+// Messages sent to agents whose profile has the raw input encoding aren't found.
+// The messages are returned by the index of the event the server started to
+// write them with.
+func userMessages(events []CastEvent) map[int]string {
+	messages := make(map[int]string)
+	var text strings.Builder
+	pasting := false
+	start := 0
+	previousInput := -1
+	for i, event := range events {
+		if event.Type != EventTypeInput {
+			continue
+		}
+		data := event.Data
+		if !pasting {
+			index := strings.Index(data, pasteStart)
+			if index < 0 {
+				previousInput = i
+				continue
+			}
+			start = i
+			if index == 0 && previousInput >= 0 && events[previousInput].Data == pastePrefix {
+				start = previousInput
+			}
+			pasting = true
+			text.Reset()
+			data = data[index+len(pasteStart):]
+		}
+		if index := strings.Index(data, pasteEnd); index >= 0 {
+			text.WriteString(data[:index])
+			pasting = false
+			if message := mf.TrimWhitespace(text.String()); message != "" {
+				messages[start] = message
+			}
+		} else {
+			text.WriteString(data)
+		}
+		previousInput = i
+	}
+	return messages
+}
+
+// sendState tracks a message being sent to the agent. The live server doesn't
+// take snapshots while it sends a message, so neither does the replay.
+type sendState int
+
+const (
+	sendStateNone sendState = iota
+	// sendStateWritten means that the message was written and the server waits
+	// for the agent to echo it before pressing enter.
+	sendStateWritten
+	// sendStateSubmitted means that enter was pressed, and the server waits for
+	// the agent to start processing the message.
+	sendStateSubmitted
+)
+
+// Replay feeds the output of the cast into a terminal emulator on a virtual
+// clock, and takes snapshots of the screen as the snapshot loop of the server
+// would. It returns the messages of the conversation once the screen is stable
+// after the last event.
+func Replay(ctx context.Context, cast *Cast, cfg Config) ([]st.ConversationMessage, error) {
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if cfg.ScreenStabilityLength <= 0 {
+		cfg.ScreenStabilityLength = DefaultScreenStabilityLength
+	}
+	if cfg.AgentType == "" {
+		cfg.AgentType = mf.AgentTypeCustom
+	}
+
+	terminal, err := termexec.NewVirtualTerminal(cast.Header.Width, cast.Header.Height, cfg.ScrollbackLines)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create terminal: %w", err)
+	}
+	start := time.Unix(cast.Header.Timestamp, 0)
+	now := start
+	conversation := st.NewConversation(ctx, st.ConversationConfig{
+		AgentType: cfg.AgentType,
+		AgentIO:   terminal,
+		GetTime: func() time.Time {
+			return now
+		},
+		SnapshotInterval:      cfg.SnapshotInterval,
+		ScreenStabilityLength: cfg.ScreenStabilityLength,
+		FormatMessage: func(message string, userInput string) string {
+			return mf.FormatAgentMessage(cfg.AgentType, message, userInput)
+		},
+		SkipWritingMessage:         true,
+		SkipSendMessageStatusCheck: true,
+	}, "")
+
+	nextSnapshot := start.Add(cfg.SnapshotInterval)
+	state := sendStateNone
+	// advance takes the snapshots that are due until t.
+	advance := func(t time.Time) {
+		for !nextSnapshot.After(t) {
+			now = nextSnapshot
+			if state == sendStateNone {
+				conversation.TakeSnapshot()
+			}
+			nextSnapshot = nextSnapshot.Add(cfg.SnapshotInterval)
+		}
+		now = t
+	}
+
+	messages := userMessages(cast.Events)
+	for i, event := range cast.Events {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		advance(start.Add(event.Time))
+		switch event.Type {
+		case EventTypeOutput:
+			terminal.WriteOutput([]byte(event.Data))
+			if state == sendStateSubmitted {
+				state = sendStateNone
+			}
+		case EventTypeInput:
+			if message, ok := messages[i]; ok {
+				if err := conversation.SendMessage(st.MessagePartText{Content: message}); err != nil {
+					return nil, xerrors.Errorf("event %d: failed to send message: %w", i, err)
+				}
+				state = sendStateWritten
+			} else if state == sendStateWritten && strings.Contains(event.Data, "\r") {
+				state = sendStateSubmitted
+			}
+		case EventTypeResize:
+			var width, height uint16
+			if _, err := fmt.Sscanf(event.Data, "%dx%d", &width, &height); err != nil {
+				return nil, xerrors.Errorf("event %d: invalid terminal size %q: %w", i, event.Data, err)
+			}
+			terminal.Resize(width, height)
+			conversation.Resized()
+		}
+	}
+	// The conversation is stable once the screen didn't change for the stability
+	// length, which takes one more snapshot than fits in it.
+	state = sendStateNone
+	advance(now.Add(cfg.ScreenStabilityLength + cfg.SnapshotInterval))
+	return conversation.Messages(), nil
+}
@@ -0,0 +1,119 @@
+package replay
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type transcriptMessage struct {
+	Role    st.ConversationRole
+	Content string
+}
+
+// transcript returns the roles and contents of the messages, without the
+// trailing spaces of the lines of the terminal.
+func transcript(messages []st.ConversationMessage) []transcriptMessage {
+	result := make([]transcriptMessage, 0, len(messages))
+	for _, msg := range messages {
+		lines := strings.Split(msg.Message, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " ")
+		}
+		result = append(result, transcriptMessage{Role: msg.Role, Content: strings.Join(lines, "\n")})
+	}
+	return result
+}
+
+func loadCast(t *testing.T, name string) *Cast {
+	t.Helper()
+	file, err := os.Open("testdata/" + name)
+	require.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+	cast, err := ParseCast(file)
+	require.NoError(t, err)
+	return cast
+}
+
+func TestReplay(t *testing.T) {
+	cast := loadCast(t, "custom.cast")
+	reply := "Sure:\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12"
+
+	t.Run("scrollback", func(t *testing.T) {
+		messages, err := Replay(context.Background(), cast, Config{ScrollbackLines: 1000})
+		require.NoError(t, err)
+		// The keystrokes sent after the reply don't become a message.
+		assert.Equal(t, []transcriptMessage{
+			{Role: st.ConversationRoleAgent, Content: "Welcome to the agent"},
+			{Role: st.ConversationRoleUser, Content: "count to 12"},
+			{Role: st.ConversationRoleAgent, Content: reply},
+		}, transcript(messages))
+	})
+
+	t.Run("no scrollback", func(t *testing.T) {
+		messages, err := Replay(context.Background(), cast, Config{})
+		require.NoError(t, err)
+		require.Len(t, messages, 3)
+		// The start of the reply scrolled off the 8 rows of the terminal.
+		assert.Equal(t, "6\n7\n8\n9\n10\n11\n12", transcript(messages)[2].Content)
+	})
+
+	t.Run("resize", func(t *testing.T) {
+		resized := *cast
+		end := cast.Events[len(cast.Events)-1].Time + time.Second
+		resized.Events = append(append([]CastEvent(nil), cast.Events...),
+			CastEvent{Time: end, Type: EventTypeResize, Data: "60x8"},
+			CastEvent{Time: end, Type: EventTypeOutput, Data: "\x1b[2J\x1b[H12\r\n> "},
+		)
+		messages, err := Replay(context.Background(), &resized, Config{ScrollbackLines: 1000})
+		require.NoError(t, err)
+		// The screen redrawn after the resize isn't added to the reply.
+		assert.Equal(t, reply, transcript(messages)[2].Content)
+	})
+}
+
+func TestUserMessages(t *testing.T) {
+	events := []CastEvent{
+		{Type: EventTypeInput, Data: "ls\r"},
+		{Type: EventTypeOutput, Data: "ls"},
+		{Type: EventTypeInput, Data: "x\b"},
+		{Type: EventTypeInput, Data: "\x1b[200~"},
+		{Type: EventTypeInput, Data: "  hello\nworld "},
+		{Type: EventTypeInput, Data: "\x1b[201~"},
+		{Type: EventTypeInput, Data: "\r"},
+		{Type: EventTypeInput, Data: "\x1b[200~pasted\x1b[201~"},
+		{Type: EventTypeInput, Data: "\x1b[200~   \x1b[201~"},
+	}
+	assert.Equal(t, map[int]string{2: "hello\nworld", 7: "pasted"}, userMessages(events))
+}
+
+func TestParseCast(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"empty", "", "the cast is empty"},
+		{"version 1", `{"version": 1, "width": 80, "height": 24}`, "unsupported cast version 1"},
+		{"no size", `{"version": 2}`, "doesn't contain the terminal size"},
+		{"invalid event", "{\"version\": 2, \"width\": 80, \"height\": 24}\n[1, \"o\"]", "line 2: an event must be an array"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseCast(strings.NewReader(tc.input))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	cast := loadCast(t, "custom.cast")
+	assert.Equal(t, CastHeader{Version: 2, Width: 40, Height: 8, Timestamp: 1700000000}, cast.Header)
+	assert.Equal(t, CastEvent{Time: 100_000_000, Type: EventTypeOutput, Data: "Welcome to the agent\r\n> "}, cast.Events[0])
+}
@@ -23,6 +23,16 @@ type AgentIO interface {
 	ReadScreen() string
 }
 
+// ScrollbackReader is implemented by an AgentIO whose terminal keeps the lines
+// that scrolled off the top of the screen. Messages that don't fit on the screen
+// are then found in the scrollback.
+type ScrollbackReader interface {
+	// ReadScreenWithScrollback returns the screen together with the lines that
+	// scrolled off the screen, starting with line number from, and the number of
+	// lines that scrolled off so far.
+	ReadScreenWithScrollback(from int) (screen string, scrollback []string, total int)
+}
+
 type ConversationConfig struct {
 	AgentType msgfmt.AgentType
 	AgentIO   AgentIO
@@ -41,6 +51,9 @@ type ConversationConfig struct {
 	// SkipSendMessageStatusCheck skips the check for whether the message can be sent.
 	// This is used in tests
 	SkipSendMessageStatusCheck bool
+	// History contains messages restored from a previous run of the agent.
+	// Messages tracked by the conversation are appended after them.
+	History []ConversationMessage
 }
 
 type ConversationRole string
@@ -48,11 +61,15 @@ type ConversationRole string
 const (
 	ConversationRoleUser  ConversationRole = "user"
 	ConversationRoleAgent ConversationRole = "agent"
+	// ConversationRoleSystem messages are added by AgentAPI itself, e.g. to mark
+	// that the agent was restarted.
+	ConversationRoleSystem ConversationRole = "system"
 )
 
 var ConversationRoleValues = []ConversationRole{
 	ConversationRoleUser,
 	ConversationRoleAgent,
+	ConversationRoleSystem,
 }
 
 func (c ConversationRole) Schema(r huma.Registry) *huma.Schema {
@@ -73,7 +90,21 @@ type Conversation struct {
 	snapshotBuffer              *RingBuffer[screenSnapshot]
 	messages                    []ConversationMessage
 	screenBeforeLastUserMessage string
-	lock                        sync.Mutex
+	// scrollbackStart is the number of lines that had scrolled off the screen
+	// when screenBeforeLastUserMessage was read. Only the lines that scrolled off
+	// after it can be part of the agent's reply.
+	scrollbackStart int
+	// scrollbackEnd is the number of lines that had scrolled off the screen
+	// when the screen was last read.
+	scrollbackEnd int
+	// messagePrefix is the part of the last agent message that was on the screen
+	// before the terminal was resized. The rest of the message is found by comparing
+	// the screen with the screen right after the resize.
+	messagePrefix string
+	// resizedAt is the time the terminal was resized. It's zero once the agent
+	// finished redrawing the screen.
+	resizedAt time.Time
+	lock      sync.Mutex
 	// InitialPrompt is the initial prompt passed to the agent
 	InitialPrompt string
 	// InitialPromptSent keeps track if the InitialPrompt has been successfully sent to the agents
@@ -100,19 +131,21 @@ func getStableSnapshotsThreshold(cfg ConversationConfig) int {
 
 func NewConversation(ctx context.Context, cfg ConversationConfig, initialPrompt string) *Conversation {
 	threshold := getStableSnapshotsThreshold(cfg)
+	messages := make([]ConversationMessage, 0, len(cfg.History)+1)
+	messages = append(messages, cfg.History...)
+	messages = append(messages, ConversationMessage{
+		Id:      len(cfg.History),
+		Message: "",
+		Role:    ConversationRoleAgent,
+		Time:    cfg.GetTime(),
+	})
 	c := &Conversation{
 		cfg:                      cfg,
 		stableSnapshotsThreshold: threshold,
 		snapshotBuffer:           NewRingBuffer[screenSnapshot](threshold),
-		messages: []ConversationMessage{
-			{
-				Message: "",
-				Role:    ConversationRoleAgent,
-				Time:    cfg.GetTime(),
-			},
-		},
-		InitialPrompt:     initialPrompt,
-		InitialPromptSent: len(initialPrompt) == 0,
+		messages:                 messages,
+		InitialPrompt:            initialPrompt,
+		InitialPromptSent:        len(initialPrompt) == 0,
 	}
 	return c
 }
@@ -124,45 +157,66 @@ func (c *Conversation) StartSnapshotLoop(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-time.After(c.cfg.SnapshotInterval):
-				// It's important that we hold the lock while reading the screen.
-				// There's a race condition that occurs without it:
-				// 1. The screen is read
-				// 2. Independently, SendMessage is called and takes the lock.
-				// 3. AddSnapshot is called and waits on the lock.
-				// 4. SendMessage modifies the terminal state, releases the lock
-				// 5. AddSnapshot adds a snapshot from a stale screen
-				c.lock.Lock()
-				screen := c.cfg.AgentIO.ReadScreen()
-				c.addSnapshotInner(screen)
-				c.lock.Unlock()
+				c.TakeSnapshot()
 			}
 		}
 	}()
 }
 
+// TakeSnapshot reads the agent's screen and adds a snapshot of it. It's called by
+// the snapshot loop, and can be called directly to drive the conversation on a
+// virtual clock.
+func (c *Conversation) TakeSnapshot() {
+	// It's important that we hold the lock while reading the screen.
+	// There's a race condition that occurs without it:
+	// 1. The screen is read
+	// 2. Independently, SendMessage is called and takes the lock.
+	// 3. AddSnapshot is called and waits on the lock.
+	// 4. SendMessage modifies the terminal state, releases the lock
+	// 5. AddSnapshot adds a snapshot from a stale screen
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	screen, text := c.readScreen()
+	c.addSnapshotInner(screen, text)
+}
+
+// readScreen reads the screen of the agent, and the text its messages are found in:
+// the lines that scrolled off the screen since the last user message, followed by
+// the screen. The text is the screen if the agent's terminal doesn't keep a scrollback.
+// Assumes the caller holds the lock.
+func (c *Conversation) readScreen() (screen string, text string) {
+	reader, ok := c.cfg.AgentIO.(ScrollbackReader)
+	if !ok {
+		screen = c.cfg.AgentIO.ReadScreen()
+		return screen, screen
+	}
+	screen, scrollback, total := reader.ReadScreenWithScrollback(c.scrollbackStart)
+	c.scrollbackEnd = total
+	if len(scrollback) == 0 {
+		return screen, screen
+	}
+	return screen, strings.Join(scrollback, "\n") + "\n" + screen
+}
+
 func FindNewMessage(oldScreen, newScreen string, agentType msgfmt.AgentType) string {
 	oldLines := strings.Split(oldScreen, "\n")
 	newLines := strings.Split(newScreen, "\n")
 	oldLinesMap := make(map[string]bool)
 
-	// -1 indicates no header
-	dynamicHeaderEnd := -1
-
-	// Skip header lines for Opencode agent type to avoid false positives
-	// The header contains dynamic content (token count, context percentage, cost)
-	// that changes between screens, causing line comparison mismatches:
-	//
-	// ┃  # Getting Started with Claude CLI                                   ┃
-	// ┃  /share to create a shareable link                 12.6K/6% ($0.05)  ┃
-	if len(newLines) >= 2 && agentType == msgfmt.AgentTypeOpencode {
-		dynamicHeaderEnd = 2
+	// Skip the header rows of the agent's screen to avoid false positives.
+	// They contain dynamic content, e.g. a token count, that changes between
+	// screens, causing line comparison mismatches.
+	headerRows := 0
+	if rows := msgfmt.ProfileOf(agentType).HeaderRows; len(newLines) >= rows {
+		headerRows = rows
 	}
 
 	for _, line := range oldLines {
 		oldLinesMap[line] = true
 	}
 	firstNonMatchingLine := len(newLines)
-	for i, line := range newLines[dynamicHeaderEnd+1:] {
+	for i, line := range newLines[headerRows:] {
 		if !oldLinesMap[line] {
 			firstNonMatchingLine = i
 			break
@@ -204,7 +258,10 @@ func (c *Conversation) updateLastAgentMessage(screen string, timestamp time.Time
 	if c.cfg.FormatMessage != nil {
 		agentMessage = c.cfg.FormatMessage(agentMessage, lastUserMessage.Message)
 	}
-	shouldCreateNewMessage := len(c.messages) == 0 || c.messages[len(c.messages)-1].Role == ConversationRoleUser
+	if c.messagePrefix != "" {
+		agentMessage = strings.TrimSuffix(c.messagePrefix+"\n"+agentMessage, "\n")
+	}
+	shouldCreateNewMessage := len(c.messages) == 0 || c.messages[len(c.messages)-1].Role != ConversationRoleAgent
 	lastAgentMessage := c.lastMessage(ConversationRoleAgent)
 	if lastAgentMessage.Message == agentMessage {
 		return
@@ -222,21 +279,98 @@ func (c *Conversation) updateLastAgentMessage(screen string, timestamp time.Time
 	c.messages[len(c.messages)-1].Id = len(c.messages) - 1
 }
 
-// assumes the caller holds the lock
-func (c *Conversation) addSnapshotInner(screen string) {
+// addSnapshotInner adds a snapshot of the screen. Messages are found in text, which
+// may contain the lines that scrolled off the screen before it.
+// Assumes the caller holds the lock.
+func (c *Conversation) addSnapshotInner(screen string, text string) {
 	snapshot := screenSnapshot{
 		timestamp: c.cfg.GetTime(),
 		screen:    screen,
 	}
 	c.snapshotBuffer.Add(snapshot)
-	c.updateLastAgentMessage(screen, snapshot.timestamp)
+	if !c.resizedAt.IsZero() {
+		// The lines of the screen are rewrapped while the agent redraws it, so
+		// comparing them with the screen before the resize would turn the whole
+		// screen into the agent's message.
+		if !c.settledSince(c.resizedAt) {
+			return
+		}
+		c.resizedAt = time.Time{}
+		if len(c.messages) > 0 && c.messages[len(c.messages)-1].Role == ConversationRoleAgent {
+			c.messagePrefix = c.messages[len(c.messages)-1].Message
+		}
+		c.screenBeforeLastUserMessage = screen
+		c.scrollbackStart = c.scrollbackEnd
+	}
+	c.updateLastAgentMessage(text, snapshot.timestamp)
+}
+
+// resizeSettleLength is how long the screen must not change after the terminal
+// was resized for the redraw to be considered finished. Output the agent writes
+// in the meantime is not added to its message.
+const resizeSettleLength = 300 * time.Millisecond
+
+// settledSince returns true if the screen didn't change for resizeSettleLength,
+// or the screen stability length if it's shorter, taking only the snapshots
+// after the given time into account. Assumes the caller holds the lock.
+func (c *Conversation) settledSince(since time.Time) bool {
+	settleLength := min(resizeSettleLength, c.cfg.ScreenStabilityLength)
+	snapshots := c.snapshotBuffer.GetAll()
+	if len(snapshots) == 0 {
+		return false
+	}
+	latest := snapshots[len(snapshots)-1]
+	for i := len(snapshots) - 2; i >= 0; i-- {
+		if snapshots[i].timestamp.Before(since) || snapshots[i].screen != latest.screen {
+			break
+		}
+		if latest.timestamp.Sub(snapshots[i].timestamp) >= settleLength {
+			return true
+		}
+	}
+	return false
+}
+
+// Resized tells the conversation that the agent's terminal was resized. Agents
+// redraw the screen at the new size, so the last agent message isn't updated
+// until the screen settles, and the agent needs to become stable again before
+// it can receive messages.
+func (c *Conversation) Resized() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.resizedAt = c.cfg.GetTime()
+	c.snapshotBuffer = NewRingBuffer[screenSnapshot](c.stableSnapshotsThreshold)
+}
+
+// ReplaceAgentIO switches the conversation to a new terminal, e.g. after the agent
+// process was restarted. The history is kept, and a system message with the given
+// content marks where the output of the new agent starts.
+func (c *Conversation) ReplaceAgentIO(agentIO AgentIO, marker string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.cfg.AgentIO = agentIO
+	// The new agent needs to become stable again before it can receive messages.
+	c.snapshotBuffer = NewRingBuffer[screenSnapshot](c.stableSnapshotsThreshold)
+	c.screenBeforeLastUserMessage = ""
+	c.scrollbackStart = 0
+	c.scrollbackEnd = 0
+	c.messagePrefix = ""
+	c.resizedAt = time.Time{}
+	c.messages = append(c.messages, ConversationMessage{
+		Id:      len(c.messages),
+		Message: marker,
+		Role:    ConversationRoleSystem,
+		Time:    c.cfg.GetTime(),
+	})
 }
 
 func (c *Conversation) AddSnapshot(screen string) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	c.addSnapshotInner(screen)
+	c.addSnapshotInner(screen, screen)
 }
 
 type MessagePart interface {
@@ -356,15 +490,18 @@ func (c *Conversation) SendMessage(messageParts ...MessagePart) error {
 		return MessageValidationErrorEmpty
 	}
 
-	screenBeforeMessage := c.cfg.AgentIO.ReadScreen()
+	screenBeforeMessage, textBeforeMessage := c.readScreen()
+	scrollbackBeforeMessage := c.scrollbackEnd
 	now := c.cfg.GetTime()
-	c.updateLastAgentMessage(screenBeforeMessage, now)
+	c.updateLastAgentMessage(textBeforeMessage, now)
 
 	if err := c.writeMessageWithConfirmation(context.Background(), messageParts...); err != nil {
 		return xerrors.Errorf("failed to send message: %w", err)
 	}
 
 	c.screenBeforeLastUserMessage = screenBeforeMessage
+	c.scrollbackStart = scrollbackBeforeMessage
+	c.messagePrefix = ""
 	c.messages = append(c.messages, ConversationMessage{
 		Id:      len(c.messages),
 		Message: message,
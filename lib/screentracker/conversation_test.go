@@ -348,6 +348,106 @@ func TestMessages(t *testing.T) {
 		c := newConversation()
 		assert.Error(t, sendMsg(c, ""), st.MessageValidationErrorEmpty)
 	})
+
+	t.Run("history", func(t *testing.T) {
+		agent := &testAgent{}
+		c := newConversation(func(cfg *st.ConversationConfig) {
+			cfg.AgentIO = agent
+			cfg.History = []st.ConversationMessage{
+				agentMsg(0, "welcome"),
+				userMsg(1, "hi"),
+				agentMsg(2, "hello"),
+			}
+		})
+		assert.Equal(t, []st.ConversationMessage{
+			agentMsg(0, "welcome"),
+			userMsg(1, "hi"),
+			agentMsg(2, "hello"),
+			agentMsg(3, ""),
+		}, c.Messages())
+
+		agent.screen = "1"
+		c.AddSnapshot("1")
+		assert.NoError(t, sendMsg(c, "2"))
+		agent.screen = "1\n3"
+		c.AddSnapshot("1\n3")
+		assert.Equal(t, []st.ConversationMessage{
+			agentMsg(0, "welcome"),
+			userMsg(1, "hi"),
+			agentMsg(2, "hello"),
+			agentMsg(3, "1"),
+			userMsg(4, "2"),
+			agentMsg(5, "3"),
+		}, c.Messages())
+	})
+
+	t.Run("replace-agent-io", func(t *testing.T) {
+		agent := &testAgent{}
+		c := newConversation(func(cfg *st.ConversationConfig) {
+			cfg.AgentIO = agent
+		})
+		agent.screen = "1"
+		c.AddSnapshot("1")
+		assert.NoError(t, sendMsg(c, "2"))
+		agent.screen = "1\n3"
+		c.AddSnapshot("1\n3")
+
+		restarted := &testAgent{screen: "4"}
+		c.ReplaceAgentIO(restarted, "restarted")
+		assert.Equal(t, st.ConversationStatusInitializing, c.Status())
+		// The output of the new agent is a new message, even if it's
+		// similar to the screen of the previous agent.
+		c.AddSnapshot("1\n4")
+		assert.Equal(t, []st.ConversationMessage{
+			agentMsg(0, "1"),
+			userMsg(1, "2"),
+			agentMsg(2, "3"),
+			{Id: 3, Message: "restarted", Role: st.ConversationRoleSystem, Time: now},
+			agentMsg(4, "1\n4"),
+		}, c.Messages())
+	})
+	t.Run("resize", func(t *testing.T) {
+		agent := &testAgent{}
+		clock := now
+		c := newConversation(func(cfg *st.ConversationConfig) {
+			cfg.AgentIO = agent
+			cfg.GetTime = func() time.Time {
+				clock = clock.Add(time.Second)
+				return clock
+			}
+		})
+		contents := func() []string {
+			var contents []string
+			for _, msg := range c.Messages() {
+				contents = append(contents, msg.Message)
+			}
+			return contents
+		}
+		c.AddSnapshot("1")
+		agent.screen = "1"
+		assert.NoError(t, sendMsg(c, "2"))
+		c.AddSnapshot("1\nfoo bar")
+		assert.Equal(t, []string{"1", "2", "foo bar"}, contents())
+
+		c.Resized()
+		assert.Equal(t, st.ConversationStatusInitializing, c.Status())
+		// The message isn't updated while the agent redraws the screen.
+		c.AddSnapshot("1\nfo")
+		c.AddSnapshot("1\nfoo\nbar")
+		assert.Equal(t, []string{"1", "2", "foo bar"}, contents())
+		c.AddSnapshot("1\nfoo\nbar")
+		assert.Equal(t, []string{"1", "2", "foo bar"}, contents())
+
+		// Output after the redraw is appended to the message.
+		c.AddSnapshot("1\nfoo\nbar\nbaz")
+		assert.Equal(t, []string{"1", "2", "foo bar\nbaz"}, contents())
+
+		// The next message is found relative to the screen before it was sent.
+		agent.screen = "1\nfoo\nbar\nbaz"
+		assert.NoError(t, sendMsg(c, "3"))
+		c.AddSnapshot("1\nfoo\nbar\nbaz\nqux")
+		assert.Equal(t, []string{"1", "2", "foo bar\nbaz", "3", "qux"}, contents())
+	})
 }
 
 //go:embed testdata
@@ -0,0 +1,197 @@
+package screentracker
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+type journalEntry struct {
+	Id      int              `json:"id"`
+	Role    ConversationRole `json:"role"`
+	Message string           `json:"message"`
+	Time    time.Time        `json:"time"`
+}
+
+// MessageJournal persists conversation messages to an append-only JSONL file,
+// so the conversation history survives restarts of the server.
+//
+// A message may be appended more than once as it changes. When the journal is
+// read back, the last entry for every message ID wins.
+type MessageJournal struct {
+	mu        sync.Mutex
+	file      *os.File
+	persisted map[int]ConversationMessage
+}
+
+// OpenMessageJournal opens the journal at path, creating it if it doesn't exist,
+// and returns the messages it contains ordered by ID. The journal is compacted
+// on open so that every message ID appears at most once.
+func OpenMessageJournal(path string) (*MessageJournal, []ConversationMessage, error) {
+	messages, err := readJournal(path)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to read journal: %w", err)
+	}
+	if err := writeJournal(path, messages); err != nil {
+		return nil, nil, xerrors.Errorf("failed to compact journal: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to open journal: %w", err)
+	}
+	j := &MessageJournal{
+		file:      file,
+		persisted: make(map[int]ConversationMessage, len(messages)),
+	}
+	for _, msg := range messages {
+		j.persisted[msg.Id] = msg
+	}
+	return j, messages, nil
+}
+
+// readJournal reads the messages in the journal, keeping the last entry for
+// each message ID and renumbering the messages so IDs are contiguous.
+// A malformed last line, e.g. one left behind by a crash during a write, is ignored.
+func readJournal(path string) ([]ConversationMessage, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	byId := make(map[int]ConversationMessage)
+	scanner := bufio.NewScanner(file)
+	// Agent messages can be as large as the terminal screen.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var malformedLine int
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if malformedLine != 0 {
+			return nil, xerrors.Errorf("malformed entry on line %d", malformedLine)
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			malformedLine = lineNumber
+			continue
+		}
+		byId[entry.Id] = ConversationMessage{
+			Id:      entry.Id,
+			Role:    entry.Role,
+			Message: entry.Message,
+			Time:    entry.Time,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	messages := make([]ConversationMessage, 0, len(byId))
+	for _, msg := range byId {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, k int) bool {
+		return messages[i].Id < messages[k].Id
+	})
+	for i := range messages {
+		messages[i].Id = i
+	}
+	return messages, nil
+}
+
+// writeJournal atomically replaces the journal at path with the given messages.
+func writeJournal(path string, messages []ConversationMessage) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	writer := bufio.NewWriter(tmp)
+	for _, msg := range messages {
+		if err := writeJournalEntry(writer, msg); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeJournalEntry(writer io.Writer, msg ConversationMessage) error {
+	line, err := json.Marshal(journalEntry{
+		Id:      msg.Id,
+		Role:    msg.Role,
+		Message: msg.Message,
+		Time:    msg.Time,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(line, '\n'))
+	return err
+}
+
+// Sync appends the messages that changed since they were last persisted.
+// The last message is skipped if it's an agent message that may still change,
+// which is the case whenever the conversation isn't stable.
+func (j *MessageJournal) Sync(messages []ConversationMessage, stable bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return xerrors.New("journal is closed")
+	}
+	for i, msg := range messages {
+		isLast := i == len(messages)-1
+		if isLast && msg.Role == ConversationRoleAgent && !stable {
+			continue
+		}
+		if msg.Role == ConversationRoleAgent && msg.Message == "" {
+			continue
+		}
+		if persisted, ok := j.persisted[msg.Id]; ok && persisted == msg {
+			continue
+		}
+		if err := writeJournalEntry(j.file, msg); err != nil {
+			return xerrors.Errorf("failed to write message %d: %w", msg.Id, err)
+		}
+		j.persisted[msg.Id] = msg
+	}
+	return nil
+}
+
+// Close closes the journal file.
+func (j *MessageJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
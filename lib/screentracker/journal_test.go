@@ -0,0 +1,124 @@
+package screentracker_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageJournal(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := func(id int, role st.ConversationRole, message string) st.ConversationMessage {
+		return st.ConversationMessage{Id: id, Role: role, Message: message, Time: now}
+	}
+
+	t.Run("round-trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		journal, history, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		assert.Empty(t, history)
+
+		messages := []st.ConversationMessage{
+			msg(0, st.ConversationRoleAgent, "welcome"),
+			msg(1, st.ConversationRoleUser, "hi"),
+			msg(2, st.ConversationRoleAgent, "hel"),
+		}
+		require.NoError(t, journal.Sync(messages, false))
+		messages[2].Message = "hello"
+		require.NoError(t, journal.Sync(messages, true))
+		require.NoError(t, journal.Close())
+
+		_, history, err = st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		assert.Equal(t, messages, history)
+	})
+
+	t.Run("unstable-last-agent-message-is-skipped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		journal, _, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+
+		require.NoError(t, journal.Sync([]st.ConversationMessage{
+			msg(0, st.ConversationRoleAgent, "welcome"),
+			msg(1, st.ConversationRoleUser, "hi"),
+			msg(2, st.ConversationRoleAgent, "partial"),
+		}, false))
+		require.NoError(t, journal.Close())
+
+		_, history, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		assert.Equal(t, []st.ConversationMessage{
+			msg(0, st.ConversationRoleAgent, "welcome"),
+			msg(1, st.ConversationRoleUser, "hi"),
+		}, history)
+	})
+
+	t.Run("updates-are-compacted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		journal, _, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		for _, content := range []string{"a", "ab", "abc"} {
+			require.NoError(t, journal.Sync([]st.ConversationMessage{
+				msg(0, st.ConversationRoleAgent, content),
+			}, true))
+		}
+		require.NoError(t, journal.Close())
+
+		journal, _, err = st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		require.NoError(t, journal.Close())
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":0,"role":"agent","message":"abc","time":"2025-01-01T00:00:00Z"}`+"\n", string(data))
+	})
+
+	t.Run("ids-are-renumbered", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		journal, _, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		// empty agent messages are not persisted, which leaves a gap in the IDs
+		require.NoError(t, journal.Sync([]st.ConversationMessage{
+			msg(0, st.ConversationRoleAgent, ""),
+			msg(1, st.ConversationRoleUser, "hi"),
+			msg(2, st.ConversationRoleAgent, "hello"),
+		}, true))
+		require.NoError(t, journal.Close())
+
+		_, history, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		assert.Equal(t, []st.ConversationMessage{
+			msg(0, st.ConversationRoleUser, "hi"),
+			msg(1, st.ConversationRoleAgent, "hello"),
+		}, history)
+	})
+
+	t.Run("truncated-last-line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		require.NoError(t, os.WriteFile(path, []byte(
+			`{"id":0,"role":"user","message":"hi","time":"2025-01-01T00:00:00Z"}`+"\n"+
+				`{"id":1,"role":"agent","mess`,
+		), 0o600))
+
+		_, history, err := st.OpenMessageJournal(path)
+		require.NoError(t, err)
+		assert.Equal(t, []st.ConversationMessage{
+			msg(0, st.ConversationRoleUser, "hi"),
+		}, history)
+	})
+
+	t.Run("malformed-line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "messages.jsonl")
+		require.NoError(t, os.WriteFile(path, []byte(
+			"not json\n"+
+				`{"id":0,"role":"user","message":"hi","time":"2025-01-01T00:00:00Z"}`+"\n",
+		), 0o600))
+
+		_, _, err := st.OpenMessageJournal(path)
+		assert.ErrorContains(t, err, "malformed entry on line 1")
+	})
+}
@@ -0,0 +1,174 @@
+package termexec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+// outputFlushInterval is how often the output of the process is written to the
+// recording. Output written in the meantime is recorded as a single event, which
+// keeps the recording small since the output is read one rune at a time.
+const outputFlushInterval = 10 * time.Millisecond
+
+// Recorder records the output of processes and the input sent to them in the
+// asciicast v2 format of asciinema. It can be shared by the processes that are
+// started one after the other in the same session, e.g. when the agent is restarted.
+type Recorder struct {
+	mu     sync.Mutex
+	file   io.WriteCloser
+	w      *bufio.Writer
+	start  time.Time
+	width  uint16
+	height uint16
+	// pending is output that wasn't written to the recording yet, read at pendingAt.
+	pending   []byte
+	pendingAt time.Time
+	// err is the first error that occurred while writing. Nothing is recorded after it.
+	err    error
+	closed bool
+	done   chan struct{}
+}
+
+// NewRecorder creates a recording at path, overwriting the file if it exists.
+// width and height are the initial size of the terminal.
+func NewRecorder(path string, width uint16, height uint16) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create recording: %w", err)
+	}
+	r, err := newRecorder(file, width, height, time.Now())
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+func newRecorder(file io.WriteCloser, width uint16, height uint16, start time.Time) (*Recorder, error) {
+	r := &Recorder{
+		file:   file,
+		w:      bufio.NewWriter(file),
+		start:  start,
+		width:  width,
+		height: height,
+		done:   make(chan struct{}),
+	}
+	header, err := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     width,
+		"height":    height,
+		"timestamp": start.Unix(),
+		"env":       map[string]string{"TERM": "vt100"},
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal recording header: %w", err)
+	}
+	if _, err := r.w.Write(append(header, '\n')); err != nil {
+		return nil, xerrors.Errorf("failed to write recording header: %w", err)
+	}
+	return r, nil
+}
+
+func (r *Recorder) flushLoop() {
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.closed {
+				r.flushOutput()
+				if r.err == nil {
+					r.err = r.w.Flush()
+				}
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// writeEvent writes an event of the given type. Assumes the caller holds the lock.
+func (r *Recorder) writeEvent(at time.Time, eventType string, data string) {
+	if r.err != nil || r.closed {
+		return
+	}
+	// Times are in seconds, with microsecond precision.
+	elapsed := math.Round(at.Sub(r.start).Seconds()*1e6) / 1e6
+	event, err := json.Marshal([]any{elapsed, eventType, data})
+	if err != nil {
+		r.err = xerrors.Errorf("failed to marshal recording event: %w", err)
+		return
+	}
+	if _, err := r.w.Write(append(event, '\n')); err != nil {
+		r.err = xerrors.Errorf("failed to write recording event: %w", err)
+	}
+}
+
+// flushOutput writes the pending output as an event. Assumes the caller holds the lock.
+func (r *Recorder) flushOutput() {
+	if len(r.pending) == 0 {
+		return
+	}
+	r.writeEvent(r.pendingAt, "o", string(r.pending))
+	r.pending = r.pending[:0]
+}
+
+func (r *Recorder) output(c rune, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		r.pendingAt = at
+	}
+	r.pending = utf8.AppendRune(r.pending, c)
+}
+
+func (r *Recorder) input(data []byte, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The output read before the input must come first.
+	r.flushOutput()
+	r.writeEvent(at, "i", string(data))
+}
+
+// resize records that the terminal was resized. Nothing is recorded if the size didn't change.
+func (r *Recorder) resize(width uint16, height uint16, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if width == r.width && height == r.height {
+		return
+	}
+	r.flushOutput()
+	r.width, r.height = width, height
+	r.writeEvent(at, "r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close writes the pending output and closes the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.err
+	}
+	r.flushOutput()
+	r.closed = true
+	close(r.done)
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = xerrors.Errorf("failed to close recording: %w", err)
+	}
+	return r.err
+}
@@ -0,0 +1,105 @@
+package termexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	strings.Builder
+}
+
+func (w *nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	file := &nopWriteCloser{}
+	r, err := newRecorder(file, 80, 24, start)
+	require.NoError(t, err)
+
+	for _, c := range "$ é" {
+		r.output(c, start.Add(500*time.Millisecond))
+	}
+	r.input([]byte("ls\r"), start.Add(time.Second))
+	r.output('a', start.Add(1500*time.Millisecond))
+	r.resize(80, 24, start.Add(2*time.Second))
+	r.resize(100, 30, start.Add(2*time.Second))
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+
+	assert.Equal(t, `{"env":{"TERM":"vt100"},"height":24,"timestamp":1700000000,"version":2,"width":80}
+[0.5,"o","$ é"]
+[1,"i","ls\r"]
+[1.5,"o","a"]
+[2,"r","100x30"]
+`, file.String())
+}
+
+func TestProcessRecording(t *testing.T) {
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	path := filepath.Join(t.TempDir(), "agent.cast")
+	recorder, err := NewRecorder(path, 80, 24)
+	require.NoError(t, err)
+
+	process, err := StartProcess(ctx, StartProcessConfig{
+		Program:        "sh",
+		Args:           []string{"-c", "read line; echo got $line"},
+		TerminalWidth:  80,
+		TerminalHeight: 24,
+		Recorder:       recorder,
+	})
+	require.NoError(t, err)
+	_, err = process.Write([]byte("hi\r"))
+	require.NoError(t, err)
+	require.NoError(t, process.Wait())
+	// Wait for the output to be read before closing the recording.
+	require.Eventually(t, func() bool {
+		return strings.Contains(process.ReadScreen(), "got hi")
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, recorder.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+	scanner := bufio.NewScanner(file)
+	require.True(t, scanner.Scan())
+	var header struct {
+		Version int `json:"version"`
+		Width   int `json:"width"`
+		Height  int `json:"height"`
+	}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, 80, header.Width)
+	assert.Equal(t, 24, header.Height)
+
+	var input, output strings.Builder
+	for scanner.Scan() {
+		var event []any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		require.Len(t, event, 3)
+		switch event[1] {
+		case "i":
+			input.WriteString(event[2].(string))
+		case "o":
+			output.WriteString(event[2].(string))
+		}
+	}
+	assert.Equal(t, "hi\r", input.String())
+	assert.Contains(t, output.String(), "got hi")
+}
@@ -0,0 +1,95 @@
+package termexec
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/ActiveState/vt10x"
+)
+
+// scrollback keeps the most recent lines that scrolled off the top of the
+// terminal. Lines are numbered from the first line that ever scrolled off, so
+// callers can ask for the lines after one they've already seen.
+type scrollback struct {
+	// lines is a ring buffer of at most max lines. start is the index of the oldest line.
+	lines []string
+	start int
+	max   int
+	// total is the number of lines that ever scrolled off.
+	total int
+}
+
+func newScrollback(max int) *scrollback {
+	return &scrollback{max: max}
+}
+
+func (s *scrollback) add(line string) {
+	s.total++
+	if len(s.lines) < s.max {
+		s.lines = append(s.lines, line)
+		return
+	}
+	s.lines[s.start] = line
+	s.start = (s.start + 1) % s.max
+}
+
+// since returns the lines that are still kept, starting with line number from,
+// and the number of lines that ever scrolled off.
+func (s *scrollback) since(from int) ([]string, int) {
+	oldest := s.total - len(s.lines)
+	from = max(from, oldest)
+	if from >= s.total {
+		return nil, s.total
+	}
+	lines := make([]string, 0, s.total-from)
+	for i := from - oldest; i < len(s.lines); i++ {
+		lines = append(lines, s.lines[(s.start+i)%len(s.lines)])
+	}
+	return lines, s.total
+}
+
+// historyOffset is the offset of the unexported history field of vt10x.State,
+// which holds the lines that scrolled off the top of the screen when the state
+// records history. It's -1 if the field doesn't have the expected layout.
+//
+// Warning: This depends on vt10x internals, like vtGlyph.
+var historyOffset = func() uintptr {
+	field, ok := reflect.TypeOf(vt10x.State{}).FieldByName("history")
+	if !ok || !glyphLayoutMatches || field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Slice {
+		return ^uintptr(0)
+	}
+	if field.Type.Elem().Elem().Size() != reflect.TypeOf(vtGlyph{}).Size() {
+		return ^uintptr(0)
+	}
+	return field.Offset
+}()
+
+// scrollbackSupported reports whether lines can be moved out of the history of vt10x.
+func scrollbackSupported() bool {
+	return historyOffset != ^uintptr(0)
+}
+
+// drainHistory moves the lines vt10x recorded in its history to the scrollback,
+// so the history doesn't grow without bounds. Lines that scroll off while a
+// full-screen program uses the alternate screen aren't part of the output, and
+// are dropped. The caller must not hold the state's lock.
+func (s *scrollback) drainHistory(state *vt10x.State) {
+	state.Lock()
+	defer state.Unlock()
+
+	history := (*[][]vtGlyph)(unsafe.Add(unsafe.Pointer(state), historyOffset))
+	if len(*history) == 0 {
+		return
+	}
+	if !state.Mode(vt10x.ModeAltScreen) {
+		for _, line := range *history {
+			runes := make([]rune, len(line))
+			for i, glyph := range line {
+				runes[i] = glyph.c
+			}
+			s.add(string(runes))
+		}
+	}
+	clear(*history)
+	*history = (*history)[:0]
+}
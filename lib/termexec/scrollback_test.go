@@ -0,0 +1,60 @@
+package termexec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ActiveState/vt10x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrollback(t *testing.T) {
+	sb := newScrollback(3)
+	lines, total := sb.since(0)
+	assert.Empty(t, lines)
+	assert.Equal(t, 0, total)
+
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		sb.add(line)
+	}
+	lines, total = sb.since(0)
+	// Only the 3 most recent lines are kept.
+	assert.Equal(t, []string{"c", "d", "e"}, lines)
+	assert.Equal(t, 5, total)
+	lines, _ = sb.since(3)
+	assert.Equal(t, []string{"d", "e"}, lines)
+	lines, _ = sb.since(5)
+	assert.Empty(t, lines)
+}
+
+func TestDrainHistory(t *testing.T) {
+	require.True(t, scrollbackSupported(), "the history layout of vt10x changed")
+
+	state := &vt10x.State{RecordHistory: true}
+	term, err := vt10x.New(state, &bytes.Buffer{}, &bytes.Buffer{})
+	require.NoError(t, err)
+	term.Resize(5, 2)
+	sb := newScrollback(100)
+	write := func(output string) {
+		for _, r := range output {
+			term.WriteRune(r)
+			sb.drainHistory(state)
+		}
+	}
+
+	write("1\r\n2\r\n3\r\n4")
+	lines, total := sb.since(0)
+	assert.Equal(t, []string{"1    ", "2    "}, lines)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, "3    \n4    \n", state.String())
+
+	// Lines that scroll off the alternate screen are dropped.
+	write("\x1b[?1049hx\r\ny\r\nz")
+	lines, _ = sb.since(0)
+	assert.Equal(t, []string{"1    ", "2    "}, lines)
+
+	write("\x1b[?1049l\r\n5\r\n")
+	lines, _ = sb.since(2)
+	assert.Equal(t, []string{"3    ", "4    "}, lines)
+}
@@ -0,0 +1,231 @@
+package termexec
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/ActiveState/vt10x"
+)
+
+// Color is an index into the 256-color xterm palette. Colors 0-15 are the
+// ANSI colors, with 8-15 being their bright variants.
+type Color int16
+
+// ColorDefault is the terminal's default foreground or background color.
+const ColorDefault Color = -1
+
+// Style is the color and text attributes of a cell on the screen.
+type Style struct {
+	Fg        Color `json:"fg" doc:"Foreground color as an index into the 256-color xterm palette, or -1 for the default color."`
+	Bg        Color `json:"bg" doc:"Background color as an index into the 256-color xterm palette, or -1 for the default color."`
+	Bold      bool  `json:"bold,omitempty"`
+	Italic    bool  `json:"italic,omitempty"`
+	Underline bool  `json:"underline,omitempty"`
+	Blink     bool  `json:"blink,omitempty"`
+	Inverse   bool  `json:"inverse,omitempty" doc:"Whether the foreground and background colors are swapped when the text is displayed."`
+}
+
+// DefaultStyle is the style of cells the agent didn't style.
+var DefaultStyle = Style{Fg: ColorDefault, Bg: ColorDefault}
+
+// StyledRun is a sequence of adjacent cells in a row that share the same style.
+type StyledRun struct {
+	Text string `json:"text"`
+	Style
+}
+
+// vtGlyph mirrors the unexported glyph type of vt10x, which holds the
+// attributes vt10x doesn't expose.
+//
+// Warning: This depends on vt10x internals. glyphLayoutMatches guards against
+// the layout changing.
+type vtGlyph struct {
+	c      rune
+	mode   int16
+	fg, bg vt10x.Color
+}
+
+// The bits of vtGlyph.mode, as defined by vt10x.
+const (
+	vtAttrReverse = 1 << iota
+	vtAttrUnderline
+	vtAttrBold
+	vtAttrGfx
+	vtAttrItalic
+	vtAttrBlink
+)
+
+var glyphLayoutMatches = func() bool {
+	field, ok := reflect.TypeOf(vt10x.State{}).FieldByName("lines")
+	if !ok || field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Slice {
+		return false
+	}
+	glyph := field.Type.Elem().Elem()
+	mirror := reflect.TypeOf(vtGlyph{})
+	if glyph.Kind() != reflect.Struct || glyph.Size() != mirror.Size() || glyph.NumField() != mirror.NumField() {
+		return false
+	}
+	for i := range glyph.NumField() {
+		if glyph.Field(i).Type.Kind() != mirror.Field(i).Type.Kind() || glyph.Field(i).Offset != mirror.Field(i).Offset {
+			return false
+		}
+	}
+	return true
+}()
+
+func convertColor(c vt10x.Color) Color {
+	if c >= vt10x.DefaultFG {
+		return ColorDefault
+	}
+	return Color(c)
+}
+
+// styledRows returns the rows of the screen as runs of styled text.
+// Trailing blank cells of a row and trailing empty rows are left out.
+func styledRows(state *vt10x.State) [][]StyledRun {
+	state.Lock()
+	defer state.Unlock()
+
+	rowCount, cols := state.Size()
+	var lines [][]vtGlyph
+	if glyphLayoutMatches {
+		field := reflect.ValueOf(state).Elem().FieldByName("lines")
+		lines = *(*[][]vtGlyph)(unsafe.Pointer(field.UnsafeAddr()))
+	}
+
+	rows := make([][]StyledRun, 0, rowCount)
+	for y := range rowCount {
+		var runs []StyledRun
+		var text strings.Builder
+		style := DefaultStyle
+		flush := func() {
+			if text.Len() > 0 {
+				runs = append(runs, StyledRun{Text: text.String(), Style: style})
+				text.Reset()
+			}
+		}
+		for x := range cols {
+			c, fg, bg := state.Cell(x, y)
+			cellStyle := Style{Fg: convertColor(fg), Bg: convertColor(bg)}
+			if lines != nil {
+				mode := lines[y][x].mode
+				cellStyle.Bold = mode&vtAttrBold != 0
+				cellStyle.Italic = mode&vtAttrItalic != 0
+				cellStyle.Underline = mode&vtAttrUnderline != 0
+				cellStyle.Blink = mode&vtAttrBlink != 0
+				if mode&vtAttrReverse != 0 {
+					// vt10x swaps the colors of inverse cells when they're written.
+					cellStyle.Inverse = true
+					cellStyle.Fg, cellStyle.Bg = cellStyle.Bg, cellStyle.Fg
+				}
+			}
+			if c == 0 {
+				c = ' '
+			}
+			if cellStyle != style {
+				flush()
+				style = cellStyle
+			}
+			text.WriteRune(c)
+		}
+		flush()
+		rows = append(rows, trimBlankCells(runs))
+	}
+	for len(rows) > 0 && len(rows[len(rows)-1]) == 0 {
+		rows = rows[:len(rows)-1]
+	}
+	return rows
+}
+
+// trimBlankCells removes the trailing spaces of a row that are displayed
+// with the default background.
+func trimBlankCells(runs []StyledRun) []StyledRun {
+	for len(runs) > 0 {
+		last := &runs[len(runs)-1]
+		if last.Bg != ColorDefault || last.Inverse || last.Underline {
+			break
+		}
+		last.Text = strings.TrimRight(last.Text, " ")
+		if last.Text != "" {
+			break
+		}
+		runs = runs[:len(runs)-1]
+	}
+	return runs
+}
+
+// ReadStyledScreen returns the contents of the terminal window with the colors
+// and attributes of the text, as a list of rows. It waits for the screen to be
+// stable like ReadScreen.
+func (p *Process) ReadStyledScreen() [][]StyledRun {
+	p.waitForStableScreen()
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	return styledRows(p.xp.State)
+}
+
+// sgr returns the Select Graphic Rendition escape sequence that sets the style.
+func (s Style) sgr() string {
+	params := []string{"0"}
+	for _, attr := range []struct {
+		set   bool
+		param string
+	}{
+		{s.Bold, "1"},
+		{s.Italic, "3"},
+		{s.Underline, "4"},
+		{s.Blink, "5"},
+		{s.Inverse, "7"},
+	} {
+		if attr.set {
+			params = append(params, attr.param)
+		}
+	}
+	if param := colorParam(s.Fg, 30, 90, 38); param != "" {
+		params = append(params, param)
+	}
+	if param := colorParam(s.Bg, 40, 100, 48); param != "" {
+		params = append(params, param)
+	}
+	return fmt.Sprintf("\x1b[%sm", strings.Join(params, ";"))
+}
+
+func colorParam(c Color, base int, brightBase int, extended int) string {
+	switch {
+	case c == ColorDefault:
+		return ""
+	case c < 8:
+		return strconv.Itoa(base + int(c))
+	case c < 16:
+		return strconv.Itoa(brightBase + int(c) - 8)
+	default:
+		return fmt.Sprintf("%d;5;%d", extended, c)
+	}
+}
+
+// EncodeANSI renders styled rows as text with ANSI escape sequences, one line
+// per row. Every line that changes the style ends with a reset, so lines can
+// be displayed on their own.
+func EncodeANSI(rows [][]StyledRun) string {
+	var b strings.Builder
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		style := DefaultStyle
+		for _, run := range row {
+			if run.Style != style {
+				b.WriteString(run.Style.sgr())
+				style = run.Style
+			}
+			b.WriteString(run.Text)
+		}
+		if style != DefaultStyle {
+			b.WriteString("\x1b[0m")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package termexec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ActiveState/vt10x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestState(t *testing.T, output string) *vt10x.State {
+	t.Helper()
+	state := &vt10x.State{}
+	term, err := vt10x.New(state, &bytes.Buffer{}, &bytes.Buffer{})
+	require.NoError(t, err)
+	for _, r := range output {
+		term.WriteRune(r)
+	}
+	return state
+}
+
+func TestStyledRows(t *testing.T) {
+	require.True(t, glyphLayoutMatches, "the glyph layout of vt10x changed")
+
+	state := newTestState(t, "plain \x1b[1;31mbold red\x1b[0m \x1b[4;38;5;208munderlined\x1b[0m\r\n\x1b[7minverse\x1b[0m\x1b[42m  \x1b[0m   \r\n\r\n")
+	rows := styledRows(state)
+	assert.Equal(t, [][]StyledRun{
+		{
+			{Text: "plain ", Style: DefaultStyle},
+			// vt10x displays bold text in the bright variant of its color.
+			{Text: "bold red", Style: Style{Fg: 9, Bg: ColorDefault, Bold: true}},
+			{Text: " ", Style: DefaultStyle},
+			{Text: "underlined", Style: Style{Fg: 208, Bg: ColorDefault, Underline: true}},
+		},
+		{
+			{Text: "inverse", Style: Style{Fg: ColorDefault, Bg: ColorDefault, Inverse: true}},
+			{Text: "  ", Style: Style{Fg: ColorDefault, Bg: 2}},
+		},
+	}, rows)
+
+	assert.Equal(t,
+		"plain \x1b[0;1;91mbold red\x1b[0m \x1b[0;4;38;5;208munderlined\x1b[0m\n"+
+			"\x1b[0;7minverse\x1b[0;42m  \x1b[0m",
+		EncodeANSI(rows))
+}
+
+func TestStyledRows_Empty(t *testing.T) {
+	assert.Empty(t, styledRows(newTestState(t, "")))
+	assert.Equal(t, "", EncodeANSI(nil))
+}
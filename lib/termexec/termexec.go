@@ -2,13 +2,11 @@ package termexec
 
 import (
 	"context"
-	"errors"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/ActiveState/termtest/xpty"
@@ -22,6 +20,18 @@ type Process struct {
 	execCmd          *exec.Cmd
 	screenUpdateLock sync.RWMutex
 	lastScreenUpdate time.Time
+	// scrollback keeps the lines that scrolled off the top of the screen.
+	// It's nil if the scrollback is disabled.
+	scrollback *scrollback
+	// recorder records the output and input of the process. It's nil if the
+	// process isn't recorded.
+	recorder *Recorder
+	// readerDone is closed when the goroutine that reads the process output exits.
+	readerDone chan struct{}
+	// exited is closed when the process exits. exitState and waitErr are set before.
+	exited    chan struct{}
+	exitState *os.ProcessState
+	waitErr   error
 }
 
 type StartProcessConfig struct {
@@ -29,11 +39,25 @@ type StartProcessConfig struct {
 	Args           []string
 	TerminalWidth  uint16
 	TerminalHeight uint16
+	// ScrollbackLines is the number of lines that scrolled off the top of the
+	// terminal to keep. The scrollback is disabled if it's 0.
+	ScrollbackLines int
+	// Recorder, if set, records the output of the process and the input sent to it.
+	// It isn't closed when the process is closed.
+	Recorder *Recorder
 }
 
 func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error) {
 	logger := logctx.From(ctx)
-	xp, err := xpty.New(args.TerminalWidth, args.TerminalHeight, false)
+	var sb *scrollback
+	if args.ScrollbackLines > 0 {
+		if scrollbackSupported() {
+			sb = newScrollback(args.ScrollbackLines)
+		} else {
+			logger.Warn("The scrollback isn't supported by this version of vt10x, lines that scroll off the screen are lost")
+		}
+	}
+	xp, err := xpty.New(args.TerminalWidth, args.TerminalHeight, sb != nil)
 	if err != nil {
 		return nil, err
 	}
@@ -46,9 +70,26 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 		return nil, err
 	}
 
-	process := &Process{xp: xp, execCmd: execCmd}
+	process := &Process{
+		xp:         xp,
+		execCmd:    execCmd,
+		scrollback: sb,
+		recorder:   args.Recorder,
+		readerDone: make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+
+	if process.recorder != nil {
+		process.recorder.resize(args.TerminalWidth, args.TerminalHeight, time.Now())
+	}
 
 	go func() {
+		defer close(process.exited)
+		process.exitState, process.waitErr = execCmd.Process.Wait()
+	}()
+
+	go func() {
+		defer close(process.readerDone)
 		// HACK: Working around xpty concurrency limitations
 		//
 		// Problem:
@@ -86,10 +127,16 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 				// unresponsive.
 				return
 			}
+			if process.recorder != nil {
+				process.recorder.output(r, time.Now())
+			}
 			process.screenUpdateLock.Lock()
 			// writing to the terminal updates its state. without it,
 			// xp.State will always return an empty string
 			xp.Term.WriteRune(r)
+			if process.scrollback != nil {
+				process.scrollback.drainHistory(xp.State)
+			}
 			process.lastScreenUpdate = time.Now()
 			process.screenUpdateLock.Unlock()
 		}
@@ -98,6 +145,44 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 	return process, nil
 }
 
+// ReaderAlive reports whether the process output is still being read into the terminal.
+// If it isn't, the screen is no longer updated.
+func (p *Process) ReaderAlive() bool {
+	select {
+	case <-p.readerDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// LastScreenUpdate returns the time the process last wrote to the terminal.
+// It's zero if the process hasn't written anything yet.
+func (p *Process) LastScreenUpdate() time.Time {
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	return p.lastScreenUpdate
+}
+
+// Exited returns a channel that's closed when the process exits.
+func (p *Process) Exited() <-chan struct{} {
+	return p.exited
+}
+
+// ExitCode returns the exit code of the process, or false if it's still running.
+// The exit code is -1 if the process was terminated by a signal.
+func (p *Process) ExitCode() (int, bool) {
+	select {
+	case <-p.exited:
+	default:
+		return 0, false
+	}
+	if p.exitState == nil {
+		return -1, true
+	}
+	return p.exitState.ExitCode(), true
+}
+
 func (p *Process) Signal(sig os.Signal) error {
 	return p.execCmd.Process.Signal(sig)
 }
@@ -112,39 +197,86 @@ func (p *Process) Signal(sig os.Signal) error {
 // result in a malformed agent message being returned to the
 // user.
 func (p *Process) ReadScreen() string {
+	p.waitForStableScreen()
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	return p.xp.State.String()
+}
+
+// ReadScreenWithScrollback returns the contents of the terminal window like ReadScreen,
+// together with the lines that scrolled off the top of the window, starting with
+// line number from. Lines are numbered from the first line that ever scrolled off,
+// and total is the number of lines that scrolled off so far. Only the most recent
+// lines are kept, so lines may be missing if from is too old.
+func (p *Process) ReadScreenWithScrollback(from int) (screen string, scrollback []string, total int) {
+	p.waitForStableScreen()
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	screen = p.xp.State.String()
+	if p.scrollback == nil {
+		return screen, nil, 0
+	}
+	scrollback, total = p.scrollback.since(from)
+	return screen, scrollback, total
+}
+
+// waitForStableScreen waits until the terminal wasn't updated for 16ms,
+// or for 48ms, whichever is sooner.
+func (p *Process) waitForStableScreen() {
 	for range 3 {
-		p.screenUpdateLock.RLock()
-		if time.Since(p.lastScreenUpdate) >= 16*time.Millisecond {
-			state := p.xp.State.String()
-			p.screenUpdateLock.RUnlock()
-			return state
+		if time.Since(p.LastScreenUpdate()) >= 16*time.Millisecond {
+			return
 		}
-		p.screenUpdateLock.RUnlock()
 		time.Sleep(16 * time.Millisecond)
 	}
-	return p.xp.State.String()
 }
 
 // Write sends input to the process via the pseudo terminal.
 func (p *Process) Write(data []byte) (int, error) {
-	return p.xp.TerminalInPipe().Write(data)
+	n, err := p.xp.TerminalInPipe().Write(data)
+	if p.recorder != nil && n > 0 {
+		p.recorder.input(data[:n], time.Now())
+	}
+	return n, err
+}
+
+// Size returns the width and height of the terminal window.
+func (p *Process) Size() (uint16, uint16) {
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	rows, cols := p.xp.State.Size()
+	return uint16(cols), uint16(rows)
+}
+
+// Resize changes the size of the terminal window. The process is sent SIGWINCH
+// by the kernel, which makes most programs redraw the screen.
+func (p *Process) Resize(width uint16, height uint16) error {
+	p.screenUpdateLock.Lock()
+	defer p.screenUpdateLock.Unlock()
+	if err := p.xp.Resize(width, height); err != nil {
+		return xerrors.Errorf("failed to resize pseudo terminal: %w", err)
+	}
+	// The screen changed, so it must not be read as stable right away.
+	p.lastScreenUpdate = time.Now()
+	if p.recorder != nil {
+		p.recorder.resize(width, height, p.lastScreenUpdate)
+	}
+	return nil
 }
 
 // Close closes the process using a SIGINT signal or forcefully killing it if the process
 // does not exit after the timeout. It then closes the pseudo terminal.
 func (p *Process) Close(logger *slog.Logger, timeout time.Duration) error {
 	logger.Info("Closing process")
-	if err := p.execCmd.Process.Signal(os.Interrupt); err != nil {
-		return xerrors.Errorf("failed to send SIGINT to process: %w", err)
+	select {
+	case <-p.exited:
+		// The process has already exited, e.g. because it crashed.
+	default:
+		if err := p.execCmd.Process.Signal(os.Interrupt); err != nil {
+			return xerrors.Errorf("failed to send SIGINT to process: %w", err)
+		}
 	}
 
-	exited := make(chan error, 1)
-	go func() {
-		_, err := p.execCmd.Process.Wait()
-		exited <- err
-		close(exited)
-	}()
-
 	var exitErr error
 	select {
 	case <-time.After(timeout):
@@ -153,11 +285,9 @@ func (p *Process) Close(logger *slog.Logger, timeout time.Duration) error {
 		}
 		// don't wait for the process to exit to avoid hanging indefinitely
 		// if the process never exits
-	case err := <-exited:
-		var pathErr *os.SyscallError
-		// ECHILD is expected if the process has already exited
-		if err != nil && !(errors.As(err, &pathErr) && pathErr.Err == syscall.ECHILD) {
-			exitErr = xerrors.Errorf("process exited with error: %w", err)
+	case <-p.exited:
+		if p.waitErr != nil {
+			exitErr = xerrors.Errorf("process exited with error: %w", p.waitErr)
 		}
 	}
 	if err := p.xp.Close(); err != nil {
@@ -170,11 +300,11 @@ var ErrNonZeroExitCode = xerrors.New("non-zero exit code")
 
 // Wait waits for the process to exit.
 func (p *Process) Wait() error {
-	state, err := p.execCmd.Process.Wait()
-	if err != nil {
-		return xerrors.Errorf("process exited with error: %w", err)
+	<-p.exited
+	if p.waitErr != nil {
+		return xerrors.Errorf("process exited with error: %w", p.waitErr)
 	}
-	if state.ExitCode() != 0 {
+	if p.exitState.ExitCode() != 0 {
 		return ErrNonZeroExitCode
 	}
 	return nil
@@ -0,0 +1,72 @@
+package termexec
+
+import (
+	"io"
+	"strings"
+
+	"github.com/ActiveState/vt10x"
+	"golang.org/x/xerrors"
+)
+
+// VirtualTerminal is a terminal emulator that isn't attached to a process.
+// Output is written to it directly, e.g. to replay a recording of a process.
+// It emulates the same terminal as the pseudo terminal of a Process.
+type VirtualTerminal struct {
+	term       *vt10x.VT
+	state      *vt10x.State
+	scrollback *scrollback
+}
+
+// NewVirtualTerminal creates a terminal of the given size. scrollbackLines is
+// the number of lines that scrolled off the top of the terminal to keep, like
+// StartProcessConfig.ScrollbackLines.
+func NewVirtualTerminal(width uint16, height uint16, scrollbackLines int) (*VirtualTerminal, error) {
+	var sb *scrollback
+	if scrollbackLines > 0 && scrollbackSupported() {
+		sb = newScrollback(scrollbackLines)
+	}
+	state := &vt10x.State{RecordHistory: sb != nil}
+	// Replies of the terminal to the program, e.g. to cursor position requests, are discarded.
+	term, err := vt10x.New(state, strings.NewReader(""), io.Discard)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create terminal: %w", err)
+	}
+	term.Resize(int(width), int(height))
+	return &VirtualTerminal{term: term, state: state, scrollback: sb}, nil
+}
+
+// WriteOutput writes the output of a program to the terminal.
+func (t *VirtualTerminal) WriteOutput(data []byte) {
+	for _, r := range string(data) {
+		t.term.WriteRune(r)
+		if t.scrollback != nil {
+			t.scrollback.drainHistory(t.state)
+		}
+	}
+}
+
+// Resize changes the size of the terminal.
+func (t *VirtualTerminal) Resize(width uint16, height uint16) {
+	t.term.Resize(int(width), int(height))
+}
+
+// Write discards input sent to the program, since there is none. It's there so
+// the terminal can stand in for a Process.
+func (t *VirtualTerminal) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// ReadScreen returns the contents of the terminal window.
+func (t *VirtualTerminal) ReadScreen() string {
+	return t.state.String()
+}
+
+// ReadScreenWithScrollback is like Process.ReadScreenWithScrollback.
+func (t *VirtualTerminal) ReadScreenWithScrollback(from int) (screen string, scrollback []string, total int) {
+	screen = t.state.String()
+	if t.scrollback == nil {
+		return screen, nil, 0
+	}
+	scrollback, total = t.scrollback.since(from)
+	return screen, scrollback, total
+}